@@ -164,7 +164,10 @@ function pwaServiceWorkerPlugin(): Plugin {
     apply: "build",
     async generateBundle(_outputOptions, bundle) {
       const generatedAssets = Object.keys(bundle)
-        .filter((fileName) => !fileName.endsWith(".map"))
+        .filter(
+          (fileName) =>
+            !fileName.endsWith(".map") && !fileName.startsWith(".vite/"),
+        )
         .map((fileName) => `/${fileName}`);
       const precacheUrls = [
         ...new Set([...PWA_CORE_ASSETS, ...generatedAssets]),
@@ -275,6 +278,9 @@ export default defineConfig({
   },
   build: {
     chunkSizeWarningLimit: 999999,
+    // Every chunk is named index.[hash].js, so the releaser reads
+    // .vite/manifest.json to tell chunks apart between releases.
+    manifest: true,
     rollupOptions: {
       output: {
         manualChunks: undefined,
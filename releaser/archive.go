@@ -0,0 +1,333 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+)
+
+// A release archive holds release.json first, then a copy of version.yaml,
+// then every planned file under dist/.
+const (
+	releaseArchiveFormat   = 1
+	releaseArchiveMetadata = "release.json"
+	releaseArchiveDistDir  = "dist"
+)
+
+// releaseArchiveInfo is release.json: the plan, with DistDir relative to the
+// archive root, and when the archive was made.
+type releaseArchiveInfo struct {
+	Format    int         `json:"format"`
+	CreatedAt string      `json:"createdAt"`
+	Plan      releasePlan `json:"plan"`
+}
+
+func newBuildCmd() *cobra.Command {
+	cfg := config{}
+	var (
+		archivePath string
+		prune       bool
+	)
+	cmd := &cobra.Command{
+		Use:   "build --web=<branch> --archive=<release.tar.zst>",
+		Short: "Build a release into a self-describing archive for offline publishing",
+		Long: `Resolve inputs, build, and run every release check like plan, then write
+the planned files, version.yaml, and the plan into a single archive.
+Publish it with "releaser publish --archive=<path>" on a machine that has
+bucket credentials but no git or pnpm.
+
+The compression follows the extension: .tar.zst, .tar.gz or .tgz, or .tar.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if _, err := archiveCompression(archivePath); err != nil {
+				return err
+			}
+			ctx, finishTrace := startTrace(cmd.Context(), cfg.trace, "build")
+			defer func() { finishTrace(err) }()
+			cfg.bucketSet = cmd.Flags().Changed("bucket")
+			plan, current, err := planRelease(ctx, cfg, prune)
+			if err != nil || current {
+				return err
+			}
+			if err := writeReleaseArchive(ctx, archivePath, plan); err != nil {
+				plan.worktree.keep("for debugging")
+				return fmt.Errorf("write archive: %w", err)
+			}
+			plan.worktree.remove(ctx)
+			printReleasePlan(plan)
+			fmt.Printf("archive written to %s; publish it with: releaser publish --archive=%s\n", archivePath, archivePath)
+			return nil
+		},
+	}
+	addPlanFlags(cmd, &cfg, &prune, "build")
+	cmd.Flags().StringVar(&archivePath, "archive", "", "path to write the release archive to")
+	_ = cmd.MarkFlagRequired("archive")
+	return cmd
+}
+
+func newPublishCmd() *cobra.Command {
+	var (
+		archivePath string
+		publisher   string
+		configPath  string
+		tmpBase     string
+		trace       traceOptions
+	)
+	cmd := &cobra.Command{
+		Use:   "publish --archive=<release.tar.zst>",
+		Short: "Publish a release archive made by build",
+		Long: `Unpack a release archive made by "releaser build" and publish it like
+"releaser apply", with the same checks: the bucket's version.yaml must be
+unchanged since the archive was built, and every file must match its digest.
+Only bucket credentials are needed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			ctx, finishTrace := startTrace(cmd.Context(), trace, "publish")
+			defer func() { finishTrace(err) }()
+
+			dir, err := os.MkdirTemp(tmpBase, "release-archive-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			plan, err := readReleaseArchive(ctx, archivePath, dir)
+			if err != nil {
+				return fmt.Errorf("read archive: %w", err)
+			}
+			return applyReleasePlanAndNotify(ctx, plan, publisher, configPath)
+		},
+	}
+	cmd.Flags().StringVar(&archivePath, "archive", "", "release archive made by build")
+	cmd.Flags().StringVar(&publisher, "publisher", "", "publisher identity recorded in the release history (defaults to the CI run or local user)")
+	cmd.Flags().StringVar(&configPath, "config", "", "releaser config file (notifications)")
+	cmd.Flags().StringVar(&tmpBase, "tmpdir", os.TempDir(), "base temporary directory to unpack the archive in")
+	addTraceFlags(cmd, &trace)
+	_ = cmd.MarkFlagRequired("archive")
+	return cmd
+}
+
+// writeReleaseArchive stores plan and its files at archivePath.
+func writeReleaseArchive(ctx context.Context, archivePath string, plan releasePlan) (err error) {
+	compression, err := archiveCompression(archivePath)
+	if err != nil {
+		return err
+	}
+	info := releaseArchiveInfo{Format: releaseArchiveFormat, CreatedAt: time.Now().UTC().Format(time.RFC3339), Plan: plan}
+	info.Plan.DistDir = releaseArchiveDistDir
+	metadata, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	w, err := compressWriter(compression, f)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = w.Close()
+		}
+	}()
+	tw := tar.NewWriter(w)
+
+	if err := writeTarFile(tw, releaseArchiveMetadata, append(metadata, '\n')); err != nil {
+		return err
+	}
+	version, err := os.ReadFile(filepath.Join(plan.DistDir, versionFileName))
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, versionFileName, version); err != nil {
+		return err
+	}
+	for _, upload := range plan.Uploads {
+		content, err := os.ReadFile(filepath.Join(plan.DistDir, filepath.FromSlash(upload.Path)))
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, path.Join(releaseArchiveDistDir, upload.Path), content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(content)),
+		ModTime: time.Unix(0, 0),
+		Format:  tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+// readReleaseArchive unpacks archivePath into dir and returns its plan with
+// DistDir pointing at the unpacked files. The files are not checked here;
+// applyReleasePlan verifies each against its planned digest.
+func readReleaseArchive(ctx context.Context, archivePath, dir string) (releasePlan, error) {
+	compression, err := archiveCompression(archivePath)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	defer f.Close()
+	r, err := decompressReader(compression, f)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	var info *releaseArchiveInfo
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return releasePlan{}, err
+		}
+		if info == nil {
+			if header.Name != releaseArchiveMetadata {
+				return releasePlan{}, fmt.Errorf("not a release archive: first entry is %s, not %s", header.Name, releaseArchiveMetadata)
+			}
+			info = &releaseArchiveInfo{}
+			if err := json.NewDecoder(tr).Decode(info); err != nil {
+				return releasePlan{}, fmt.Errorf("parse %s: %w", releaseArchiveMetadata, err)
+			}
+			if info.Format != releaseArchiveFormat {
+				return releasePlan{}, fmt.Errorf("unsupported archive format %d", info.Format)
+			}
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return releasePlan{}, fmt.Errorf("unexpected archive entry %s", header.Name)
+		}
+		dst, err := archiveEntryPath(dir, header.Name)
+		if err != nil {
+			return releasePlan{}, err
+		}
+		if err := extractTarFile(tr, dst); err != nil {
+			return releasePlan{}, err
+		}
+	}
+	if info == nil {
+		return releasePlan{}, errors.New("empty archive")
+	}
+	if err := r.Close(); err != nil {
+		return releasePlan{}, err
+	}
+
+	plan := info.Plan
+	if plan.Bucket == "" {
+		return releasePlan{}, errors.New("archive plan has no bucket")
+	}
+	plan.DistDir = filepath.Join(dir, releaseArchiveDistDir)
+	return plan, nil
+}
+
+// archiveEntryPath maps an entry name into dir, refusing names that would
+// land outside it.
+func archiveEntryPath(dir, name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(name, `\`) {
+		return "", fmt.Errorf("unsafe archive entry %q", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+func extractTarFile(r io.Reader, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type archiveCompressionKind int
+
+const (
+	archiveNone archiveCompressionKind = iota
+	archiveGzip
+	archiveZstd
+)
+
+func archiveCompression(name string) (archiveCompressionKind, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.zst"):
+		return archiveZstd, nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveGzip, nil
+	case strings.HasSuffix(name, ".tar"):
+		return archiveNone, nil
+	}
+	return 0, fmt.Errorf("archive %q must end in .tar.zst, .tar.gz, .tgz, or .tar", name)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func compressWriter(kind archiveCompressionKind, w io.Writer) (io.WriteCloser, error) {
+	switch kind {
+	case archiveGzip:
+		return gzip.NewWriter(w), nil
+	case archiveZstd:
+		return zstd.NewWriter(w)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+func decompressReader(kind archiveCompressionKind, r io.Reader) (io.ReadCloser, error) {
+	switch kind {
+	case archiveGzip:
+		return gzip.NewReader(r)
+	case archiveZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
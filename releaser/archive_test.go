@@ -0,0 +1,91 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReleaseArchiveRoundTrip(t *testing.T) {
+	for _, ext := range []string{".tar.gz", ".tar.zst"} {
+		t.Run(ext, func(t *testing.T) {
+			ctx := context.Background()
+			bucket := t.TempDir()
+			dist := writeDist(t, map[string]string{
+				"index.html":        "<html>v1</html>",
+				"assets/index.a.js": "console.log(1)",
+				versionFileName:     "webRepo: runmedev/web\nwebCommit: 1111111111\n",
+			})
+			build, err := finalizeLocalDist(dist, hardeningConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan, err := makeReleasePlan(bucket, build, nil, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			archivePath := filepath.Join(t.TempDir(), "release"+ext)
+			if err := writeReleaseArchive(ctx, archivePath, plan); err != nil {
+				t.Fatal(err)
+			}
+			// Publishing must not depend on the build output still existing.
+			if err := os.RemoveAll(dist); err != nil {
+				t.Fatal(err)
+			}
+
+			unpacked, err := readReleaseArchive(ctx, archivePath, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unpacked.Version.WebCommit != "1111111111" || len(unpacked.Uploads) != len(plan.Uploads) {
+				t.Fatalf("unpacked plan = %+v", unpacked)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(unpacked.DistDir), versionFileName)); err != nil {
+				t.Fatalf("archive has no top-level %s: %v", versionFileName, err)
+			}
+			if err := applyReleasePlan(ctx, unpacked, "tester"); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(bucket, "assets", "index.a.js"))
+			if err != nil || string(content) != "console.log(1)" {
+				t.Fatalf("published asset = %q, %v", content, err)
+			}
+		})
+	}
+}
+
+func TestReadReleaseArchiveRejectsUnsafeEntries(t *testing.T) {
+	for name, entries := range map[string][]string{
+		"no metadata": {"dist/index.html"},
+		"traversal":   {releaseArchiveMetadata, "../escape"},
+		"absolute":    {releaseArchiveMetadata, "/etc/passwd"},
+	} {
+		archivePath := filepath.Join(t.TempDir(), "bad.tar")
+		f, err := os.Create(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw := tar.NewWriter(f)
+		for _, entry := range entries {
+			content := []byte("x")
+			if entry == releaseArchiveMetadata {
+				content = []byte(`{"format":1,"plan":{"bucket":"b"}}`)
+			}
+			if err := writeTarFile(tw, entry, content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = tw.Close()
+		_ = f.Close()
+
+		if _, err := readReleaseArchive(context.Background(), archivePath, t.TempDir()); err == nil {
+			t.Errorf("%s: archive accepted", name)
+		} else if name != "no metadata" && !strings.Contains(err.Error(), "unsafe") {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
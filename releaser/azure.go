@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const azureStorageAPIVersion = "2021-08-06"
+
+// azureLocation is a parsed az://container/prefix destination. The storage
+// account and an optional endpoint override (for example Azurite) are query
+// parameters or environment variables:
+//
+//	az://container/prefix?account=devstoreaccount1&endpoint=http://127.0.0.1:10000/devstoreaccount1
+type azureLocation struct {
+	account   string
+	container string
+	prefix    string
+	endpoint  *url.URL
+}
+
+// azureCredentials holds either a SAS token or a shared account key.
+type azureCredentials struct {
+	sasToken   string
+	accountKey []byte
+}
+
+func isAzureBucket(bucket string) bool {
+	return strings.HasPrefix(bucket, "az://")
+}
+
+func parseAzureLocation(raw string) (azureLocation, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return azureLocation{}, err
+	}
+	if u.Host == "" {
+		return azureLocation{}, fmt.Errorf("az destination %q has no container", raw)
+	}
+	query := u.Query()
+
+	loc := azureLocation{
+		account:   firstNonEmpty(query.Get("account"), os.Getenv("AZURE_STORAGE_ACCOUNT")),
+		container: u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+	}
+	if loc.account == "" {
+		return azureLocation{}, errors.New("az destinations need ?account= or AZURE_STORAGE_ACCOUNT")
+	}
+	endpoint := firstNonEmpty(query.Get("endpoint"), os.Getenv("AZURE_STORAGE_BLOB_ENDPOINT"), "https://"+loc.account+".blob.core.windows.net")
+	if loc.endpoint, err = url.Parse(endpoint); err != nil {
+		return azureLocation{}, fmt.Errorf("az endpoint %q: %w", endpoint, err)
+	}
+	return loc, nil
+}
+
+func (loc azureLocation) displayURL(rel string) string {
+	return "az://" + loc.container + "/" + joinObjectKey(loc.prefix, rel)
+}
+
+func (loc azureLocation) blobURL(rel string) *url.URL {
+	u := *loc.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + loc.container + "/" + joinObjectKey(loc.prefix, rel)
+	u.RawPath = awsURIEncode(u.Path, false)
+	return &u
+}
+
+func azureCredentialsFromEnv() (azureCredentials, error) {
+	if sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"); sas != "" {
+		return azureCredentials{sasToken: sas}, nil
+	}
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return azureCredentials{}, fmt.Errorf("decode AZURE_STORAGE_KEY: %w", err)
+		}
+		return azureCredentials{accountKey: decoded}, nil
+	}
+	return azureCredentials{}, errors.New("AZURE_STORAGE_SAS_TOKEN or AZURE_STORAGE_KEY must be set for az:// destinations")
+}
+
+func azureUpload(ctx context.Context, bucket string, file publishFile) error {
+	return azurePut(ctx, bucket, file, nil)
+}
+
+// azurePut uploads file with the given precondition headers, if any.
+func azurePut(ctx context.Context, bucket string, file publishFile, conditions http.Header) error {
+	loc, err := parseAzureLocation(bucket)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(file.src)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, loc.blobURL(file.dst).String(), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("x-ms-blob-cache-control", file.cacheControl)
+	req.Header.Set("x-ms-blob-content-type", objectContentType(file))
+	if file.contentDisposition != "" {
+		req.Header.Set("x-ms-blob-content-disposition", file.contentDisposition)
+	}
+	for name, values := range conditions {
+		req.Header[name] = values
+	}
+	resp, err := azureDo(req, loc, int64(len(content)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		if len(conditions) > 0 {
+			return preconditionStatusError(resp)
+		}
+		return httpStatusError(resp)
+	}
+	return nil
+}
+
+func azureRead(ctx context.Context, bucket, rel string) ([]byte, bool, error) {
+	content, _, exists, err := azureGet(ctx, bucket, rel)
+	return content, exists, err
+}
+
+// azureGet reads rel along with its ETag.
+func azureGet(ctx context.Context, bucket, rel string) ([]byte, string, bool, error) {
+	loc, err := parseAzureLocation(bucket)
+	if err != nil {
+		return nil, "", false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc.blobURL(rel).String(), nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	resp, err := azureDo(req, loc, 0)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", false, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, "", false, httpStatusError(resp)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return content, resp.Header.Get("ETag"), true, nil
+}
+
+func azureDelete(ctx context.Context, bucket, rel string) error {
+	loc, err := parseAzureLocation(bucket)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, loc.blobURL(rel).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := azureDo(req, loc, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return httpStatusError(resp)
+	}
+	return nil
+}
+
+func azureDo(req *http.Request, loc azureLocation, contentLength int64) (*http.Response, error) {
+	creds, err := azureCredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureStorageAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	if creds.sasToken != "" {
+		if req.URL.RawQuery != "" {
+			req.URL.RawQuery += "&"
+		}
+		req.URL.RawQuery += creds.sasToken
+	} else {
+		signAzureRequest(req, loc.account, creds.accountKey, contentLength)
+	}
+	return objectDo(req)
+}
+
+// signAzureRequest adds a Shared Key Authorization header to req.
+func signAzureRequest(req *http.Request, account string, key []byte, contentLength int64) {
+	length := ""
+	if contentLength > 0 {
+		length = strconv.FormatInt(contentLength, 10)
+	}
+
+	msHeaders := []string{}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower)
+		}
+	}
+	sort.Strings(msHeaders)
+	var canonicalHeaders strings.Builder
+	for _, name := range msHeaders {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	var canonicalResource strings.Builder
+	canonicalResource.WriteString("/" + account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		canonicalResource.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date; x-ms-date is used instead.
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalHeaders.String() + canonicalResource.String(),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", "SharedKey "+account+":"+signature)
+}
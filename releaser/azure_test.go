@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAzureDestinationRoundTrip(t *testing.T) {
+	fake := &fakeAzureBlob{blobs: map[string]fakeObject{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_STORAGE_KEY", base64.StdEncoding.EncodeToString([]byte("azurite-key")))
+	bucket := "az://web/releases?account=devstoreaccount1&endpoint=" + server.URL + "/devstoreaccount1"
+
+	ctx := context.Background()
+	if _, exists, err := readObject(ctx, bucket, versionFileName); err != nil || exists {
+		t.Fatalf("readObject(missing) = %v, %v", exists, err)
+	}
+
+	src := filepath.Join(t.TempDir(), versionFileName)
+	if err := os.WriteFile(src, []byte("webCommit: abc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cacheControl, contentType, disposition, group := classifyFile(versionFileName)
+	file := publishFile{src: src, dst: versionFileName, cacheControl: cacheControl, contentType: contentType, contentDisposition: disposition, group: group}
+	if err := uploadFile(ctx, bucket, file); err != nil {
+		t.Fatalf("uploadFile() error = %v", err)
+	}
+
+	stored := fake.blobs["/devstoreaccount1/web/releases/version.yaml"]
+	if got := stored.header.Get("x-ms-blob-cache-control"); got != "no-cache, max-age=0, must-revalidate" {
+		t.Fatalf("x-ms-blob-cache-control = %q", got)
+	}
+	if got := stored.header.Get("x-ms-blob-content-type"); got != "text/plain; charset=utf-8" {
+		t.Fatalf("x-ms-blob-content-type = %q", got)
+	}
+	if got := stored.header.Get("x-ms-blob-content-disposition"); got != "inline" {
+		t.Fatalf("x-ms-blob-content-disposition = %q", got)
+	}
+
+	version, exists, err := readVersion(ctx, bucket)
+	if err != nil || !exists || version.WebCommit != "abc" {
+		t.Fatalf("readVersion() = %#v, %v, %v", version, exists, err)
+	}
+	if got := destinationURL(bucket, "index.html"); got != "az://web/releases/index.html" {
+		t.Fatalf("destinationURL() = %q", got)
+	}
+}
+
+func TestAzureSASTokenIsAppendedToQuery(t *testing.T) {
+	var gotQuery, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		gotAuth = r.Header.Get("Authorization")
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2021-08-06&sig=abc")
+	t.Setenv("AZURE_STORAGE_KEY", "")
+	bucket := "az://web?account=acct&endpoint=" + server.URL
+	if _, _, err := readObject(context.Background(), bucket, versionFileName); err != nil {
+		t.Fatal(err)
+	}
+	if gotQuery != "sv=2021-08-06&sig=abc" || gotAuth != "" {
+		t.Fatalf("query = %q, authorization = %q", gotQuery, gotAuth)
+	}
+}
+
+func TestAzureErrorsOmitSASToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AuthenticationFailed", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2021-08-06&sig=secret")
+	t.Setenv("AZURE_STORAGE_KEY", "")
+	ctx := context.Background()
+	_, _, err := readObject(ctx, "az://web?account=acct&endpoint="+server.URL, versionFileName)
+	if err == nil || !strings.Contains(err.Error(), "403") || strings.Contains(err.Error(), "sig=") {
+		t.Fatalf("403 error = %v", err)
+	}
+
+	// A transport error must not print the signed URL either.
+	server.Close()
+	_, _, err = readObject(ctx, "az://web?account=acct&endpoint="+server.URL, versionFileName)
+	if err == nil || strings.Contains(err.Error(), "sig=") {
+		t.Fatalf("transport error = %v", err)
+	}
+}
+
+// fakeAzureBlob is an Azurite-style stand-in for Put Blob and Get Blob.
+type fakeAzureBlob struct {
+	mu    sync.Mutex
+	blobs map[string]fakeObject
+}
+
+func (f *fakeAzureBlob) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey devstoreaccount1:") || r.Header.Get("x-ms-version") == "" {
+		http.Error(w, "AuthenticationFailed", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			http.Error(w, "MissingRequiredHeader", http.StatusBadRequest)
+			return
+		}
+		if fakePreconditionFailed(w, r, f.blobs) {
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		f.blobs[r.URL.Path] = fakeObject{content: content, header: r.Header.Clone()}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		blob, ok := f.blobs[r.URL.Path]
+		if !ok {
+			http.Error(w, "BlobNotFound", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", blob.etag())
+		_, _ = w.Write(blob.content)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// budgetConfig caps the size of the build output. Zero values disable the
+// corresponding check.
+type budgetConfig struct {
+	MaxTotalBytes int64 `yaml:"maxTotalBytes"`
+	// MaxGrowthBytes and MaxGrowthPercent limit the total size increase over
+	// the release currently published in the bucket.
+	MaxGrowthBytes   int64        `yaml:"maxGrowthBytes"`
+	MaxGrowthPercent float64      `yaml:"maxGrowthPercent"`
+	Files            []fileBudget `yaml:"files"`
+}
+
+// fileBudget applies to every file whose path, or base name when the glob has
+// no slash, matches Glob. MaxBytes caps the matched files combined and
+// MaxFileBytes caps each one.
+type fileBudget struct {
+	Glob         string `yaml:"glob"`
+	MaxBytes     int64  `yaml:"maxBytes"`
+	MaxFileBytes int64  `yaml:"maxFileBytes"`
+}
+
+type budgetViolation struct {
+	rule  string
+	files []manifestFile
+}
+
+// checkBudgets evaluates manifest against budgets. current is the published
+// manifest, or nil when the bucket has none, in which case growth limits are
+// skipped.
+func checkBudgets(budgets budgetConfig, manifest releaseManifest, current *releaseManifest) ([]budgetViolation, error) {
+	violations := []budgetViolation{}
+	total := manifestTotal(manifest)
+
+	if budgets.MaxTotalBytes > 0 && total > budgets.MaxTotalBytes {
+		violations = append(violations, budgetViolation{
+			rule:  fmt.Sprintf("total size %s exceeds maxTotalBytes %s", formatBytes(total), formatBytes(budgets.MaxTotalBytes)),
+			files: largestFiles(manifest.Files, 5),
+		})
+	}
+
+	if current != nil {
+		previous := manifestTotal(*current)
+		growth := total - previous
+		if budgets.MaxGrowthBytes > 0 && growth > budgets.MaxGrowthBytes {
+			violations = append(violations, budgetViolation{
+				rule:  fmt.Sprintf("total size grew by %s, more than maxGrowthBytes %s", formatBytes(growth), formatBytes(budgets.MaxGrowthBytes)),
+				files: grownFiles(manifest, *current),
+			})
+		}
+		if budgets.MaxGrowthPercent > 0 && previous > 0 {
+			percent := float64(growth) * 100 / float64(previous)
+			if percent > budgets.MaxGrowthPercent {
+				violations = append(violations, budgetViolation{
+					rule:  fmt.Sprintf("total size grew by %.1f%%, more than maxGrowthPercent %.1f%%", percent, budgets.MaxGrowthPercent),
+					files: grownFiles(manifest, *current),
+				})
+			}
+		}
+	}
+
+	for _, budget := range budgets.Files {
+		if _, err := path.Match(budget.Glob, ""); err != nil {
+			return nil, fmt.Errorf("budget glob %q: %w", budget.Glob, err)
+		}
+		matched := []manifestFile{}
+		var sum int64
+		for _, file := range manifest.Files {
+			if matchBudgetGlob(budget.Glob, file.Path) {
+				matched = append(matched, file)
+				sum += file.Size
+			}
+		}
+		if budget.MaxBytes > 0 && sum > budget.MaxBytes {
+			violations = append(violations, budgetViolation{
+				rule:  fmt.Sprintf("%s totals %s, more than maxBytes %s", budget.Glob, formatBytes(sum), formatBytes(budget.MaxBytes)),
+				files: matched,
+			})
+		}
+		if budget.MaxFileBytes > 0 {
+			over := []manifestFile{}
+			for _, file := range matched {
+				if file.Size > budget.MaxFileBytes {
+					over = append(over, file)
+				}
+			}
+			if len(over) > 0 {
+				violations = append(violations, budgetViolation{
+					rule:  fmt.Sprintf("%s has files larger than maxFileBytes %s", budget.Glob, formatBytes(budget.MaxFileBytes)),
+					files: over,
+				})
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+func matchBudgetGlob(glob, rel string) bool {
+	name := rel
+	if !strings.Contains(glob, "/") {
+		name = path.Base(rel)
+	}
+	ok, _ := path.Match(glob, name)
+	return ok
+}
+
+func manifestTotal(manifest releaseManifest) int64 {
+	var total int64
+	for _, file := range manifest.Files {
+		total += file.Size
+	}
+	return total
+}
+
+func largestFiles(files []manifestFile, n int) []manifestFile {
+	sorted := append([]manifestFile(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Size > sorted[j].Size
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// grownFiles lists the files that are new or larger than the file with the
+// same identity in current, comparing JS by chunk rather than by name.
+func grownFiles(manifest, current releaseManifest) []manifestFile {
+	before := map[string]int64{}
+	for _, file := range current.Files {
+		before[fileIdentity(file)] += file.Size
+	}
+	after := map[string]int64{}
+	for _, file := range manifest.Files {
+		after[fileIdentity(file)] += file.Size
+	}
+	grown := []manifestFile{}
+	for _, file := range manifest.Files {
+		identity := fileIdentity(file)
+		if after[identity] > before[identity] {
+			grown = append(grown, file)
+		}
+	}
+	return largestFiles(grown, 10)
+}
+
+// enforceBudgets checks the build against the configured budgets and fails
+// unless the overrun was explicitly allowed.
+func enforceBudgets(ctx context.Context, bucket string, budgets budgetConfig, manifest releaseManifest, allowOverrun bool) error {
+	var current *releaseManifest
+	published, exists, err := readManifest(ctx, bucket, manifestFileName)
+	if err != nil {
+		return fmt.Errorf("read published manifest: %w", err)
+	}
+	if exists {
+		current = &published
+	} else if budgets.MaxGrowthBytes > 0 || budgets.MaxGrowthPercent > 0 {
+		fmt.Printf("no published %s in %s; skipping growth budgets\n", manifestFileName, bucket)
+	}
+
+	violations, err := checkBudgets(budgets, manifest, current)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		fmt.Printf("bundle budgets ok: total %s\n", formatBytes(manifestTotal(manifest)))
+		return nil
+	}
+	printBudgetViolations(violations)
+	if allowOverrun {
+		fmt.Printf("continuing despite %d budget violation(s) due to --allow-budget-overrun\n", len(violations))
+		return nil
+	}
+	return fmt.Errorf("%d bundle budget violation(s); pass --allow-budget-overrun to publish anyway", len(violations))
+}
+
+func printBudgetViolations(violations []budgetViolation) {
+	for _, violation := range violations {
+		fmt.Printf("budget exceeded: %s\n", violation.rule)
+		for _, file := range violation.files {
+			fmt.Printf("  %s (%s)\n", file.Path, formatBytes(file.Size))
+		}
+	}
+}
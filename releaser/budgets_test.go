@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckBudgets(t *testing.T) {
+	t.Parallel()
+
+	manifest := releaseManifest{Files: []manifestFile{
+		{Path: "index.html", Size: 100},
+		{Path: "index.aaaaaaaa.js", Size: 600},
+		{Path: "index.bbbbbbbb.js", Size: 300},
+		{Path: "wasm/harness.cccccccc.wasm", Size: 2000},
+	}}
+	current := releaseManifest{Files: []manifestFile{
+		{Path: "index.html", Size: 100},
+		{Path: "index.dddddddd.js", Size: 800},
+		{Path: "wasm/harness.eeeeeeee.wasm", Size: 2000},
+	}}
+
+	cases := []struct {
+		name    string
+		budgets budgetConfig
+		want    int
+		files   int
+	}{
+		{name: "within budget", budgets: budgetConfig{MaxTotalBytes: 5000, MaxGrowthBytes: 200}, want: 0},
+		{name: "total", budgets: budgetConfig{MaxTotalBytes: 2500}, want: 1, files: 4},
+		{name: "growth bytes", budgets: budgetConfig{MaxGrowthBytes: 50}, want: 1, files: 2},
+		{name: "growth percent", budgets: budgetConfig{MaxGrowthPercent: 1}, want: 1, files: 2},
+		{name: "glob combined", budgets: budgetConfig{Files: []fileBudget{{Glob: "index.*.js", MaxBytes: 800}}}, want: 1, files: 2},
+		{name: "glob per file", budgets: budgetConfig{Files: []fileBudget{{Glob: "index.*.js", MaxFileBytes: 500}}}, want: 1, files: 1},
+		{name: "glob with dir", budgets: budgetConfig{Files: []fileBudget{{Glob: "wasm/*.wasm", MaxBytes: 1000}}}, want: 1, files: 1},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := checkBudgets(tc.budgets, manifest, &current)
+			if err != nil {
+				t.Fatalf("checkBudgets() error = %v", err)
+			}
+			if len(got) != tc.want {
+				t.Fatalf("checkBudgets() = %d violations, want %d: %#v", len(got), tc.want, got)
+			}
+			if tc.want > 0 && len(got[0].files) != tc.files {
+				t.Fatalf("violation lists %d files, want %d: %#v", len(got[0].files), tc.files, got[0].files)
+			}
+		})
+	}
+}
+
+func TestGrownFilesComparesChunks(t *testing.T) {
+	t.Parallel()
+
+	current := releaseManifest{Files: []manifestFile{
+		{Path: "index.aaaaaaaa.js", Size: 500, Chunk: "index.html"},
+		{Path: "index.bbbbbbbb.js", Size: 300, Chunk: "src/editor.tsx"},
+		{Path: "logo.cccccccc.svg", Size: 50},
+	}}
+	manifest := releaseManifest{Files: []manifestFile{
+		{Path: "index.dddddddd.js", Size: 480, Chunk: "index.html"},
+		{Path: "index.eeeeeeee.js", Size: 900, Chunk: "src/editor.tsx"},
+		{Path: "index.ffffffff.js", Size: 10, Chunk: "_vendor"},
+		{Path: "logo.99999999.svg", Size: 50},
+	}}
+	got := grownFiles(manifest, current)
+	if len(got) != 2 || got[0].Path != "index.eeeeeeee.js" || got[1].Path != "index.ffffffff.js" {
+		t.Fatalf("grownFiles() = %#v", got)
+	}
+}
+
+func TestLoadReleaserConfigRejectsUnknownFields(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "releaser.yaml")
+	if err := os.WriteFile(path, []byte("budgets:\n  maxTotalByte: 10\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadReleaserConfig(path); err == nil {
+		t.Fatal("loadReleaserConfig() accepted a misspelled budget key")
+	}
+}
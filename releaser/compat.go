@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// compatibilityFileName is where the web repo declares what the release needs
+// from the backend, next to url-map.yaml in app/.
+const compatibilityFileName = "compatibility.yaml"
+
+// releaseRequirements are the backend versions a release needs. They come
+// from app/compatibility.yaml and are recorded in version.yaml.
+type releaseRequirements struct {
+	// MinRunnerVersion is the oldest runner release, as semver, the app works
+	// with.
+	MinRunnerVersion string `yaml:"minRunnerVersion,omitempty" json:"minRunnerVersion,omitempty"`
+	// MinAgentProtocol is the oldest agent websocket protocol version the app
+	// speaks.
+	MinAgentProtocol int `yaml:"minAgentProtocol,omitempty" json:"minAgentProtocol,omitempty"`
+}
+
+func (r releaseRequirements) empty() bool {
+	return r.MinRunnerVersion == "" && r.MinAgentProtocol == 0
+}
+
+// compatibilityConfig describes the backend the release will talk to, under
+// compatibility in --config. ProbeURL, when set, is asked for the live
+// versions; the static values are used for anything the probe does not
+// report.
+type compatibilityConfig struct {
+	RunnerVersion string `yaml:"runnerVersion"`
+	AgentProtocol int    `yaml:"agentProtocol"`
+	ProbeURL      string `yaml:"probeURL"`
+}
+
+// backendVersion is what the backend runs. The probe endpoint answers with
+// this as JSON, or with a bare runner version as plain text.
+type backendVersion struct {
+	RunnerVersion string `json:"version"`
+	AgentProtocol int    `json:"agentProtocol"`
+}
+
+// readReleaseRequirements reads app/compatibility.yaml from appDir. A missing
+// file means the release declares no requirements.
+func readReleaseRequirements(appDir string) (*releaseRequirements, error) {
+	content, err := os.ReadFile(filepath.Join(appDir, compatibilityFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var reqs releaseRequirements
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&reqs); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse %s: %w", compatibilityFileName, err)
+	}
+	if reqs.MinRunnerVersion != "" {
+		if _, err := parseSemver(reqs.MinRunnerVersion); err != nil {
+			return nil, fmt.Errorf("%s: minRunnerVersion: %w", compatibilityFileName, err)
+		}
+	}
+	if reqs.empty() {
+		return nil, nil
+	}
+	return &reqs, nil
+}
+
+// requireDeclaredRequirements refuses a release without app/compatibility.yaml
+// when its profile requires compatibility checks, which would otherwise pass
+// without checking anything.
+func requireDeclaredRequirements(name string, profile profileConfig, reqs *releaseRequirements) error {
+	if reqs == nil && slices.Contains(profile.Require, profileRequireCompatibility) {
+		return fmt.Errorf("profile %q requires compatibility checks, but the release declares no requirements in app/%s", name, compatibilityFileName)
+	}
+	return nil
+}
+
+// enforceCompatibility refuses a release whose requirements the backend does
+// not meet, unless force is set.
+func enforceCompatibility(ctx context.Context, cfg *compatibilityConfig, reqs *releaseRequirements, force bool) error {
+	if reqs == nil {
+		return nil
+	}
+	if cfg == nil {
+		fmt.Println("no compatibility config; skipping backend compatibility checks")
+		return nil
+	}
+	backend, err := resolveBackendVersion(ctx, *cfg)
+	if err != nil {
+		if force {
+			fmt.Printf("backend version unknown (%v); continuing due to --force-incompatible\n", err)
+			return nil
+		}
+		return fmt.Errorf("resolve backend version: %w", err)
+	}
+
+	problems, err := checkCompatibility(*reqs, backend)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Printf("backend compatible: runner=%s agentProtocol=%d\n", firstNonEmpty(backend.RunnerVersion, "unknown"), backend.AgentProtocol)
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Printf("incompatible: %s\n", problem)
+	}
+	if force {
+		fmt.Printf("continuing despite %d compatibility problem(s) due to --force-incompatible\n", len(problems))
+		return nil
+	}
+	return fmt.Errorf("%d compatibility problem(s); pass --force-incompatible to publish anyway", len(problems))
+}
+
+// checkCompatibility compares reqs with backend. A requirement the backend
+// reports nothing about is a problem: it cannot be shown to hold.
+func checkCompatibility(reqs releaseRequirements, backend backendVersion) ([]string, error) {
+	problems := []string{}
+	if reqs.MinRunnerVersion != "" {
+		if backend.RunnerVersion == "" {
+			problems = append(problems, fmt.Sprintf("release needs runner >= %s but the runner version is unknown", reqs.MinRunnerVersion))
+		} else {
+			c, err := compareSemver(backend.RunnerVersion, reqs.MinRunnerVersion)
+			if err != nil {
+				return nil, fmt.Errorf("runner version: %w", err)
+			}
+			if c < 0 {
+				problems = append(problems, fmt.Sprintf("release needs runner >= %s, backend runs %s", reqs.MinRunnerVersion, backend.RunnerVersion))
+			}
+		}
+	}
+	if reqs.MinAgentProtocol > 0 {
+		if backend.AgentProtocol == 0 {
+			problems = append(problems, fmt.Sprintf("release needs agent protocol >= %d but the backend protocol is unknown", reqs.MinAgentProtocol))
+		} else if backend.AgentProtocol < reqs.MinAgentProtocol {
+			problems = append(problems, fmt.Sprintf("release needs agent protocol >= %d, backend speaks %d", reqs.MinAgentProtocol, backend.AgentProtocol))
+		}
+	}
+	return problems, nil
+}
+
+func resolveBackendVersion(ctx context.Context, cfg compatibilityConfig) (backendVersion, error) {
+	backend := backendVersion{RunnerVersion: cfg.RunnerVersion, AgentProtocol: cfg.AgentProtocol}
+	if cfg.ProbeURL == "" {
+		return backend, nil
+	}
+	probed, err := probeBackendVersion(ctx, cfg.ProbeURL)
+	if err != nil {
+		return backendVersion{}, err
+	}
+	if probed.RunnerVersion != "" {
+		backend.RunnerVersion = probed.RunnerVersion
+	}
+	if probed.AgentProtocol != 0 {
+		backend.AgentProtocol = probed.AgentProtocol
+	}
+	return backend, nil
+}
+
+func probeBackendVersion(ctx context.Context, url string) (backendVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return backendVersion{}, err
+	}
+	req.Header.Set("Accept", "application/json, text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return backendVersion{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return backendVersion{}, httpStatusError(resp)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return backendVersion{}, err
+	}
+
+	text := strings.TrimSpace(string(body))
+	if !strings.HasPrefix(text, "{") {
+		if _, err := parseSemver(text); err != nil {
+			return backendVersion{}, fmt.Errorf("probe response: %w", err)
+		}
+		return backendVersion{RunnerVersion: text}, nil
+	}
+	var backend backendVersion
+	if err := json.Unmarshal(body, &backend); err != nil {
+		return backendVersion{}, fmt.Errorf("parse probe response: %w", err)
+	}
+	return backend, nil
+}
+
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+// parseSemver accepts MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD] with an
+// optional leading v, as runner tags are written.
+func parseSemver(value string) (semver, error) {
+	text := strings.TrimPrefix(strings.TrimSpace(value), "v")
+	text, _, _ = strings.Cut(text, "+")
+	text, pre, hasPre := strings.Cut(text, "-")
+
+	var v semver
+	parts := strings.Split(text, ".")
+	if len(parts) > 3 {
+		return semver{}, fmt.Errorf("invalid version %q", value)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, fmt.Errorf("invalid version %q", value)
+		}
+		v.core[i] = n
+	}
+	if hasPre {
+		if pre == "" {
+			return semver{}, fmt.Errorf("invalid version %q", value)
+		}
+		v.prerelease = strings.Split(pre, ".")
+	}
+	return v, nil
+}
+
+// compareSemver orders a and b by semver precedence.
+func compareSemver(a, b string) (int, error) {
+	va, err := parseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range va.core {
+		if va.core[i] != vb.core[i] {
+			return cmp.Compare(va.core[i], vb.core[i]), nil
+		}
+	}
+	// A release ranks above its prereleases.
+	switch {
+	case len(va.prerelease) == 0 && len(vb.prerelease) == 0:
+		return 0, nil
+	case len(va.prerelease) == 0:
+		return 1, nil
+	case len(vb.prerelease) == 0:
+		return -1, nil
+	}
+	for i := 0; i < len(va.prerelease) && i < len(vb.prerelease); i++ {
+		if c := comparePrereleaseIdent(va.prerelease[i], vb.prerelease[i]); c != 0 {
+			return c, nil
+		}
+	}
+	return cmp.Compare(len(va.prerelease), len(vb.prerelease)), nil
+}
+
+func comparePrereleaseIdent(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
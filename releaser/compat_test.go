@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareSemver(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"3.16.0", "3.16.0", 0},
+		{"v3.16.0", "3.16", 0},
+		{"3.16.1", "3.16.0", 1},
+		{"3.9.0", "3.16.0", -1},
+		{"3.16.0-rc.1", "3.16.0", -1},
+		{"3.16.0-rc.2", "3.16.0-rc.10", -1},
+		{"3.16.0-beta", "3.16.0-alpha.1", 1},
+		{"3.16.0+build.5", "3.16.0", 0},
+	} {
+		got, err := compareSemver(tc.a, tc.b)
+		if err != nil {
+			t.Fatalf("compareSemver(%q, %q): %v", tc.a, tc.b, err)
+		}
+		if got != tc.want {
+			t.Errorf("compareSemver(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+	if _, err := parseSemver("3.x"); err == nil {
+		t.Error("parseSemver accepted 3.x")
+	}
+}
+
+func TestReadReleaseRequirements(t *testing.T) {
+	appDir := writeDist(t, map[string]string{
+		compatibilityFileName: "minRunnerVersion: 3.16.0\nminAgentProtocol: 2\n",
+	})
+	reqs, err := readReleaseRequirements(appDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reqs == nil || reqs.MinRunnerVersion != "3.16.0" || reqs.MinAgentProtocol != 2 {
+		t.Fatalf("requirements = %+v", reqs)
+	}
+
+	if reqs, err := readReleaseRequirements(t.TempDir()); err != nil || reqs != nil {
+		t.Fatalf("missing file: requirements = %+v, err = %v", reqs, err)
+	}
+	bad := writeDist(t, map[string]string{compatibilityFileName: "minRunner: 3\n"})
+	if _, err := readReleaseRequirements(bad); err == nil {
+		t.Fatal("readReleaseRequirements accepted an unknown key")
+	}
+}
+
+func TestRequireDeclaredRequirements(t *testing.T) {
+	t.Parallel()
+
+	prod := profileConfig{Require: []string{profileRequireCompatibility}}
+	err := requireDeclaredRequirements("prod", prod, nil)
+	if err == nil || !strings.Contains(err.Error(), compatibilityFileName) {
+		t.Fatalf("undeclared requirements under prod: err = %v", err)
+	}
+	if err := requireDeclaredRequirements("prod", prod, &releaseRequirements{MinAgentProtocol: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := requireDeclaredRequirements("", profileConfig{}, nil); err != nil {
+		t.Fatalf("no profile: %v", err)
+	}
+}
+
+// TestRepoCompatibility checks that the web app's declared requirements are
+// met by the backend in releaser.yaml, so prod releases are not refused.
+func TestRepoCompatibility(t *testing.T) {
+	t.Parallel()
+
+	reqs, err := readReleaseRequirements(filepath.Join("..", "app"))
+	if err != nil || reqs == nil {
+		t.Fatalf("app/%s = %+v, %v", compatibilityFileName, reqs, err)
+	}
+	releaserCfg, err := loadReleaserConfig("releaser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := requireDeclaredRequirements("prod", releaserCfg.Profiles["prod"], reqs); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := resolveBackendVersion(context.Background(), *releaserCfg.Compatibility)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := checkCompatibility(*reqs, backend); err != nil || len(problems) > 0 {
+		t.Fatalf("problems = %v, %v", problems, err)
+	}
+}
+
+func TestEnforceCompatibility(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			_, _ = w.Write([]byte(`{"version":"v3.15.2","agentProtocol":2}`))
+		case "/text":
+			_, _ = w.Write([]byte("3.17.0\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	reqs := &releaseRequirements{MinRunnerVersion: "3.16.0", MinAgentProtocol: 2}
+
+	err := enforceCompatibility(ctx, &compatibilityConfig{ProbeURL: server.URL + "/json"}, reqs, false)
+	if err == nil || !strings.Contains(err.Error(), "--force-incompatible") {
+		t.Fatalf("old runner: err = %v", err)
+	}
+	if err := enforceCompatibility(ctx, &compatibilityConfig{ProbeURL: server.URL + "/json"}, reqs, true); err != nil {
+		t.Fatalf("forced: %v", err)
+	}
+
+	// The probe reports only the runner; the protocol comes from the config.
+	if err := enforceCompatibility(ctx, &compatibilityConfig{ProbeURL: server.URL + "/text", AgentProtocol: 2}, reqs, false); err != nil {
+		t.Fatalf("new runner: %v", err)
+	}
+	if err := enforceCompatibility(ctx, &compatibilityConfig{ProbeURL: server.URL + "/text"}, reqs, false); err == nil {
+		t.Fatal("unknown protocol passed")
+	}
+	if err := enforceCompatibility(ctx, &compatibilityConfig{ProbeURL: server.URL + "/missing"}, reqs, false); err == nil {
+		t.Fatal("failed probe passed")
+	}
+
+	if err := enforceCompatibility(ctx, nil, reqs, false); err != nil {
+		t.Fatalf("no config: %v", err)
+	}
+	if err := enforceCompatibility(ctx, &compatibilityConfig{RunnerVersion: "3.0.0"}, nil, false); err != nil {
+		t.Fatalf("no requirements: %v", err)
+	}
+}
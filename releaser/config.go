@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// releaserConfig is the optional YAML file passed with --config. Flags cover
+// the inputs of a single release; the config file holds policy that should be
+// reviewed and versioned alongside the workflow.
+type releaserConfig struct {
+	Budgets       *budgetConfig            `yaml:"budgets"`
+	Secrets       secretsConfig            `yaml:"secrets"`
+	Hardening     hardeningConfig          `yaml:"hardening"`
+	Notifications []webhookConfig          `yaml:"notifications"`
+	Compatibility *compatibilityConfig     `yaml:"compatibility"`
+	Profiles      map[string]profileConfig `yaml:"profiles"`
+}
+
+func loadReleaserConfig(path string) (releaserConfig, error) {
+	if path == "" {
+		return releaserConfig{}, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return releaserConfig{}, err
+	}
+	var cfg releaserConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return releaserConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const appConfigsPath = "configs/app-configs.yaml"
+
+// releaseSnapshot is the part of a release that diff compares: the file
+// digests and the shipped app-configs.yaml.
+type releaseSnapshot struct {
+	label      string
+	files      map[string]manifestFile
+	appConfigs []byte
+}
+
+type fileChange struct {
+	path   string
+	before int64
+	after  int64
+}
+
+type chunkChange struct {
+	name          string
+	before, after int64
+	added         []string
+	removed       []string
+}
+
+type releaseDiff struct {
+	added   []fileChange
+	removed []fileChange
+	changed []fileChange
+
+	beforeTotal int64
+	afterTotal  int64
+
+	chunks []chunkChange
+
+	configAdded   []string
+	configRemoved []string
+	configChanged []string
+}
+
+func newDiffCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <a> <b>",
+		Short: "Compare two releases, buckets, or local dist directories",
+		Long: `Compare two releases. Each side can be:
+
+  a bucket URL or local bucket dir    the release currently published there
+  <bucket>@<ref>                      a past release from the bucket history (entry number or commit prefix)
+  path/to/app/dist                    a local build`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := loadReleaseSnapshot(cmd.Context(), args[0])
+			if err != nil {
+				return fmt.Errorf("load %s: %w", args[0], err)
+			}
+			b, err := loadReleaseSnapshot(cmd.Context(), args[1])
+			if err != nil {
+				return fmt.Errorf("load %s: %w", args[1], err)
+			}
+			diff, err := diffReleases(a, b)
+			if err != nil {
+				return err
+			}
+			printReleaseDiff(a, b, diff)
+			return nil
+		},
+	}
+}
+
+// archiveReleaseSnapshot keeps a copy of the manifest, app-configs, SBOM,
+// license bundle, and release notes under releases/<commit>/ so later diffs
+// and compliance reviews can look at this release after it has been replaced.
+func archiveReleaseSnapshot(ctx context.Context, bucket, distDir, commit string) error {
+	for _, name := range []string{manifestFileName, appConfigsPath, sbomFileName, licensesFileName, releaseNotesFileName, releaseNotesJSONFileName} {
+		content, err := os.ReadFile(filepath.Join(distDir, filepath.FromSlash(name)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		dst := path.Join(releaseSnapshotDir(commit), path.Base(name))
+		contentType := "text/plain; charset=utf-8"
+		if path.Ext(name) == ".json" {
+			contentType = "application/json"
+		}
+		if err := writeObject(ctx, bucket, dst, content, "no-cache, max-age=0, must-revalidate", contentType); err != nil {
+			return fmt.Errorf("upload %s: %w", dst, err)
+		}
+	}
+	return nil
+}
+
+func releaseSnapshotDir(commit string) string {
+	return "releases/" + commit
+}
+
+func loadReleaseSnapshot(ctx context.Context, value string) (releaseSnapshot, error) {
+	if st, err := os.Stat(value); err == nil && st.IsDir() {
+		// A local bucket carries its manifest; a plain build output does not
+		// and is hashed instead.
+		if err := assertFile(filepath.Join(value, manifestFileName)); err == nil {
+			return loadBucketSnapshot(ctx, value)
+		}
+		return loadLocalSnapshot(value)
+	}
+
+	if i := strings.LastIndex(value, "@"); i > 0 {
+		return loadHistorySnapshot(ctx, value[:i], value[i+1:])
+	}
+
+	if isRemoteBucket(value) {
+		return loadBucketSnapshot(ctx, value)
+	}
+	return releaseSnapshot{}, fmt.Errorf("%q is not a directory, bucket URL, or <bucket>@<ref>", value)
+}
+
+func loadBucketSnapshot(ctx context.Context, bucket string) (releaseSnapshot, error) {
+	manifest, exists, err := readManifest(ctx, bucket, manifestFileName)
+	if err != nil {
+		return releaseSnapshot{}, err
+	}
+	if !exists {
+		return releaseSnapshot{}, fmt.Errorf("%s has no %s; it was published before per-file digests were recorded", bucket, manifestFileName)
+	}
+	appConfigs, _, err := readObject(ctx, bucket, appConfigsPath)
+	if err != nil {
+		return releaseSnapshot{}, err
+	}
+	return newReleaseSnapshot(bucket, manifest, appConfigs), nil
+}
+
+func loadLocalSnapshot(dir string) (releaseSnapshot, error) {
+	files, err := collectPublishFiles(dir)
+	if err != nil {
+		return releaseSnapshot{}, err
+	}
+	manifest, err := buildManifest(dir, files)
+	if err != nil {
+		return releaseSnapshot{}, err
+	}
+	appConfigs, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(appConfigsPath)))
+	if err != nil && !os.IsNotExist(err) {
+		return releaseSnapshot{}, err
+	}
+	return newReleaseSnapshot(dir, manifest, appConfigs), nil
+}
+
+func loadHistorySnapshot(ctx context.Context, bucket, ref string) (releaseSnapshot, error) {
+	entries, err := readReleaseHistory(ctx, bucket)
+	if err != nil {
+		return releaseSnapshot{}, fmt.Errorf("read release history: %w", err)
+	}
+	i, err := resolveHistoryRef(entries, ref)
+	if err != nil {
+		return releaseSnapshot{}, err
+	}
+	commit := entries[i].Version.WebCommit
+	dir := releaseSnapshotDir(commit)
+
+	manifest, exists, err := readManifest(ctx, bucket, path.Join(dir, manifestFileName))
+	if err != nil {
+		return releaseSnapshot{}, err
+	}
+	if !exists {
+		return releaseSnapshot{}, fmt.Errorf("no archived manifest for %s in %s", shortSHA(commit, shortSHALen), bucket)
+	}
+	appConfigs, _, err := readObject(ctx, bucket, path.Join(dir, path.Base(appConfigsPath)))
+	if err != nil {
+		return releaseSnapshot{}, err
+	}
+	label := fmt.Sprintf("%s@%s", bucket, shortSHA(commit, shortSHALen))
+	return newReleaseSnapshot(label, manifest, appConfigs), nil
+}
+
+func newReleaseSnapshot(label string, manifest releaseManifest, appConfigs []byte) releaseSnapshot {
+	files := make(map[string]manifestFile, len(manifest.Files))
+	for _, file := range manifest.Files {
+		files[file.Path] = file
+	}
+	return releaseSnapshot{label: label, files: files, appConfigs: appConfigs}
+}
+
+func diffReleases(a, b releaseSnapshot) (releaseDiff, error) {
+	diff := releaseDiff{}
+	for name, before := range a.files {
+		diff.beforeTotal += before.Size
+		after, ok := b.files[name]
+		switch {
+		case !ok:
+			diff.removed = append(diff.removed, fileChange{path: name, before: before.Size})
+		case after.SHA256 != before.SHA256:
+			diff.changed = append(diff.changed, fileChange{path: name, before: before.Size, after: after.Size})
+		}
+	}
+	for name, after := range b.files {
+		diff.afterTotal += after.Size
+		if _, ok := a.files[name]; !ok {
+			diff.added = append(diff.added, fileChange{path: name, after: after.Size})
+		}
+	}
+	for _, changes := range [][]fileChange{diff.added, diff.removed, diff.changed} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	}
+
+	diff.chunks = diffChunks(a, b)
+
+	var err error
+	diff.configAdded, diff.configRemoved, diff.configChanged, err = diffAppConfigs(a.appConfigs, b.appConfigs)
+	if err != nil {
+		return releaseDiff{}, fmt.Errorf("compare %s: %w", appConfigsPath, err)
+	}
+	return diff, nil
+}
+
+// diffChunks compares JS files by chunk, so a chunk whose hash changed is
+// reported as one chunk with its old and new file. Files from builds
+// without chunk names are compared one by one.
+func diffChunks(a, b releaseSnapshot) []chunkChange {
+	byName := map[string]*chunkChange{}
+	get := func(file manifestFile) *chunkChange {
+		name := fileIdentity(file)
+		if byName[name] == nil {
+			byName[name] = &chunkChange{name: name}
+		}
+		return byName[name]
+	}
+	for name, file := range a.files {
+		if path.Ext(name) != ".js" {
+			continue
+		}
+		chunk := get(file)
+		chunk.before += file.Size
+		if _, ok := b.files[name]; !ok {
+			chunk.removed = append(chunk.removed, name)
+		}
+	}
+	for name, file := range b.files {
+		if path.Ext(name) != ".js" {
+			continue
+		}
+		chunk := get(file)
+		chunk.after += file.Size
+		if _, ok := a.files[name]; !ok {
+			chunk.added = append(chunk.added, name)
+		}
+	}
+
+	chunks := []chunkChange{}
+	for _, chunk := range byName {
+		if chunk.before == chunk.after && len(chunk.added) == 0 && len(chunk.removed) == 0 {
+			continue
+		}
+		sort.Strings(chunk.added)
+		sort.Strings(chunk.removed)
+		chunks = append(chunks, *chunk)
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].name < chunks[j].name })
+	return chunks
+}
+
+func unhashedName(rel string) string {
+	dir, name := path.Split(rel)
+	if loc := hashedAssetPattern.FindStringIndex(name); loc != nil {
+		name = name[:loc[0]] + path.Ext(name)
+	}
+	return dir + name
+}
+
+func diffAppConfigs(a, b []byte) (added, removed, changed []string, err error) {
+	before, err := flattenYAML(a)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	after, err := flattenYAML(b)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for key, value := range before {
+		next, ok := after[key]
+		switch {
+		case !ok:
+			removed = append(removed, key)
+		case next != value:
+			changed = append(changed, fmt.Sprintf("%s: %s -> %s", key, value, next))
+		}
+	}
+	for key, value := range after {
+		if _, ok := before[key]; !ok {
+			added = append(added, fmt.Sprintf("%s = %s", key, value))
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed, nil
+}
+
+// flattenYAML maps every scalar in content to a dotted key path such as
+// `oidc.google.clientID` or `agent.endpoints[0]`.
+func flattenYAML(content []byte) (map[string]string, error) {
+	out := map[string]string{}
+	if len(content) == 0 {
+		return out, nil
+	}
+	var doc any
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	flattenValue("", doc, out)
+	return out, nil
+}
+
+func flattenValue(prefix string, value any, out map[string]string) {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, child := range typed {
+			next := key
+			if prefix != "" {
+				next = prefix + "." + key
+			}
+			flattenValue(next, child, out)
+		}
+	case []any:
+		for i, child := range typed {
+			flattenValue(fmt.Sprintf("%s[%d]", prefix, i), child, out)
+		}
+	default:
+		out[prefix] = fmt.Sprint(typed)
+	}
+}
+
+func printReleaseDiff(a, b releaseSnapshot, diff releaseDiff) {
+	fmt.Printf("--- %s\n+++ %s\n", a.label, b.label)
+	fmt.Printf("files: %d added, %d removed, %d changed; total %s -> %s (%s)\n",
+		len(diff.added), len(diff.removed), len(diff.changed),
+		formatBytes(diff.beforeTotal), formatBytes(diff.afterTotal), formatByteDelta(diff.afterTotal-diff.beforeTotal))
+	for _, change := range diff.added {
+		fmt.Printf("  + %s (%s)\n", change.path, formatBytes(change.after))
+	}
+	for _, change := range diff.removed {
+		fmt.Printf("  - %s (%s)\n", change.path, formatBytes(change.before))
+	}
+	for _, change := range diff.changed {
+		fmt.Printf("  ~ %s (%s -> %s, %s)\n", change.path, formatBytes(change.before), formatBytes(change.after), formatByteDelta(change.after-change.before))
+	}
+
+	if len(diff.chunks) > 0 {
+		fmt.Println("js chunks:")
+		for _, chunk := range diff.chunks {
+			fmt.Printf("  %s: %s -> %s (%s)\n", chunk.name, formatBytes(chunk.before), formatBytes(chunk.after), formatByteDelta(chunk.after-chunk.before))
+			for _, name := range chunk.removed {
+				fmt.Printf("    - %s (%s)\n", name, formatBytes(a.files[name].Size))
+			}
+			for _, name := range chunk.added {
+				fmt.Printf("    + %s (%s)\n", name, formatBytes(b.files[name].Size))
+			}
+		}
+	}
+
+	if len(diff.configAdded)+len(diff.configRemoved)+len(diff.configChanged) > 0 {
+		fmt.Printf("%s:\n", appConfigsPath)
+		for _, key := range diff.configAdded {
+			fmt.Printf("  + %s\n", key)
+		}
+		for _, key := range diff.configRemoved {
+			fmt.Printf("  - %s\n", key)
+		}
+		for _, key := range diff.configChanged {
+			fmt.Printf("  ~ %s\n", key)
+		}
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 2; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMG"[exp])
+}
+
+func formatByteDelta(n int64) string {
+	if n >= 0 {
+		return "+" + formatBytes(n)
+	}
+	return "-" + formatBytes(-n)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffReleasesLocalDist(t *testing.T) {
+	t.Parallel()
+
+	before := writeDist(t, map[string]string{
+		"index.html":               "<html>v1</html>",
+		"index.aaaaaaaa.js":        "console.log(1)",
+		"logo.bbbbbbbb.svg":        "<svg/>",
+		"configs/app-configs.yaml": "agent:\n  endpoint: http://a\noidc:\n  clientExchange: true\n",
+	})
+	after := writeDist(t, map[string]string{
+		"index.html":               "<html>v2</html>",
+		"index.cccccccc.js":        "console.log(22222)",
+		"configs/app-configs.yaml": "agent:\n  endpoint: http://b\nextra: 1\n",
+	})
+
+	a, err := loadReleaseSnapshot(context.Background(), before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := loadReleaseSnapshot(context.Background(), after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := diffReleases(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := changePaths(diff.added); got != "index.cccccccc.js" {
+		t.Fatalf("added = %q", got)
+	}
+	if got := changePaths(diff.removed); got != "index.aaaaaaaa.js,logo.bbbbbbbb.svg" {
+		t.Fatalf("removed = %q", got)
+	}
+	if got := changePaths(diff.changed); got != "configs/app-configs.yaml,index.html" {
+		t.Fatalf("changed = %q", got)
+	}
+
+	// Without a Vite manifest each JS file is its own chunk.
+	if len(diff.chunks) != 2 || diff.chunks[0].name != "index.aaaaaaaa.js" || diff.chunks[1].name != "index.cccccccc.js" {
+		t.Fatalf("chunks = %#v", diff.chunks)
+	}
+
+	if strings.Join(diff.configAdded, ",") != "extra = 1" {
+		t.Fatalf("configAdded = %v", diff.configAdded)
+	}
+	if strings.Join(diff.configRemoved, ",") != "oidc.clientExchange" {
+		t.Fatalf("configRemoved = %v", diff.configRemoved)
+	}
+	if strings.Join(diff.configChanged, ",") != "agent.endpoint: http://a -> http://b" {
+		t.Fatalf("configChanged = %v", diff.configChanged)
+	}
+}
+
+func TestDiffChunksByViteManifest(t *testing.T) {
+	t.Parallel()
+
+	before := writeDist(t, map[string]string{
+		"index.html":        "<html></html>",
+		"index.aaaaaaaa.js": "main",
+		"index.bbbbbbbb.js": "editor",
+		"index.cccccccc.js": "vendor",
+		".vite/manifest.json": `{
+			"index.html": {"file": "index.aaaaaaaa.js", "src": "index.html", "isEntry": true},
+			"src/editor.tsx": {"file": "index.bbbbbbbb.js", "src": "src/editor.tsx", "isDynamicEntry": true},
+			"_index.cccccccc.js": {"file": "index.cccccccc.js", "name": "vendor"}
+		}`,
+	})
+	after := writeDist(t, map[string]string{
+		"index.html":        "<html></html>",
+		"index.aaaaaaaa.js": "main",
+		"index.dddddddd.js": "editor, now larger",
+		"index.eeeeeeee.js": "vendor",
+		".vite/manifest.json": `{
+			"index.html": {"file": "index.aaaaaaaa.js", "src": "index.html", "isEntry": true},
+			"src/editor.tsx": {"file": "index.dddddddd.js", "src": "src/editor.tsx", "isDynamicEntry": true},
+			"_index.eeeeeeee.js": {"file": "index.eeeeeeee.js", "name": "vendor"}
+		}`,
+	})
+
+	a, err := loadReleaseSnapshot(context.Background(), before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := loadReleaseSnapshot(context.Background(), after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.files[viteManifestPath]; ok {
+		t.Fatalf("%s is published", viteManifestPath)
+	}
+	diff, err := diffReleases(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The unchanged entry is left out; the rehashed chunks pair up.
+	if len(diff.chunks) != 2 {
+		t.Fatalf("chunks = %#v", diff.chunks)
+	}
+	vendor, editor := diff.chunks[0], diff.chunks[1]
+	if vendor.name != "_vendor" || vendor.after != vendor.before || strings.Join(vendor.removed, ",") != "index.cccccccc.js" || strings.Join(vendor.added, ",") != "index.eeeeeeee.js" {
+		t.Fatalf("vendor chunk = %#v", vendor)
+	}
+	if editor.name != "src/editor.tsx" || editor.after-editor.before != 12 {
+		t.Fatalf("editor chunk = %#v", editor)
+	}
+}
+
+func TestUnhashedName(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"index.DkR2x_9a.js":        "index.js",
+		"assets/logo.12345678.svg": "assets/logo.svg",
+		"index.html":               "index.html",
+	}
+	for in, want := range cases {
+		if got := unhashedName(in); got != want {
+			t.Fatalf("unhashedName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func writeDist(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func changePaths(changes []fileChange) string {
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		paths = append(paths, change.path)
+	}
+	return strings.Join(paths, ",")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gitCredentialHelper answers `git credential get` with the token read from
+// $RELEASER_GIT_TOKEN_FILE at the moment git asks, so the token itself never
+// appears in arguments, the environment, the work directory, or logs.
+const gitCredentialHelper = `!f() { test "$1" = get || exit 0; echo username=x-access-token; printf 'password=%s\n' "$(cat "$RELEASER_GIT_TOKEN_FILE")"; }; f`
+
+// gitAuth holds the credentials for the releaser's own git subprocesses.
+// Both fields are optional; with neither set git uses the host's setup.
+type gitAuth struct {
+	tokenFile string
+	sshKey    string
+}
+
+// gitEnv returns the environment for git subprocesses, or nil to inherit the
+// releaser's environment unchanged.
+func (a gitAuth) gitEnv() ([]string, error) {
+	if a.tokenFile == "" && a.sshKey == "" {
+		return nil, nil
+	}
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	if a.tokenFile != "" {
+		path, err := readableSecretFile(a.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("--git-token-file: %w", err)
+		}
+		// Append after any config the host already passes through
+		// GIT_CONFIG_*; the empty value clears inherited helpers first.
+		count, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+		env = append(env,
+			"RELEASER_GIT_TOKEN_FILE="+path,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=credential.helper", count),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=", count),
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=credential.helper", count+1),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", count+1, gitCredentialHelper),
+			fmt.Sprintf("GIT_CONFIG_COUNT=%d", count+2),
+		)
+	}
+
+	if a.sshKey != "" {
+		path, err := readableSecretFile(a.sshKey)
+		if err != nil {
+			return nil, fmt.Errorf("--ssh-key: %w", err)
+		}
+		env = append(env, "GIT_SSH_COMMAND=ssh -i "+shellQuote(path)+" -o IdentitiesOnly=yes -o BatchMode=yes")
+	}
+	return env, nil
+}
+
+// readableSecretFile checks that path is a non-empty regular file and returns
+// it as an absolute path, since git runs in other directories.
+func readableSecretFile(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return "", errors.New("must be a non-empty file")
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return "", err
+	}
+	_ = f.Close()
+	return abs, nil
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// hardeningConfig controls how index.html is rewritten before upload. Subresource
+// Integrity is on by default; the Content-Security-Policy is opt-in because a
+// policy that misses an origin breaks the app for every user.
+type hardeningConfig struct {
+	// DisableSRI leaves script and stylesheet tags untouched.
+	DisableSRI bool `yaml:"disableSRI"`
+	// CSP adds a generated Content-Security-Policy meta tag when set.
+	CSP *cspConfig `yaml:"csp"`
+}
+
+// cspConfig extends the generated policy. Sources maps a directive such as
+// connect-src to extra source expressions; a directive the generator does not
+// emit is added as is.
+type cspConfig struct {
+	Sources map[string][]string `yaml:"sources"`
+}
+
+// cspDirectiveOrder lists the generated directives in output order.
+var cspDirectiveOrder = []string{
+	"default-src",
+	"script-src",
+	"style-src",
+	"connect-src",
+	"img-src",
+	"font-src",
+	"worker-src",
+	"manifest-src",
+	"object-src",
+	"base-uri",
+}
+
+// Directives a <meta> policy cannot carry; browsers ignore them there.
+var cspHeaderOnlyDirectives = map[string]bool{
+	"frame-ancestors": true,
+	"report-uri":      true,
+	"report-to":       true,
+	"sandbox":         true,
+}
+
+var (
+	scriptTagPattern = regexp.MustCompile(`(?is)<script\b([^>]*)>(.*?)</script\s*>`)
+	linkTagPattern   = regexp.MustCompile(`(?is)<link\b([^>]*?)\s*/?>`)
+	headTagPattern   = regexp.MustCompile(`(?is)<head\b[^>]*>`)
+	charsetPattern   = regexp.MustCompile(`(?is)<meta\b[^>]*\bcharset\s*=[^>]*>`)
+	cspMetaPattern   = regexp.MustCompile(`(?is)<meta\b[^>]*http-equiv\s*=\s*["']?content-security-policy`)
+	htmlAttrPattern  = regexp.MustCompile(`([A-Za-z_:][-A-Za-z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+)
+
+// indexHardening summarizes what hardenIndexHTML changed.
+type indexHardening struct {
+	integrity int
+	external  []string
+	csp       string
+}
+
+func (h indexHardening) String() string {
+	summary := fmt.Sprintf("index.html: integrity added to %d tags", h.integrity)
+	if len(h.external) > 0 {
+		summary += fmt.Sprintf(", %d cross-origin left unpinned (%s)", len(h.external), strings.Join(h.external, ", "))
+	}
+	if h.csp != "" {
+		summary += "; csp: " + h.csp
+	}
+	return summary
+}
+
+// hardenIndexHTML adds integrity attributes to the scripts and stylesheets
+// index.html loads from the release itself and, when configured, a generated
+// Content-Security-Policy meta tag. It must run before manifest.yaml is
+// written so the manifest digests the rewritten file.
+func hardenIndexHTML(distDir string, cfg hardeningConfig) (indexHardening, error) {
+	indexPath := filepath.Join(distDir, "index.html")
+	content, err := os.ReadFile(indexPath)
+	if err != nil {
+		return indexHardening{}, err
+	}
+	html := string(content)
+	result := indexHardening{}
+
+	if !cfg.DisableSRI {
+		if html, err = addSubresourceIntegrity(distDir, html, &result); err != nil {
+			return indexHardening{}, err
+		}
+	}
+
+	// A policy already in index.html, from the app or an earlier run, is kept.
+	if cfg.CSP != nil && !cspMetaPattern.MatchString(html) {
+		appConfigs, err := os.ReadFile(filepath.Join(distDir, filepath.FromSlash(appConfigsPath)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return indexHardening{}, err
+		}
+		policy, err := buildContentSecurityPolicy(html, appConfigs, *cfg.CSP)
+		if err != nil {
+			return indexHardening{}, err
+		}
+		html = insertCSPMeta(html, policy)
+		result.csp = policy
+	}
+
+	if html == string(content) {
+		return result, nil
+	}
+	return result, os.WriteFile(indexPath, []byte(html), 0o644)
+}
+
+func addSubresourceIntegrity(distDir, html string, result *indexHardening) (string, error) {
+	var firstErr error
+	annotate := func(tag, attrs, ref string) string {
+		if firstErr != nil || ref == "" {
+			return tag
+		}
+		if _, ok := parseHTMLAttrs(attrs)["integrity"]; ok {
+			return tag
+		}
+		rel, local := localAssetPath(ref)
+		if !local {
+			result.external = append(result.external, ref)
+			return tag
+		}
+		content, err := os.ReadFile(filepath.Join(distDir, filepath.FromSlash(rel)))
+		if err != nil {
+			firstErr = fmt.Errorf("index.html references %s: %w", ref, err)
+			return tag
+		}
+		sum := sha512.Sum384(content)
+		result.integrity++
+		// Attributes go right after the tag name so self-closing and
+		// unquoted forms keep parsing the same way.
+		name := "<script"
+		if strings.HasPrefix(strings.ToLower(tag), "<link") {
+			name = "<link"
+		}
+		return name + ` integrity="sha384-` + base64.StdEncoding.EncodeToString(sum[:]) + `"` + tag[len(name):]
+	}
+
+	html = scriptTagPattern.ReplaceAllStringFunc(html, func(tag string) string {
+		attrs := scriptTagPattern.FindStringSubmatch(tag)[1]
+		return annotate(tag, attrs, parseHTMLAttrs(attrs)["src"])
+	})
+	html = linkTagPattern.ReplaceAllStringFunc(html, func(tag string) string {
+		attrs := linkTagPattern.FindStringSubmatch(tag)[1]
+		parsed := parseHTMLAttrs(attrs)
+		if !linkNeedsIntegrity(parsed) {
+			return tag
+		}
+		return annotate(tag, attrs, parsed["href"])
+	})
+	return html, firstErr
+}
+
+// linkNeedsIntegrity reports whether the browser enforces integrity on the
+// link: stylesheets, module preloads, and script or style preloads.
+func linkNeedsIntegrity(attrs map[string]string) bool {
+	for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+		switch rel {
+		case "stylesheet", "modulepreload":
+			return true
+		case "preload":
+			as := strings.ToLower(attrs["as"])
+			return as == "script" || as == "style"
+		}
+	}
+	return false
+}
+
+// localAssetPath resolves ref against the site root and reports whether it
+// points into the release rather than another origin.
+func localAssetPath(ref string) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	return strings.TrimPrefix(path.Clean("/"+u.Path), "/"), true
+}
+
+// parseHTMLAttrs returns attribute values keyed by lower-cased name. Boolean
+// attributes map to the empty string.
+func parseHTMLAttrs(attrs string) map[string]string {
+	out := map[string]string{}
+	for _, match := range htmlAttrPattern.FindAllStringSubmatch(attrs, -1) {
+		name := strings.ToLower(match[1])
+		if _, ok := out[name]; !ok {
+			out[name] = match[2] + match[3] + match[4]
+		}
+	}
+	return out
+}
+
+// buildContentSecurityPolicy derives a policy from what index.html loads and
+// the endpoints the app is configured to talk to in app-configs.yaml.
+func buildContentSecurityPolicy(html string, appConfigs []byte, cfg cspConfig) (string, error) {
+	directives := map[string][]string{
+		"default-src":  {"'self'"},
+		"script-src":   {"'self'"},
+		"style-src":    {"'self'"},
+		"connect-src":  {"'self'"},
+		"img-src":      {"'self'", "data:", "blob:"},
+		"font-src":     {"'self'", "data:"},
+		"worker-src":   {"'self'", "blob:"},
+		"manifest-src": {"'self'"},
+		"object-src":   {"'none'"},
+		"base-uri":     {"'self'"},
+	}
+	add := func(directive string, sources ...string) {
+		for _, source := range sources {
+			if source != "" && !slices.Contains(directives[directive], source) {
+				directives[directive] = append(directives[directive], source)
+			}
+		}
+	}
+
+	for _, match := range scriptTagPattern.FindAllStringSubmatch(html, -1) {
+		attrs := parseHTMLAttrs(match[1])
+		if src, ok := attrs["src"]; ok {
+			if _, local := localAssetPath(src); !local {
+				add("script-src", sourceOrigin(src))
+			}
+			continue
+		}
+		// Inline scripts are allowed by hash rather than 'unsafe-inline'.
+		if body := match[2]; strings.TrimSpace(body) != "" {
+			sum := sha256.Sum256([]byte(body))
+			add("script-src", "'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+		}
+	}
+	for _, match := range linkTagPattern.FindAllStringSubmatch(html, -1) {
+		attrs := parseHTMLAttrs(match[1])
+		if href := attrs["href"]; linkNeedsIntegrity(attrs) {
+			if _, local := localAssetPath(href); !local {
+				directive := "style-src"
+				if strings.Contains(strings.ToLower(attrs["rel"]), "modulepreload") || strings.ToLower(attrs["as"]) == "script" {
+					directive = "script-src"
+				}
+				add(directive, sourceOrigin(href))
+			}
+		}
+	}
+
+	endpoints, err := appConfigEndpoints(appConfigs)
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", appConfigsPath, err)
+	}
+	add("connect-src", endpoints...)
+
+	names := append([]string(nil), cspDirectiveOrder...)
+	extra := []string{}
+	for directive, sources := range cfg.Sources {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if cspHeaderOnlyDirectives[directive] {
+			return "", fmt.Errorf("csp directive %s is not supported in a meta tag", directive)
+		}
+		if _, ok := directives[directive]; !ok {
+			extra = append(extra, directive)
+		}
+		add(directive, sources...)
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+" "+strings.Join(directives[name], " "))
+	}
+	return strings.Join(parts, "; "), nil
+}
+
+// appConfigEndpoints returns the origins of every absolute http(s) or ws(s)
+// URL configured under a key containing "endpoint", such as
+// agent.endpoint and agent.defaultRunnerEndpoint.
+func appConfigEndpoints(content []byte) ([]string, error) {
+	flat, err := flattenYAML(content)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		if strings.Contains(strings.ToLower(key), "endpoint") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	origins := []string{}
+	for _, key := range keys {
+		if origin := sourceOrigin(flat[key]); origin != "" && !slices.Contains(origins, origin) {
+			origins = append(origins, origin)
+		}
+	}
+	return origins, nil
+}
+
+// sourceOrigin turns an absolute URL into a CSP host source such as
+// https://apis.google.com; anything else yields "".
+func sourceOrigin(raw string) string {
+	if strings.HasPrefix(raw, "//") {
+		raw = "https:" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "ws", "wss":
+		return strings.ToLower(u.Scheme) + "://" + u.Host
+	}
+	return ""
+}
+
+// insertCSPMeta places the policy before anything it governs: after the
+// charset declaration when present, otherwise right after <head>.
+func insertCSPMeta(html, policy string) string {
+	meta := `<meta http-equiv="Content-Security-Policy" content="` + strings.ReplaceAll(policy, `"`, "&quot;") + `" />`
+	anchor := charsetPattern.FindStringIndex(html)
+	if anchor == nil {
+		anchor = headTagPattern.FindStringIndex(html)
+	}
+	if anchor == nil {
+		return meta + "\n" + html
+	}
+	indent := "\n"
+	if lineStart := strings.LastIndex(html[:anchor[0]], "\n"); lineStart >= 0 {
+		if prefix := html[lineStart+1 : anchor[0]]; strings.TrimSpace(prefix) == "" {
+			indent += prefix
+		}
+	}
+	return html[:anchor[1]] + indent + meta + html[anchor[1]:]
+}
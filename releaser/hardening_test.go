@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testIndexHTML = `<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <link rel="manifest" href="/manifest.webmanifest" />
+    <script src="https://apis.google.com/js/api.js"></script>
+    <script type="module" crossorigin src="/index.abc.js"></script>
+    <link rel="modulepreload" crossorigin href="/index.def.js">
+    <link rel="stylesheet" crossorigin href="/index.abc.css">
+    <script>window.boot = 1;</script>
+  </head>
+  <body><div id="root"></div></body>
+</html>
+`
+
+func TestHardenIndexHTML(t *testing.T) {
+	t.Parallel()
+
+	dist := writeDist(t, map[string]string{
+		"index.html":    testIndexHTML,
+		"index.abc.js":  "console.log('app')",
+		"index.def.js":  "export const x = 1",
+		"index.abc.css": "body{}",
+		appConfigsPath:  "agent:\n  endpoint: 'https://agent.example.com/v1'\n  defaultRunnerEndpoint: 'wss://runner.example.com/ws'\noidc:\n  redirectURL: 'https://web.runme.dev/oidc/callback'\n",
+	})
+
+	result, err := hardenIndexHTML(dist, hardeningConfig{CSP: &cspConfig{Sources: map[string][]string{
+		"style-src": {"'unsafe-inline'"},
+		"frame-src": {"https://docs.google.com"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dist, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(content)
+
+	for _, want := range []string{
+		`<script integrity="` + sri384("console.log('app')") + `" type="module" crossorigin src="/index.abc.js">`,
+		`<link integrity="` + sri384("export const x = 1") + `" rel="modulepreload" crossorigin href="/index.def.js">`,
+		`<link integrity="` + sri384("body{}") + `" rel="stylesheet" crossorigin href="/index.abc.css">`,
+		`<script src="https://apis.google.com/js/api.js"></script>`,
+		`<link rel="manifest" href="/manifest.webmanifest" />`,
+		"<meta charset=\"UTF-8\" />\n    <meta http-equiv=\"Content-Security-Policy\"",
+	} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("index.html missing %q:\n%s", want, html)
+		}
+	}
+	if result.integrity != 3 || len(result.external) != 1 {
+		t.Fatalf("result = %#v", result)
+	}
+
+	inline := sha256.Sum256([]byte("window.boot = 1;"))
+	for _, want := range []string{
+		"script-src 'self' https://apis.google.com 'sha256-" + base64.StdEncoding.EncodeToString(inline[:]) + "';",
+		"style-src 'self' 'unsafe-inline';",
+		"connect-src 'self' wss://runner.example.com https://agent.example.com;",
+		"object-src 'none';",
+		"; frame-src https://docs.google.com",
+	} {
+		if !strings.Contains(result.csp, want) {
+			t.Fatalf("csp missing %q: %s", want, result.csp)
+		}
+	}
+	if strings.Contains(result.csp, "web.runme.dev") {
+		t.Fatalf("redirect URLs are navigations, not connections: %s", result.csp)
+	}
+
+	// A second pass leaves the already hardened file alone.
+	if _, err := hardenIndexHTML(dist, hardeningConfig{CSP: &cspConfig{}}); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := os.ReadFile(filepath.Join(dist, "index.html"))
+	if string(again) != html {
+		t.Fatalf("second pass changed index.html:\n%s", again)
+	}
+}
+
+func TestHardenIndexHTMLErrors(t *testing.T) {
+	t.Parallel()
+
+	missing := writeDist(t, map[string]string{"index.html": `<script src="/gone.js"></script>`})
+	if _, err := hardenIndexHTML(missing, hardeningConfig{}); err == nil {
+		t.Fatal("a missing local script should fail the release")
+	}
+	if _, err := hardenIndexHTML(missing, hardeningConfig{DisableSRI: true}); err != nil {
+		t.Fatalf("disableSRI should skip hashing: %v", err)
+	}
+
+	dist := writeDist(t, map[string]string{"index.html": "<head></head>"})
+	cfg := hardeningConfig{CSP: &cspConfig{Sources: map[string][]string{"frame-ancestors": {"'none'"}}}}
+	if _, err := hardenIndexHTML(dist, cfg); err == nil {
+		t.Fatal("header-only directives should be rejected")
+	}
+}
+
+func sri384(content string) string {
+	sum := sha512.Sum384([]byte(content))
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const historyFileName = "releases/history.jsonl"
+
+// releaseHistoryEntry is one line of releases/history.jsonl. version.yaml only
+// describes the live release; the history keeps every publish.
+type releaseHistoryEntry struct {
+	Version     releaseVersion `json:"version"`
+	Publisher   string         `json:"publisher"`
+	PublishedAt string         `json:"publishedAt"`
+	Duration    string         `json:"duration"`
+	Files       int            `json:"files"`
+}
+
+type historyFilter struct {
+	branch string
+	commit string
+	since  time.Time
+	until  time.Time
+}
+
+func newHistoryCmd() *cobra.Command {
+	var (
+		bucket string
+		filter historyFilter
+		since  string
+		until  string
+		at     string
+		asJSON bool
+	)
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List releases recorded in the bucket history",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if filter.since, err = parseHistoryTime(since); err != nil {
+				return fmt.Errorf("parse --since: %w", err)
+			}
+			if filter.until, err = parseHistoryTime(until); err != nil {
+				return fmt.Errorf("parse --until: %w", err)
+			}
+
+			entries, err := readReleaseHistory(cmd.Context(), bucket)
+			if err != nil {
+				return fmt.Errorf("read release history: %w", err)
+			}
+
+			indexes := filterHistory(entries, filter)
+			if at != "" {
+				when, err := parseHistoryTime(at)
+				if err != nil {
+					return fmt.Errorf("parse --at: %w", err)
+				}
+				live, ok := liveAt(entries, when)
+				if !ok {
+					return fmt.Errorf("no release was live at %s", when.Format(time.RFC3339))
+				}
+				indexes = []int{live}
+			}
+
+			if asJSON {
+				return printHistoryJSON(entries, indexes)
+			}
+			printHistoryTable(entries, indexes)
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&bucket, "bucket", defaultBucket, "bucket URL (gs://, s3://, az://) or local directory holding the release history")
+	cmd.Flags().StringVar(&filter.branch, "branch", "", "only show releases of this web branch")
+	cmd.Flags().StringVar(&filter.commit, "commit", "", "only show releases whose web commit starts with this prefix")
+	cmd.Flags().StringVar(&since, "since", "", "only show releases published at or after this RFC3339 time")
+	cmd.Flags().StringVar(&until, "until", "", "only show releases published at or before this RFC3339 time")
+	cmd.Flags().StringVar(&at, "at", "", "show the release that was live at this RFC3339 time")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print entries as JSON lines")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "diff <a> <b>",
+		Short: "Compare two history entries by number or web commit prefix",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := readReleaseHistory(cmd.Context(), bucket)
+			if err != nil {
+				return fmt.Errorf("read release history: %w", err)
+			}
+			a, err := resolveHistoryRef(entries, args[0])
+			if err != nil {
+				return err
+			}
+			b, err := resolveHistoryRef(entries, args[1])
+			if err != nil {
+				return err
+			}
+			changes := diffHistoryEntries(entries[a], entries[b])
+			if len(changes) == 0 {
+				fmt.Printf("#%d and #%d are identical\n", a+1, b+1)
+				return nil
+			}
+			fmt.Printf("#%d -> #%d\n", a+1, b+1)
+			for _, change := range changes {
+				fmt.Printf("  %s\n", change)
+			}
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+// historyAppendAttempts bounds how often appendReleaseHistory retries when
+// another publish appends to the history between its read and its write.
+const historyAppendAttempts = 5
+
+// appendReleaseHistory adds entry to the end of the bucket history. The write
+// is conditional on the history being unchanged since it was read, so
+// overlapping publishes retry instead of dropping each other's entries.
+func appendReleaseHistory(ctx context.Context, bucket string, entry releaseHistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		existing, version, err := readObjectVersion(ctx, bucket, historyFileName)
+		if err != nil {
+			return err
+		}
+		content := append([]byte(nil), existing...)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			content = append(content, '\n')
+		}
+		content = append(content, line...)
+		content = append(content, '\n')
+
+		err = writeObjectIfVersion(ctx, bucket, historyFileName, content, "no-cache, max-age=0, must-revalidate", "application/x-ndjson", version)
+		if !errors.Is(err, errObjectChanged) {
+			return err
+		}
+		if attempt == historyAppendAttempts {
+			return fmt.Errorf("history kept changing after %d attempts: %w", attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * 200 * time.Millisecond):
+		}
+	}
+}
+
+func readReleaseHistory(ctx context.Context, bucket string) ([]releaseHistoryEntry, error) {
+	content, _, err := readObject(ctx, bucket, historyFileName)
+	if err != nil {
+		return nil, err
+	}
+	return parseReleaseHistory(content)
+}
+
+func parseReleaseHistory(content []byte) ([]releaseHistoryEntry, error) {
+	entries := []releaseHistoryEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry releaseHistoryEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", historyFileName, lineNo, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// filterHistory returns the indexes of entries matching filter, oldest first.
+func filterHistory(entries []releaseHistoryEntry, filter historyFilter) []int {
+	indexes := []int{}
+	for i, entry := range entries {
+		if filter.branch != "" && entry.Version.WebBranch != filter.branch {
+			continue
+		}
+		if filter.commit != "" && !strings.HasPrefix(entry.Version.WebCommit, filter.commit) {
+			continue
+		}
+		if !filter.since.IsZero() || !filter.until.IsZero() {
+			published, err := time.Parse(time.RFC3339, entry.PublishedAt)
+			if err != nil {
+				continue
+			}
+			if !filter.since.IsZero() && published.Before(filter.since) {
+				continue
+			}
+			if !filter.until.IsZero() && published.After(filter.until) {
+				continue
+			}
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// liveAt returns the index of the most recent entry published at or before
+// when. Entries are appended in publish order, so the last match wins.
+func liveAt(entries []releaseHistoryEntry, when time.Time) (int, bool) {
+	live := -1
+	for i, entry := range entries {
+		published, err := time.Parse(time.RFC3339, entry.PublishedAt)
+		if err != nil || published.After(when) {
+			continue
+		}
+		live = i
+	}
+	return live, live >= 0
+}
+
+// resolveHistoryRef accepts a 1-based entry number as printed by `history` or
+// a web commit prefix, in which case the latest matching entry is used.
+func resolveHistoryRef(entries []releaseHistoryEntry, ref string) (int, error) {
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(entries) {
+			return 0, fmt.Errorf("history entry #%d out of range (1-%d)", n, len(entries))
+		}
+		return n - 1, nil
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(entries[i].Version.WebCommit, ref) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no history entry matches %q", ref)
+}
+
+func diffHistoryEntries(a, b releaseHistoryEntry) []string {
+	fields := []struct {
+		name string
+		a, b string
+	}{
+		{"buildDate", a.Version.BuildDate, b.Version.BuildDate},
+		{"webRepo", a.Version.WebRepo, b.Version.WebRepo},
+		{"webBranch", a.Version.WebBranch, b.Version.WebBranch},
+		{"webCommit", a.Version.WebCommit, b.Version.WebCommit},
+		{"bucket", a.Version.Bucket, b.Version.Bucket},
+		{"publisher", a.Publisher, b.Publisher},
+		{"publishedAt", a.PublishedAt, b.PublishedAt},
+		{"duration", a.Duration, b.Duration},
+		{"files", strconv.Itoa(a.Files), strconv.Itoa(b.Files)},
+	}
+	changes := []string{}
+	for _, field := range fields {
+		if field.a != field.b {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", field.name, field.a, field.b))
+		}
+	}
+	return changes
+}
+
+func printHistoryTable(entries []releaseHistoryEntry, indexes []int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tPUBLISHED\tBRANCH\tCOMMIT\tFILES\tDURATION\tPUBLISHER")
+	for _, i := range indexes {
+		entry := entries[i]
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			i+1,
+			entry.PublishedAt,
+			entry.Version.WebBranch,
+			shortSHA(entry.Version.WebCommit, shortSHALen),
+			entry.Files,
+			entry.Duration,
+			entry.Publisher,
+		)
+	}
+	_ = w.Flush()
+}
+
+func printHistoryJSON(entries []releaseHistoryEntry, indexes []int) error {
+	enc := json.NewEncoder(os.Stdout)
+	for _, i := range indexes {
+		if err := enc.Encode(entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseHistoryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// publisherIdentity prefers an explicit --publisher, then the GitHub Actions
+// run that is publishing, then the local user.
+func publisherIdentity(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		identity := fmt.Sprintf("github-actions:%s/actions/runs/%s", os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"))
+		if actor := os.Getenv("GITHUB_ACTOR"); actor != "" {
+			identity += " (" + actor + ")"
+		}
+		return identity
+	}
+
+	name := "unknown"
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		name = current.Username
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		name += "@" + host
+	}
+	return name
+}
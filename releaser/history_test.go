@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAppendAndReadReleaseHistory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bucket := t.TempDir()
+
+	for _, commit := range []string{"aaaa1111", "bbbb2222"} {
+		entry := releaseHistoryEntry{
+			Version:     releaseVersion{WebBranch: "main", WebCommit: commit, Bucket: bucket},
+			Publisher:   "tester",
+			PublishedAt: "2026-06-03T12:00:00Z",
+			Duration:    "1m0s",
+			Files:       3,
+		}
+		if commit == "bbbb2222" {
+			entry.PublishedAt = "2026-06-04T12:00:00Z"
+		}
+		if err := appendReleaseHistory(ctx, bucket, entry); err != nil {
+			t.Fatalf("appendReleaseHistory() error = %v", err)
+		}
+	}
+
+	entries, err := readReleaseHistory(ctx, bucket)
+	if err != nil {
+		t.Fatalf("readReleaseHistory() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("readReleaseHistory() returned %d entries, want 2", len(entries))
+	}
+	if entries[0].Version.WebCommit != "aaaa1111" || entries[1].Version.WebCommit != "bbbb2222" {
+		t.Fatalf("entries out of order: %#v", entries)
+	}
+
+	live, ok := liveAt(entries, time.Date(2026, 6, 3, 18, 0, 0, 0, time.UTC))
+	if !ok || live != 0 {
+		t.Fatalf("liveAt() = %d, %v, want 0, true", live, ok)
+	}
+	if _, ok := liveAt(entries, time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Fatal("liveAt() before the first release should not match")
+	}
+
+	got := filterHistory(entries, historyFilter{since: time.Date(2026, 6, 4, 0, 0, 0, 0, time.UTC)})
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("filterHistory(since) = %v, want [1]", got)
+	}
+
+	ref, err := resolveHistoryRef(entries, "aaaa")
+	if err != nil || ref != 0 {
+		t.Fatalf("resolveHistoryRef(aaaa) = %d, %v", ref, err)
+	}
+	changes := diffHistoryEntries(entries[0], entries[1])
+	if len(changes) != 2 {
+		t.Fatalf("diffHistoryEntries() = %v, want webCommit and publishedAt changes", changes)
+	}
+}
+
+func TestAppendReleaseHistoryConcurrently(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bucket := t.TempDir()
+	commits := []string{"aaaa1111", "bbbb2222", "cccc3333", "dddd4444"}
+	errs := make(chan error, len(commits))
+	for _, commit := range commits {
+		go func() {
+			errs <- appendReleaseHistory(ctx, bucket, releaseHistoryEntry{Version: releaseVersion{WebCommit: commit}})
+		}()
+	}
+	for range commits {
+		if err := <-errs; err != nil {
+			t.Fatalf("appendReleaseHistory() error = %v", err)
+		}
+	}
+
+	entries, err := readReleaseHistory(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(commits) {
+		t.Fatalf("history has %d entries, want %d: %#v", len(entries), len(commits), entries)
+	}
+}
+
+func TestReadReleaseHistoryMissing(t *testing.T) {
+	t.Parallel()
+
+	entries, err := readReleaseHistory(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatalf("readReleaseHistory() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("readReleaseHistory() = %v, want empty", entries)
+	}
+}
@@ -33,23 +33,50 @@ type config struct {
 
 	webRepo string
 	bucket  string
+	// profile names a deployment profile from --config; bucketSet records
+	// whether --bucket was given explicitly.
+	profile   string
+	bucketSet bool
 
 	dryRun bool
 
 	tmpBase string
+
+	publisher string
+
+	gitTokenFile string
+	sshKey       string
+
+	trace traceOptions
+
+	configPath         string
+	allowBudgetOverrun bool
+	forceIncompatible  bool
 }
 
 type repoSource struct {
 	identity    string
 	cloneSource string
+	// gitEnv is the environment for git subprocesses touching the repo; nil
+	// inherits the releaser's own.
+	gitEnv []string
 }
 
 type releaseVersion struct {
-	BuildDate string `yaml:"buildDate"`
-	WebRepo   string `yaml:"webRepo"`
-	WebBranch string `yaml:"webBranch"`
-	WebCommit string `yaml:"webCommit"`
-	Bucket    string `yaml:"bucket"`
+	BuildDate string `yaml:"buildDate" json:"buildDate"`
+	WebRepo   string `yaml:"webRepo" json:"webRepo"`
+	WebBranch string `yaml:"webBranch" json:"webBranch"`
+	WebCommit string `yaml:"webCommit" json:"webCommit"`
+	Bucket    string `yaml:"bucket" json:"bucket"`
+	// SBOM and Licenses name the objects published next to version.yaml.
+	SBOM     string `yaml:"sbom,omitempty" json:"sbom,omitempty"`
+	Licenses string `yaml:"licenses,omitempty" json:"licenses,omitempty"`
+	// Requires records the backend versions the release declared it needs.
+	Requires *releaseRequirements `yaml:"requires,omitempty" json:"requires,omitempty"`
+	// WebTag is set when WebBranch names a tag rather than a branch.
+	WebTag bool `yaml:"webTag,omitempty" json:"webTag,omitempty"`
+	// Profile is the deployment profile the release was published with.
+	Profile string `yaml:"profile,omitempty" json:"profile,omitempty"`
 }
 
 type publishFile struct {
@@ -61,6 +88,21 @@ type publishFile struct {
 	group              int
 }
 
+// releaseBuild is a built dist directory with its release markers written,
+// ready to publish.
+type releaseBuild struct {
+	version  releaseVersion
+	distDir  string
+	files    []publishFile
+	manifest releaseManifest
+	// worktree is where the release was built; it is zero for a dist
+	// directory built elsewhere.
+	worktree releaseWorktree
+	// appDir is the app the dist was built from, for the url-map check.
+	// Empty means the dist's parent directory.
+	appDir string
+}
+
 func main() {
 	if err := newRootCmd().ExecuteContext(context.Background()); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
@@ -74,100 +116,358 @@ func newRootCmd() *cobra.Command {
 		Use:   "releaser --web=<branch>",
 		Short: "Build and publish web.runme.dev static assets",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.bucketSet = cmd.Flags().Changed("bucket")
 			return run(cmd.Context(), cfg)
 		},
 	}
 
-	cmd.Flags().StringVar(&cfg.webBranch, "web", "", "branch name in the web repo")
+	cmd.Flags().StringVar(&cfg.webBranch, "web", "", "branch or tag name in the web repo")
 	cmd.Flags().StringVar(&cfg.webRepo, "web-repo", defaultWebRepo, "web repo slug, URL, or local path")
-	cmd.Flags().StringVar(&cfg.bucket, "bucket", defaultBucket, "destination bucket URL (gs://...) or local directory")
+	cmd.Flags().StringVar(&cfg.bucket, "bucket", defaultBucket, "destination bucket URL (gs://, s3://, az://) or local directory")
+	cmd.Flags().StringVar(&cfg.profile, "profile", "", "deployment profile from --config; sets the bucket and enforces its policy")
 	cmd.Flags().BoolVar(&cfg.dryRun, "dry-run", false, "build and evaluate publish state without uploading")
 	cmd.Flags().StringVar(&cfg.tmpBase, "tmpdir", os.TempDir(), "base temporary directory")
+	cmd.Flags().StringVar(&cfg.configPath, "config", "", "releaser config file (budgets and other release policy)")
+	cmd.Flags().BoolVar(&cfg.allowBudgetOverrun, "allow-budget-overrun", false, "publish even if bundle budgets are exceeded")
+	cmd.Flags().BoolVar(&cfg.forceIncompatible, "force-incompatible", false, "publish even if the backend does not meet the release's declared requirements")
+	cmd.Flags().StringVar(&cfg.publisher, "publisher", "", "publisher identity recorded in the release history (defaults to the CI run or local user)")
+	cmd.Flags().StringVar(&cfg.gitTokenFile, "git-token-file", "", "file holding an HTTPS token for cloning the web repo")
+	cmd.Flags().StringVar(&cfg.sshKey, "ssh-key", "", "SSH private key for cloning the web repo")
+	addTraceFlags(cmd, &cfg.trace)
 	_ = cmd.MarkFlagRequired("web")
 
+	cmd.AddCommand(newHistoryCmd())
+	cmd.AddCommand(newDiffCmd())
+	cmd.AddCommand(newPackageCmd())
+	cmd.AddCommand(newServeCmd())
+	cmd.AddCommand(newCheckURLMapCmd())
+	cmd.AddCommand(newScanSecretsCmd())
+	cmd.AddCommand(newPlanCmd())
+	cmd.AddCommand(newApplyCmd())
+	cmd.AddCommand(newBuildCmd())
+	cmd.AddCommand(newPublishCmd())
+	cmd.AddCommand(newForceUpdateCmd())
+	cmd.AddCommand(newCleanCmd())
+
 	return cmd
 }
 
-func run(ctx context.Context, cfg config) error {
-	webSource, err := resolveRepoSource(cfg.webRepo)
+func run(ctx context.Context, cfg config) (err error) {
+	started := time.Now()
+	ctx, finishTrace := startTrace(ctx, cfg.trace, "release")
+	defer func() { finishTrace(err) }()
+	releaserCfg, err := loadReleaserConfig(cfg.configPath)
 	if err != nil {
-		return fmt.Errorf("resolve --web-repo: %w", err)
+		return fmt.Errorf("load --config: %w", err)
+	}
+	if err := applyProfile(&cfg, releaserCfg); err != nil {
+		return err
 	}
-	webSHA, err := gitRemoteBranchHead(ctx, webSource.cloneSource, cfg.webBranch)
+	webhooks, err := newWebhooks(releaserCfg.Notifications)
 	if err != nil {
-		return fmt.Errorf("resolve web branch head: %w", err)
+		return fmt.Errorf("notifications: %w", err)
 	}
-	version := releaseVersion{
-		BuildDate: time.Now().Format(time.RFC3339),
-		WebRepo:   webSource.identity,
-		WebBranch: cfg.webBranch,
-		WebCommit: webSHA,
-		Bucket:    cfg.bucket,
+
+	event := releaseEvent{Outcome: releaseOutcomePublished, Bucket: cfg.bucket, Publisher: publisherIdentity(cfg.publisher)}
+	if !cfg.dryRun {
+		defer func() {
+			finishReleaseEvent(&event, started, err)
+			notifyRelease(ctx, webhooks, event)
+		}()
+	}
+
+	build, current, err := prepareRelease(ctx, cfg, releaserCfg, &event)
+	if err != nil {
+		return err
+	}
+	if current {
+		event.Outcome = releaseOutcomeNoop
+		return nil
+	}
+
+	if cfg.dryRun {
+		fmt.Printf("dry-run complete; would publish %d files to %s\n", len(build.files), cfg.bucket)
+		for _, file := range build.files {
+			fmt.Printf("  %s -> %s [%s]\n", file.src, destinationURL(cfg.bucket, file.dst), file.cacheControl)
+		}
+		build.worktree.keep("for inspection")
+		return nil
+	}
+
+	event.Version = build.version
+	event.Files = len(build.files)
+	if err := publishRelease(ctx, cfg.bucket, cfg.publisher, build, nil, started); err != nil {
+		build.worktree.keep("for debugging")
+		return err
+	}
+	build.worktree.remove(ctx)
+	return nil
+}
+
+// prepareRelease resolves, builds, and checks a release without touching the
+// bucket. It reports current=true when the bucket already serves the
+// requested inputs and there is nothing to do. When event is not nil, the
+// requested and published versions are recorded in it as soon as they are
+// known.
+func prepareRelease(ctx context.Context, cfg config, releaserCfg releaserConfig, event *releaseEvent) (build releaseBuild, current bool, err error) {
+	resolveCtx, resolveSpan := startSpan(ctx, "resolve", "web.repo", cfg.webRepo, "web.ref", cfg.webBranch)
+	webSource, version, err := resolveRelease(resolveCtx, cfg)
+	resolveSpan.set("web.commit", version.WebCommit, "web.tag", version.WebTag)
+	resolveSpan.finish(err)
+	if err != nil {
+		return releaseBuild{}, false, err
+	}
+	webSHA := version.WebCommit
+	if cfg.profile != "" {
+		if err := checkProfileSource(cfg.profile, releaserCfg.Profiles[cfg.profile], version); err != nil {
+			return releaseBuild{}, false, err
+		}
+		version.Profile = cfg.profile
+	}
+	if event != nil {
+		event.Version = version
 	}
 
-	current, exists, err := readVersion(ctx, cfg.bucket)
+	markerCtx, markerSpan := startSpan(ctx, "read marker", "bucket", cfg.bucket)
+	published, exists, err := readVersion(markerCtx, cfg.bucket)
+	markerSpan.set("marker.exists", exists, "published.commit", published.WebCommit)
+	markerSpan.finish(err)
 	if err != nil {
-		return fmt.Errorf("read current version marker: %w", err)
+		return releaseBuild{}, false, fmt.Errorf("read current version marker: %w", err)
 	}
-	if exists && versionMatches(version, current) {
+	if exists && event != nil {
+		event.Previous = &published
+	}
+	if exists && versionMatches(version, published) {
 		if cfg.dryRun {
 			fmt.Printf("release already current (continuing due to dry-run): web=%s bucket=%s\n", shortSHA(webSHA, shortSHALen), cfg.bucket)
 		} else {
 			fmt.Printf("release already current: web=%s bucket=%s\n", shortSHA(webSHA, shortSHALen), cfg.bucket)
-			return nil
+			return releaseBuild{}, true, nil
 		}
 	}
 
-	workDir := filepath.Join(cfg.tmpBase, fmt.Sprintf("web-%s", shortSHA(webSHA, shortSHALen)))
-	if err := os.RemoveAll(workDir); err != nil {
-		return fmt.Errorf("clean working directory: %w", err)
+	previousCommit := ""
+	if exists && published.WebRepo == version.WebRepo {
+		previousCommit = published.WebCommit
 	}
-	if err := os.MkdirAll(workDir, 0o755); err != nil {
-		return fmt.Errorf("create working directory: %w", err)
+	build, err = buildRelease(ctx, cfg.tmpBase, webSource, version, previousCommit, releaserCfg.Hardening, releaserCfg.Profiles[cfg.profile].AppConfig)
+	if err != nil {
+		return releaseBuild{}, false, err
 	}
-	fmt.Printf("working directory: %s\n", workDir)
 
-	webDir := filepath.Join(workDir, "web")
+	validateCtx, validateSpan := startSpan(ctx, "validate", "files", len(build.files), "bytes", manifestTotal(build.manifest))
+	err = validateRelease(validateCtx, cfg, releaserCfg, build)
+	validateSpan.finish(err)
+	if err != nil {
+		build.worktree.keep("for debugging")
+		return releaseBuild{}, false, err
+	}
+	return build, false, nil
+}
 
-	if err := gitCloneAndCheckout(ctx, webDir, webSource.cloneSource, cfg.webBranch, webSHA); err != nil {
-		return fmt.Errorf("clone web repository: %w", err)
+// validateRelease runs the checks a built release must pass before publish.
+func validateRelease(ctx context.Context, cfg config, releaserCfg releaserConfig, build releaseBuild) error {
+	appDir := build.appDir
+	if appDir == "" {
+		appDir = filepath.Dir(build.distDir)
 	}
-	if err := buildReleasePayload(ctx, webDir, version); err != nil {
+	if err := validateReleaseURLMap(appDir, build.distDir); err != nil {
+		return fmt.Errorf("validate url map: %w", err)
+	}
+	if err := scanForSecrets(releaserCfg.Secrets, build.files); err != nil {
+		return fmt.Errorf("scan for secrets: %w", err)
+	}
+	if releaserCfg.Budgets != nil {
+		if err := enforceBudgets(ctx, cfg.bucket, *releaserCfg.Budgets, build.manifest, cfg.allowBudgetOverrun); err != nil {
+			return err
+		}
+	}
+	if err := requireDeclaredRequirements(cfg.profile, releaserCfg.Profiles[cfg.profile], build.version.Requires); err != nil {
+		return err
+	}
+	return enforceCompatibility(ctx, releaserCfg.Compatibility, build.version.Requires, cfg.forceIncompatible)
+}
+
+// publishRelease uploads build in group order, removes deletes once the new
+// version.yaml is live, moves the update policy to the new build, then
+// archives the release and records it in the history.
+func publishRelease(ctx context.Context, bucket, publisher string, build releaseBuild, deletes []string, started time.Time) error {
+	for start := 0; start < len(build.files); {
+		end := start
+		for end < len(build.files) && build.files[end].group == build.files[start].group {
+			end++
+		}
+		if err := uploadGroup(ctx, bucket, build.files[start:end]); err != nil {
+			return err
+		}
+		start = end
+	}
+	fmt.Printf("published %d files to %s\n", len(build.files), bucket)
+
+	for _, rel := range deletes {
+		if err := deleteObject(ctx, bucket, rel); err != nil {
+			return fmt.Errorf("delete %s: %w", rel, err)
+		}
+	}
+	if len(deletes) > 0 {
+		fmt.Printf("deleted %d files from %s\n", len(deletes), bucket)
+	}
+
+	if _, err := updateUpdatePolicy(ctx, bucket, func(previous *updatePolicy) (updatePolicy, error) {
+		return releasedUpdatePolicy(previous, build.version, publisherIdentity(publisher)), nil
+	}); err != nil {
 		return err
 	}
 
+	if err := archiveReleaseSnapshot(ctx, bucket, build.distDir, build.version.WebCommit); err != nil {
+		return fmt.Errorf("archive release snapshot: %w", err)
+	}
+
+	entry := releaseHistoryEntry{
+		Version:     build.version,
+		Publisher:   publisherIdentity(publisher),
+		PublishedAt: time.Now().UTC().Format(time.RFC3339),
+		Duration:    time.Since(started).Round(time.Second).String(),
+		Files:       len(build.files),
+	}
+	if err := appendReleaseHistory(ctx, bucket, entry); err != nil {
+		return fmt.Errorf("record release history: %w", err)
+	}
+	return nil
+}
+
+// uploadGroup uploads files, which share one publish group, in order.
+func uploadGroup(ctx context.Context, bucket string, files []publishFile) (err error) {
+	var size int64
+	for _, file := range files {
+		if info, err := os.Stat(file.src); err == nil {
+			size += info.Size()
+		}
+	}
+	ctx, span := startSpan(ctx, fmt.Sprintf("upload group %d", files[0].group), "bucket", bucket, "files", len(files), "bytes", size)
+	defer func() { span.finish(err) }()
+	for _, file := range files {
+		if err := uploadFile(ctx, bucket, file); err != nil {
+			return fmt.Errorf("upload %s: %w", file.dst, err)
+		}
+	}
+	return nil
+}
+
+// resolveRelease pins the requested web branch to a commit and describes the
+// release that would be built from it.
+func resolveRelease(ctx context.Context, cfg config) (repoSource, releaseVersion, error) {
+	webSource, err := resolveRepoSource(cfg.webRepo, gitAuth{tokenFile: cfg.gitTokenFile, sshKey: cfg.sshKey})
+	if err != nil {
+		return repoSource{}, releaseVersion{}, fmt.Errorf("resolve --web-repo: %w", err)
+	}
+	webSHA, tag, err := gitRemoteRef(ctx, webSource.cloneSource, cfg.webBranch, webSource.gitEnv)
+	if err != nil {
+		return repoSource{}, releaseVersion{}, fmt.Errorf("resolve web ref: %w", err)
+	}
+	return webSource, releaseVersion{
+		BuildDate: time.Now().Format(time.RFC3339),
+		WebRepo:   webSource.identity,
+		WebBranch: cfg.webBranch,
+		WebCommit: webSHA,
+		WebTag:    tag,
+		Bucket:    cfg.bucket,
+	}, nil
+}
+
+// buildRelease checks version out into a worktree of the managed workspace
+// under tmpBase, builds it, merges appConfig over the built
+// app-configs.yaml, and returns the dist directory with version.yaml and
+// manifest.yaml written into it. The worktree is kept if the build fails.
+func buildRelease(ctx context.Context, tmpBase string, webSource repoSource, version releaseVersion, previousCommit string, hardening hardeningConfig, appConfig map[string]any) (build releaseBuild, err error) {
+	webSHA := version.WebCommit
+	cloneCtx, cloneSpan := startSpan(ctx, "clone", "web.repo", version.WebRepo, "web.commit", webSHA)
+	worktree, err := openWorkspace(tmpBase).checkout(cloneCtx, webSource, webSHA)
+	cloneSpan.set("work.dir", worktree.dir)
+	cloneSpan.finish(err)
+	if err != nil {
+		return releaseBuild{}, fmt.Errorf("check out web repository: %w", err)
+	}
+	fmt.Printf("working directory: %s\n", worktree.dir)
+	defer func() {
+		if err != nil {
+			worktree.keep("for debugging")
+		}
+	}()
+
+	webDir := worktree.dir
+	if err := buildReleasePayload(ctx, webDir, version); err != nil {
+		return releaseBuild{}, err
+	}
+
+	requires, err := readReleaseRequirements(filepath.Join(webDir, "app"))
+	if err != nil {
+		return releaseBuild{}, fmt.Errorf("read release requirements: %w", err)
+	}
+	version.Requires = requires
+
 	distDir := filepath.Join(webDir, "app", "dist")
+	if err := writeThirdPartyInventory(webDir, distDir, version); err != nil {
+		return releaseBuild{}, fmt.Errorf("generate sbom: %w", err)
+	}
+	version.SBOM = sbomFileName
+	version.Licenses = licensesFileName
+	if previousCommit == "" {
+		fmt.Println("no published commit from this repo; skipping release notes")
+	} else if err := writeReleaseNotes(ctx, webDir, distDir, webSource.gitEnv, previousCommit, webSHA); err != nil {
+		// Notes are informational; a published commit that was force-pushed
+		// away must not block the release.
+		fmt.Printf("release notes unavailable: %v\n", err)
+	}
+	if err := applyAppConfigOverlay(distDir, appConfig); err != nil {
+		return releaseBuild{}, fmt.Errorf("apply profile app config: %w", err)
+	}
+	build, err = finalizeDist(distDir, version, hardening)
+	build.worktree = worktree
+	return build, err
+}
+
+// finalizeDist validates a built dist directory, hardens index.html, and
+// writes the release markers into it.
+func finalizeDist(distDir string, version releaseVersion, hardening hardeningConfig) (releaseBuild, error) {
 	if err := assertDir(distDir); err != nil {
-		return fmt.Errorf("validate build output: %w", err)
+		return releaseBuild{}, fmt.Errorf("validate build output: %w", err)
 	}
 	if err := assertFile(filepath.Join(distDir, "index.html")); err != nil {
-		return fmt.Errorf("validate index.html: %w", err)
+		return releaseBuild{}, fmt.Errorf("validate index.html: %w", err)
+	}
+	hardened, err := hardenIndexHTML(distDir, hardening)
+	if err != nil {
+		return releaseBuild{}, fmt.Errorf("harden index.html: %w", err)
 	}
+	fmt.Println(hardened)
 	if err := writeVersionYAML(distDir, version); err != nil {
-		return fmt.Errorf("write version file: %w", err)
+		return releaseBuild{}, fmt.Errorf("write version file: %w", err)
 	}
 
 	files, err := collectPublishFiles(distDir)
 	if err != nil {
-		return fmt.Errorf("collect publish files: %w", err)
+		return releaseBuild{}, fmt.Errorf("collect publish files: %w", err)
 	}
-
-	if cfg.dryRun {
-		fmt.Printf("dry-run complete; would publish %d files to %s\n", len(files), cfg.bucket)
-		for _, file := range files {
-			fmt.Printf("  %s -> %s [%s]\n", file.src, destinationURL(cfg.bucket, file.dst), file.cacheControl)
-		}
-		return nil
+	manifest, err := buildManifest(distDir, files)
+	if err != nil {
+		return releaseBuild{}, fmt.Errorf("build release manifest: %w", err)
 	}
-
-	for _, file := range files {
-		if err := uploadFile(ctx, cfg.bucket, file); err != nil {
-			return fmt.Errorf("upload %s: %w", file.dst, err)
-		}
+	if err := writeManifestYAML(distDir, manifest); err != nil {
+		return releaseBuild{}, fmt.Errorf("write release manifest: %w", err)
+	}
+	files, err = collectPublishFiles(distDir)
+	if err != nil {
+		return releaseBuild{}, fmt.Errorf("collect publish files: %w", err)
 	}
 
-	fmt.Printf("published %d files to %s\n", len(files), cfg.bucket)
-	return nil
+	return releaseBuild{
+		version:  version,
+		distDir:  distDir,
+		files:    files,
+		manifest: manifest,
+	}, nil
 }
 
 func buildReleasePayload(ctx context.Context, webDir string, version releaseVersion) error {
@@ -175,19 +475,28 @@ func buildReleasePayload(ctx context.Context, webDir string, version releaseVers
 		"pnpm install --frozen-lockfile",
 		"pnpm run build:renderers",
 	} {
-		if err := runShell(ctx, webDir, nil, cmdline); err != nil {
+		if err := runTracedShell(ctx, webDir, nil, cmdline); err != nil {
 			return fmt.Errorf("build web prerequisites (%q): %w", cmdline, err)
 		}
 	}
 
 	buildEnv := append(os.Environ(), versionBuildEnv(version)...)
-	if err := runShell(ctx, webDir, buildEnv, "pnpm build:app"); err != nil {
+	if err := runTracedShell(ctx, webDir, buildEnv, "pnpm build:app"); err != nil {
 		return fmt.Errorf("build web app: %w", err)
 	}
 
 	return nil
 }
 
+// runTracedShell runs command in its own span, so its output gets its own
+// step log.
+func runTracedShell(ctx context.Context, dir string, env []string, command string) error {
+	ctx, span := startSpan(ctx, command, "process.command_line", command)
+	err := runShell(ctx, dir, env, command)
+	span.finish(err)
+	return err
+}
+
 func versionBuildEnv(version releaseVersion) []string {
 	return []string{
 		"VITE_RUNME_VERSION_BUILD_DATE=" + version.BuildDate,
@@ -204,15 +513,17 @@ func collectPublishFiles(distDir string) ([]publishFile, error) {
 		if err != nil {
 			return err
 		}
-		if d.IsDir() {
-			return nil
-		}
-
 		rel, err := filepath.Rel(distDir, path)
 		if err != nil {
 			return err
 		}
 		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel == viteManifestDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
 		cacheControl, contentType, contentDisposition, group := classifyFile(rel)
 		files = append(files, publishFile{
@@ -242,7 +553,7 @@ func collectPublishFiles(distDir string) ([]publishFile, error) {
 func classifyFile(rel string) (string, string, string, int) {
 	name := filepath.Base(rel)
 	switch {
-	case rel == versionFileName:
+	case rel == versionFileName, rel == manifestFileName:
 		return "no-cache, max-age=0, must-revalidate", "text/plain; charset=utf-8", "inline", 3
 	case rel == "index.html":
 		return "no-cache, max-age=0, must-revalidate", "", "", 2
@@ -254,20 +565,14 @@ func classifyFile(rel string) (string, string, string, int) {
 }
 
 func uploadFile(ctx context.Context, bucket string, file publishFile) error {
+	if isS3Bucket(bucket) {
+		return s3Upload(ctx, bucket, file)
+	}
+	if isAzureBucket(bucket) {
+		return azureUpload(ctx, bucket, file)
+	}
 	if strings.HasPrefix(bucket, "gs://") {
-		args := []string{
-			"storage",
-			"cp",
-			"--cache-control=" + file.cacheControl,
-		}
-		if file.contentType != "" {
-			args = append(args, "--content-type="+file.contentType)
-		}
-		if file.contentDisposition != "" {
-			args = append(args, "--content-disposition="+file.contentDisposition)
-		}
-		args = append(args, file.src, destinationURL(bucket, file.dst))
-		return runCmd(ctx, "", nil, "gcloud", args...)
+		return runCmd(ctx, "", nil, "gcloud", gcsCopyArgs(bucket, file)...)
 	}
 
 	target := destinationURL(bucket, file.dst)
@@ -277,27 +582,241 @@ func uploadFile(ctx context.Context, bucket string, file publishFile) error {
 	return copyFile(file.src, target)
 }
 
+func gcsCopyArgs(bucket string, file publishFile, extra ...string) []string {
+	args := []string{
+		"storage",
+		"cp",
+		"--cache-control=" + file.cacheControl,
+	}
+	if file.contentType != "" {
+		args = append(args, "--content-type="+file.contentType)
+	}
+	if file.contentDisposition != "" {
+		args = append(args, "--content-disposition="+file.contentDisposition)
+	}
+	args = append(args, extra...)
+	return append(args, file.src, destinationURL(bucket, file.dst))
+}
+
 func readVersion(ctx context.Context, bucket string) (releaseVersion, bool, error) {
+	content, exists, err := readObject(ctx, bucket, versionFileName)
+	if err != nil || !exists {
+		return releaseVersion{}, false, err
+	}
+	return parseVersionYAML(content)
+}
+
+// deleteObject removes rel from bucket. Missing objects are not an error, so
+// an interrupted apply can be retried.
+func deleteObject(ctx context.Context, bucket, rel string) error {
+	if isS3Bucket(bucket) {
+		return s3Delete(ctx, bucket, rel)
+	}
+	if isAzureBucket(bucket) {
+		return azureDelete(ctx, bucket, rel)
+	}
+	if strings.HasPrefix(bucket, "gs://") {
+		_, err := runCmdOutput(ctx, "", nil, "gcloud", "storage", "rm", destinationURL(bucket, rel))
+		if err != nil && isMissingVersionMarkerError(err) {
+			return nil
+		}
+		return err
+	}
+
+	err := os.Remove(destinationURL(bucket, rel))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// readObject returns the content of rel in bucket. A missing object is
+// reported as exists=false rather than an error.
+func readObject(ctx context.Context, bucket, rel string) ([]byte, bool, error) {
+	if isS3Bucket(bucket) {
+		return s3Read(ctx, bucket, rel)
+	}
+	if isAzureBucket(bucket) {
+		return azureRead(ctx, bucket, rel)
+	}
 	if strings.HasPrefix(bucket, "gs://") {
-		out, err := runCmdOutput(ctx, "", nil, "gcloud", "storage", "cat", destinationURL(bucket, versionFileName))
+		out, err := runCmdOutput(ctx, "", nil, "gcloud", "storage", "cat", destinationURL(bucket, rel))
 		if err != nil {
 			if isMissingVersionMarkerError(err) {
-				return releaseVersion{}, false, nil
+				return nil, false, nil
 			}
-			return releaseVersion{}, false, err
+			return nil, false, err
 		}
-		return parseVersionYAML(out)
+		return out, true, nil
 	}
 
-	path := destinationURL(bucket, versionFileName)
-	content, err := os.ReadFile(path)
+	content, err := os.ReadFile(destinationURL(bucket, rel))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return releaseVersion{}, false, nil
+			return nil, false, nil
 		}
-		return releaseVersion{}, false, err
+		return nil, false, err
 	}
-	return parseVersionYAML(content)
+	return content, true, nil
+}
+
+// writeObject uploads content to rel in bucket through a temporary file so it
+// goes through the same upload path as the release payload.
+func writeObject(ctx context.Context, bucket, rel string, content []byte, cacheControl, contentType string) error {
+	return withTempObject(content, func(src string) error {
+		return uploadFile(ctx, bucket, publishFile{
+			src:          src,
+			dst:          rel,
+			cacheControl: cacheControl,
+			contentType:  contentType,
+		})
+	})
+}
+
+func withTempObject(content []byte, upload func(src string) error) error {
+	tmp, err := os.CreateTemp("", "releaser-object-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return upload(tmp.Name())
+}
+
+// errObjectChanged reports that a conditional write found the object changed
+// since it was read.
+var errObjectChanged = errors.New("object changed since it was read")
+
+// readObjectVersion is readObject for a read-modify-write: it also returns the
+// version that writeObjectIfVersion checks, which is the GCS generation, the
+// S3 or Azure ETag, or a content digest for local directories. The version of
+// a missing object is empty.
+func readObjectVersion(ctx context.Context, bucket, rel string) ([]byte, string, error) {
+	if isS3Bucket(bucket) {
+		content, etag, _, err := s3Get(ctx, bucket, rel)
+		return content, etag, err
+	}
+	if isAzureBucket(bucket) {
+		content, etag, _, err := azureGet(ctx, bucket, rel)
+		return content, etag, err
+	}
+	if strings.HasPrefix(bucket, "gs://") {
+		out, err := runCmdOutput(ctx, "", nil, "gcloud", "storage", "objects", "describe", destinationURL(bucket, rel), "--format=value(generation)")
+		if err != nil {
+			if isMissingVersionMarkerError(err) {
+				return nil, "", nil
+			}
+			return nil, "", err
+		}
+		generation := strings.TrimSpace(string(out))
+		content, err := runCmdOutput(ctx, "", nil, "gcloud", "storage", "cat", destinationURL(bucket, rel)+"#"+generation)
+		if err != nil {
+			return nil, "", err
+		}
+		return content, generation, nil
+	}
+
+	content, err := os.ReadFile(destinationURL(bucket, rel))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	return content, localObjectVersion(content), nil
+}
+
+// writeObjectIfVersion is writeObject that only replaces rel if it is still
+// at version, as read by readObjectVersion, and only creates it if version is
+// empty. Otherwise it returns an error wrapping errObjectChanged.
+func writeObjectIfVersion(ctx context.Context, bucket, rel string, content []byte, cacheControl, contentType, version string) error {
+	return withTempObject(content, func(src string) error {
+		file := publishFile{src: src, dst: rel, cacheControl: cacheControl, contentType: contentType}
+		if isS3Bucket(bucket) {
+			return s3Put(ctx, bucket, file, ifVersionHeader(version))
+		}
+		if isAzureBucket(bucket) {
+			return azurePut(ctx, bucket, file, ifVersionHeader(version))
+		}
+		if strings.HasPrefix(bucket, "gs://") {
+			// Generation 0 matches only a missing object.
+			_, err := runCmdOutput(ctx, "", nil, "gcloud", gcsCopyArgs(bucket, file, "--if-generation-match="+firstNonEmpty(version, "0"))...)
+			if err != nil && isPreconditionFailedError(err) {
+				return fmt.Errorf("%s: %w", destinationURL(bucket, rel), errObjectChanged)
+			}
+			return err
+		}
+		return localWriteIfVersion(destinationURL(bucket, rel), src, version)
+	})
+}
+
+// staleLocalLockAge is how old a <target>.lock must be before
+// localWriteIfVersion assumes its writer crashed. Holders keep it for
+// milliseconds.
+const staleLocalLockAge = 30 * time.Second
+
+// localWriteIfVersion compares and replaces target while holding
+// <target>.lock, so publishes sharing a directory take turns.
+func localWriteIfVersion(target, src, version string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	lockPath := target + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > staleLocalLockAge {
+			fmt.Printf("removing stale lock %s from %s\n", lockPath, info.ModTime().Format(time.RFC3339))
+			if err := os.Remove(lockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			lock, err = os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		}
+	}
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s is locked by another writer; remove %s if none is running: %w", target, lockPath, errObjectChanged)
+	}
+	if err != nil {
+		return err
+	}
+	_ = lock.Close()
+	defer os.Remove(lockPath)
+
+	current, err := os.ReadFile(target)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if version != "" {
+			return fmt.Errorf("%s: %w", target, errObjectChanged)
+		}
+	case err != nil:
+		return err
+	case localObjectVersion(current) != version:
+		return fmt.Errorf("%s: %w", target, errObjectChanged)
+	}
+
+	tmp := target + ".tmp"
+	if err := copyFile(src, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+func localObjectVersion(content []byte) string {
+	return sha256Hex(content)
+}
+
+func isPreconditionFailedError(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "412") || strings.Contains(message, "precondition")
 }
 
 func isMissingVersionMarkerError(err error) bool {
@@ -333,14 +852,20 @@ func versionMatches(desired, current releaseVersion) bool {
 		desired.Bucket == current.Bucket
 }
 
-func resolveRepoSource(value string) (repoSource, error) {
+// resolveRepoSource maps --web-repo to a clone URL. GitHub slugs clone over
+// SSH when only an SSH key is given, otherwise over HTTPS.
+func resolveRepoSource(value string, auth gitAuth) (repoSource, error) {
 	value = strings.TrimSpace(value)
 	if value == "" {
 		return repoSource{}, errors.New("empty repo")
 	}
+	gitEnv, err := auth.gitEnv()
+	if err != nil {
+		return repoSource{}, err
+	}
 
 	if strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "ssh://") || strings.HasPrefix(value, "git@") || strings.HasPrefix(value, "file://") {
-		return repoSource{identity: value, cloneSource: value}, nil
+		return repoSource{identity: value, cloneSource: value, gitEnv: gitEnv}, nil
 	}
 
 	if _, err := os.Stat(value); err == nil {
@@ -348,13 +873,18 @@ func resolveRepoSource(value string) (repoSource, error) {
 		if err != nil {
 			return repoSource{}, err
 		}
-		return repoSource{identity: abs, cloneSource: abs}, nil
+		return repoSource{identity: abs, cloneSource: abs, gitEnv: gitEnv}, nil
 	}
 
 	if isGitHubSlug(value) {
+		cloneSource := "https://github.com/" + value + ".git"
+		if auth.sshKey != "" && auth.tokenFile == "" {
+			cloneSource = "git@github.com:" + value + ".git"
+		}
 		return repoSource{
 			identity:    value,
-			cloneSource: "https://github.com/" + value + ".git",
+			cloneSource: cloneSource,
+			gitEnv:      gitEnv,
 		}, nil
 	}
 
@@ -366,36 +896,47 @@ func isGitHubSlug(value string) bool {
 	return len(parts) == 2 && parts[0] != "" && parts[1] != ""
 }
 
-func gitRemoteBranchHead(ctx context.Context, repo, branch string) (string, error) {
-	out, err := runCmdOutput(ctx, "", nil, "git", "ls-remote", repo, "refs/heads/"+branch)
+// gitRemoteRef resolves name to a commit in repo, preferring a branch over a
+// tag of the same name. Annotated tags resolve to the commit they point at.
+func gitRemoteRef(ctx context.Context, repo, name string, env []string) (sha string, tag bool, err error) {
+	out, err := runCmdOutput(ctx, "", env, "git", "ls-remote", repo, "refs/heads/"+name, "refs/tags/"+name, "refs/tags/"+name+"^{}")
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
-	line := strings.TrimSpace(string(out))
-	if line == "" {
-		return "", fmt.Errorf("branch %q not found in %s", branch, repo)
+	refs := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
 	}
-
-	fields := strings.Fields(line)
-	if len(fields) < 1 || fields[0] == "" {
-		return "", fmt.Errorf("unexpected git ls-remote output for %s %s: %q", repo, branch, line)
+	if sha := refs["refs/heads/"+name]; sha != "" {
+		return sha, false, nil
 	}
-	return fields[0], nil
+	if sha := firstNonEmpty(refs["refs/tags/"+name+"^{}"], refs["refs/tags/"+name]); sha != "" {
+		return sha, true, nil
+	}
+	return "", false, fmt.Errorf("branch or tag %q not found in %s", name, repo)
 }
 
-func gitCloneAndCheckout(ctx context.Context, dst, repo, branch, sha string) error {
-	cloneSource := repo
-	if isLocalPath(repo) {
-		cloneSource = "file://" + filepath.ToSlash(repo)
-	}
-	if err := runCmd(ctx, "", nil, "git", "clone", "--depth", "1", "--branch", branch, cloneSource, dst); err != nil {
-		return err
-	}
-	return runCmd(ctx, dst, nil, "git", "checkout", sha)
+// isRemoteBucket reports whether bucket names an object store rather than a
+// local directory.
+func isRemoteBucket(bucket string) bool {
+	return strings.HasPrefix(bucket, "gs://") || isS3Bucket(bucket) || isAzureBucket(bucket)
 }
 
 func destinationURL(bucket, rel string) string {
 	rel = filepath.ToSlash(rel)
+	if isS3Bucket(bucket) {
+		if loc, err := parseS3Location(bucket); err == nil {
+			return loc.displayURL(rel)
+		}
+	}
+	if isAzureBucket(bucket) {
+		if loc, err := parseAzureLocation(bucket); err == nil {
+			return loc.displayURL(rel)
+		}
+	}
 	if strings.HasPrefix(bucket, "gs://") {
 		return strings.TrimRight(bucket, "/") + "/" + strings.TrimLeft(rel, "/")
 	}
@@ -416,6 +957,11 @@ func runCmd(ctx context.Context, dir string, env []string, name string, args ...
 	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	if log := logWriter(ctx); log != nil {
+		fmt.Fprintf(log, "$ %s %s\n", name, strings.Join(args, " "))
+		cmd.Stdout = io.MultiWriter(os.Stdout, log)
+		cmd.Stderr = io.MultiWriter(os.Stderr, log)
+	}
 	return cmd.Run()
 }
 
@@ -463,6 +1009,27 @@ func assertFile(path string) error {
 	return nil
 }
 
+// copyDir copies the regular files under src into dst, creating dst.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
 func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
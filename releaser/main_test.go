@@ -1,6 +1,13 @@
 package main
 
-import "testing"
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestIsMissingVersionMarkerError(t *testing.T) {
 	t.Parallel()
@@ -83,3 +90,35 @@ type errString string
 func (e errString) Error() string {
 	return string(e)
 }
+
+func TestLocalWriteIfVersionLocks(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "history.jsonl")
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lockPath := target + ".lock"
+	if err := os.WriteFile(lockPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := localWriteIfVersion(target, src, "")
+	if !errors.Is(err, errObjectChanged) || !strings.Contains(err.Error(), lockPath) {
+		t.Fatalf("write under a held lock = %v, want errObjectChanged naming %s", err, lockPath)
+	}
+
+	// A lock left by a crashed writer is taken over.
+	old := time.Now().Add(-2 * staleLocalLockAge)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := localWriteIfVersion(target, src, ""); err != nil {
+		t.Fatalf("write under a stale lock = %v", err)
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Fatalf("lock left behind: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	manifestFileName = "manifest.yaml"
+	// viteManifestPath is where Vite writes its build manifest. It maps
+	// output files back to the modules they were built from, and is build
+	// metadata rather than part of the site.
+	viteManifestDir  = ".vite"
+	viteManifestPath = viteManifestDir + "/manifest.json"
+)
+
+// releaseManifest records the size and digest of every published file so a
+// release can be compared without downloading it.
+type releaseManifest struct {
+	Files []manifestFile `yaml:"files" json:"files"`
+}
+
+type manifestFile struct {
+	Path   string `yaml:"path" json:"path"`
+	Size   int64  `yaml:"size" json:"size"`
+	SHA256 string `yaml:"sha256" json:"sha256"`
+	// Chunk names the Vite chunk a JS file was built as, such as
+	// "src/main.tsx". Unlike the file name, it stays the same across builds.
+	Chunk string `yaml:"chunk,omitempty" json:"chunk,omitempty"`
+}
+
+// buildManifest hashes files and names their chunks from the Vite manifest
+// in distDir. The release markers themselves are left out because they
+// differ on every build.
+func buildManifest(distDir string, files []publishFile) (releaseManifest, error) {
+	chunks, err := readViteChunks(distDir)
+	if err != nil {
+		return releaseManifest{}, fmt.Errorf("read %s: %w", viteManifestPath, err)
+	}
+	manifest := releaseManifest{Files: []manifestFile{}}
+	for _, file := range files {
+		if file.dst == versionFileName || file.dst == manifestFileName {
+			continue
+		}
+		size, digest, err := fileDigest(file.src)
+		if err != nil {
+			return releaseManifest{}, err
+		}
+		manifest.Files = append(manifest.Files, manifestFile{
+			Path:   file.dst,
+			Size:   size,
+			SHA256: digest,
+			Chunk:  chunks[file.dst],
+		})
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	return manifest, nil
+}
+
+// readViteChunks maps each JS file in the Vite manifest to its chunk: the
+// source module for entries and dynamic imports, or "_<name>" for shared
+// chunks. Every chunk is written as index.<hash>.js, so this is the only
+// way to tell one build's chunks apart from the next. Names that more than
+// one file claims are dropped. A build without a Vite manifest has no
+// chunk names.
+func readViteChunks(distDir string) (map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(distDir, filepath.FromSlash(viteManifestPath)))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]struct {
+		File string `json:"file"`
+		Src  string `json:"src"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+
+	chunks := map[string]string{}
+	claims := map[string]int{}
+	for _, entry := range entries {
+		if path.Ext(entry.File) != ".js" {
+			continue
+		}
+		chunk := entry.Src
+		if chunk == "" && entry.Name != "" {
+			chunk = "_" + entry.Name
+		}
+		if chunk == "" {
+			continue
+		}
+		chunks[entry.File] = chunk
+		claims[chunk]++
+	}
+	for file, chunk := range chunks {
+		if claims[chunk] > 1 {
+			delete(chunks, file)
+		}
+	}
+	return chunks, nil
+}
+
+// fileIdentity names what a file is across builds: its chunk for JS, or its
+// path without the content hash for named assets. JS files without a chunk
+// keep their own path, since the hash is all that tells them apart.
+func fileIdentity(file manifestFile) string {
+	switch {
+	case file.Chunk != "":
+		return file.Chunk
+	case path.Ext(file.Path) == ".js":
+		return file.Path
+	default:
+		return unhashedName(file.Path)
+	}
+}
+
+func writeManifestYAML(distDir string, manifest releaseManifest) error {
+	content, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(distDir, manifestFileName), content, 0o644)
+}
+
+func readManifest(ctx context.Context, bucket, rel string) (releaseManifest, bool, error) {
+	content, exists, err := readObject(ctx, bucket, rel)
+	if err != nil || !exists {
+		return releaseManifest{}, false, err
+	}
+	var manifest releaseManifest
+	if err := yaml.Unmarshal(content, &manifest); err != nil {
+		return releaseManifest{}, false, err
+	}
+	return manifest, true, nil
+}
+
+func fileDigest(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
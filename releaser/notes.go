@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Release notes are written into the release next to version.yaml.
+const (
+	releaseNotesFileName     = "release-notes.md"
+	releaseNotesJSONFileName = "release-notes.json"
+	changesetDir             = ".changeset"
+)
+
+// releaseNotes describes what moved between the published commit and the
+// commit being released.
+type releaseNotes struct {
+	From       string                `json:"from"`
+	To         string                `json:"to"`
+	Rollback   bool                  `json:"rollback,omitempty"`
+	Sections   []releaseNotesSection `json:"sections"`
+	Changesets []changesetEntry      `json:"changesets,omitempty"`
+}
+
+type releaseNotesSection struct {
+	Title   string          `json:"title"`
+	Commits []releaseCommit `json:"commits"`
+}
+
+type releaseCommit struct {
+	SHA      string `json:"sha"`
+	Type     string `json:"type,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Subject  string `json:"subject"`
+	Author   string `json:"author"`
+	Breaking bool   `json:"breaking,omitempty"`
+}
+
+// changesetEntry is one .changeset/*.md file added in the range.
+type changesetEntry struct {
+	File    string            `json:"file"`
+	Bumps   map[string]string `json:"bumps"`
+	Summary string            `json:"summary"`
+}
+
+// conventionalCommitPattern matches `type(scope)!: subject`.
+var conventionalCommitPattern = regexp.MustCompile(`^([a-zA-Z]+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+
+// releaseNotesSectionOrder maps conventional commit types to section titles
+// in output order. Types not listed fall into "Other changes".
+var releaseNotesSectionOrder = []struct {
+	title string
+	types []string
+}{
+	{"Breaking changes", nil},
+	{"Features", []string{"feat", "feature"}},
+	{"Bug fixes", []string{"fix", "bugfix"}},
+	{"Performance", []string{"perf"}},
+	{"Reverts", []string{"revert"}},
+	{"Refactoring", []string{"refactor"}},
+	{"Documentation", []string{"docs"}},
+	{"Build and CI", []string{"build", "ci"}},
+	{"Tests", []string{"test", "tests"}},
+	{"Chores", []string{"chore", "style", "deps"}},
+	{"Other changes", nil},
+}
+
+// generateReleaseNotes compares from and to in the clone at repoDir, running
+// git with env so missing objects can be fetched. When to is an ancestor of
+// from the release is a rollback and the notes list the commits being taken
+// out of production instead.
+func generateReleaseNotes(ctx context.Context, repoDir string, env []string, from, to string) (releaseNotes, error) {
+	if err := ensureCommit(ctx, repoDir, env, from); err != nil {
+		return releaseNotes{}, fmt.Errorf("fetch published commit %s: %w", shortSHA(from, shortSHALen), err)
+	}
+	notes := releaseNotes{From: from, To: to, Sections: []releaseNotesSection{}}
+
+	rangeSpec := from + ".." + to
+	if _, err := runCmdOutput(ctx, repoDir, env, "git", "merge-base", "--is-ancestor", to, from); err == nil && from != to {
+		notes.Rollback = true
+		rangeSpec = to + ".." + from
+	}
+
+	commits, err := gitRangeCommits(ctx, repoDir, env, rangeSpec)
+	if err != nil {
+		return releaseNotes{}, err
+	}
+	notes.Sections = groupReleaseCommits(commits)
+
+	if !notes.Rollback {
+		if notes.Changesets, err = addedChangesets(ctx, repoDir, env, from, to); err != nil {
+			return releaseNotes{}, err
+		}
+	}
+	return notes, nil
+}
+
+// ensureCommit fetches sha when the clone does not have it, for example when
+// the published release came from another branch.
+func ensureCommit(ctx context.Context, repoDir string, env []string, sha string) error {
+	if _, err := runCmdOutput(ctx, repoDir, env, "git", "cat-file", "-e", sha+"^{commit}"); err == nil {
+		return nil
+	}
+	_, err := runCmdOutput(ctx, repoDir, env, "git", "fetch", "--filter=blob:none", "origin", sha)
+	return err
+}
+
+func gitRangeCommits(ctx context.Context, repoDir string, env []string, rangeSpec string) ([]releaseCommit, error) {
+	out, err := runCmdOutput(ctx, repoDir, env, "git", "log", "--no-merges", "--format=%H%x1f%an%x1f%s%x1f%b%x1e", rangeSpec)
+	if err != nil {
+		return nil, fmt.Errorf("git log %s: %w", rangeSpec, err)
+	}
+	commits := []releaseCommit{}
+	for _, record := range strings.Split(string(out), "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) < 4 {
+			continue
+		}
+		commits = append(commits, parseReleaseCommit(fields[0], fields[1], fields[2], fields[3]))
+	}
+	return commits, nil
+}
+
+func parseReleaseCommit(sha, author, subject, body string) releaseCommit {
+	commit := releaseCommit{SHA: sha, Author: author, Subject: subject}
+	if match := conventionalCommitPattern.FindStringSubmatch(subject); match != nil {
+		commit.Type = strings.ToLower(match[1])
+		commit.Scope = match[2]
+		commit.Breaking = match[3] == "!"
+		commit.Subject = match[4]
+	}
+	if strings.Contains(body, "BREAKING CHANGE:") || strings.Contains(body, "BREAKING-CHANGE:") {
+		commit.Breaking = true
+	}
+	return commit
+}
+
+// groupReleaseCommits sorts commits into sections, keeping git log order
+// within each. Breaking commits are listed only under "Breaking changes".
+func groupReleaseCommits(commits []releaseCommit) []releaseNotesSection {
+	byTitle := map[string][]releaseCommit{}
+	for _, commit := range commits {
+		title := "Other changes"
+		if commit.Breaking {
+			title = "Breaking changes"
+		} else {
+			for _, section := range releaseNotesSectionOrder {
+				for _, typ := range section.types {
+					if commit.Type == typ {
+						title = section.title
+					}
+				}
+			}
+		}
+		byTitle[title] = append(byTitle[title], commit)
+	}
+	sections := []releaseNotesSection{}
+	for _, section := range releaseNotesSectionOrder {
+		if commits := byTitle[section.title]; len(commits) > 0 {
+			sections = append(sections, releaseNotesSection{Title: section.title, Commits: commits})
+		}
+	}
+	return sections
+}
+
+// addedChangesets returns the changeset files present at to that were not
+// present at from. Changesets already consumed by `changeset version` are
+// gone from to and show up as commits only.
+func addedChangesets(ctx context.Context, repoDir string, env []string, from, to string) ([]changesetEntry, error) {
+	out, err := runCmdOutput(ctx, repoDir, env, "git", "diff", "--name-only", "--diff-filter=A", from, to, "--", changesetDir)
+	if err != nil {
+		return nil, fmt.Errorf("list added changesets: %w", err)
+	}
+	entries := []changesetEntry{}
+	for _, name := range strings.Fields(string(out)) {
+		if path.Ext(name) != ".md" || strings.EqualFold(path.Base(name), "README.md") {
+			continue
+		}
+		content, err := runCmdOutput(ctx, repoDir, env, "git", "show", to+":"+name)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		entry, err := parseChangeset(content)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+		entry.File = name
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseChangeset reads a changeset file: YAML front matter mapping package
+// names to bump types, then a markdown summary.
+func parseChangeset(content []byte) (changesetEntry, error) {
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	rest, ok := strings.CutPrefix(text, "---\n")
+	if !ok {
+		return changesetEntry{}, fmt.Errorf("missing front matter")
+	}
+	frontMatter, summary, ok := strings.Cut(rest, "\n---")
+	if !ok {
+		return changesetEntry{}, fmt.Errorf("unterminated front matter")
+	}
+	entry := changesetEntry{Bumps: map[string]string{}, Summary: strings.TrimSpace(summary)}
+	if err := yaml.Unmarshal([]byte(frontMatter), &entry.Bumps); err != nil {
+		return changesetEntry{}, err
+	}
+	return entry, nil
+}
+
+func renderReleaseNotesMarkdown(notes releaseNotes) []byte {
+	var buf bytes.Buffer
+	from, to := shortSHA(notes.From, shortSHALen), shortSHA(notes.To, shortSHALen)
+	if notes.Rollback {
+		fmt.Fprintf(&buf, "# Rollback %s -> %s\n\nThis release takes the following commits out of production.\n", from, to)
+	} else {
+		fmt.Fprintf(&buf, "# Release %s -> %s\n", from, to)
+	}
+	if len(notes.Sections) == 0 && len(notes.Changesets) == 0 {
+		buf.WriteString("\nNo changes.\n")
+	}
+
+	if len(notes.Changesets) > 0 {
+		buf.WriteString("\n## Changesets\n\n")
+		for _, entry := range notes.Changesets {
+			packages := make([]string, 0, len(entry.Bumps))
+			for pkg, bump := range entry.Bumps {
+				packages = append(packages, fmt.Sprintf("%s (%s)", pkg, bump))
+			}
+			sort.Strings(packages)
+			summary, _, _ := strings.Cut(entry.Summary, "\n")
+			fmt.Fprintf(&buf, "- %s: %s\n", strings.Join(packages, ", "), summary)
+		}
+	}
+
+	for _, section := range notes.Sections {
+		fmt.Fprintf(&buf, "\n## %s\n\n", section.Title)
+		for _, commit := range section.Commits {
+			subject := commit.Subject
+			if commit.Scope != "" {
+				subject = "**" + commit.Scope + ":** " + subject
+			}
+			fmt.Fprintf(&buf, "- %s (%s, %s)\n", subject, shortSHA(commit.SHA, shortSHALen), commit.Author)
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeReleaseNotes generates the notes for from..to, writes both forms into
+// distDir, and prints the markdown.
+func writeReleaseNotes(ctx context.Context, repoDir, distDir string, env []string, from, to string) error {
+	notes, err := generateReleaseNotes(ctx, repoDir, env, from, to)
+	if err != nil {
+		return err
+	}
+	markdown := renderReleaseNotesMarkdown(notes)
+	notesJSON, err := json.MarshalIndent(notes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(distDir, releaseNotesFileName), markdown, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(distDir, releaseNotesJSONFileName), append(notesJSON, '\n'), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", markdown)
+	return nil
+}
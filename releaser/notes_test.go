@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateReleaseNotes(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	ctx := context.Background()
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Dev", "GIT_AUTHOR_EMAIL=dev@example.com",
+			"GIT_COMMITTER_NAME=Dev", "GIT_COMMITTER_EMAIL=dev@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(file, content, message string) string {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(repo, file)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(repo, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "-A")
+		git("commit", "-q", "-m", message)
+		return git("rev-parse", "HEAD")
+	}
+
+	git("init", "-q")
+	from := commit("README.md", "v1", "chore: initial import")
+	commit("a.txt", "a", "feat(editor): add cell folding")
+	commit("b.txt", "b", "fix: keep cursor on reload")
+	commit("c.txt", "c", "refactor!: drop legacy runner protocol")
+	commit(".changeset/brave-owls.md", "---\n\"@runmedev/renderers\": minor\n---\n\nAdd table renderer\n", "Add changeset")
+	to := commit("d.txt", "d", "feat: api\n\nBREAKING CHANGE: new config")
+
+	notes, err := generateReleaseNotes(ctx, repo, nil, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, section := range notes.Sections {
+		titles = append(titles, section.Title)
+	}
+	if got := strings.Join(titles, ","); got != "Breaking changes,Features,Bug fixes,Other changes" {
+		t.Fatalf("sections = %s", got)
+	}
+	if breaking := notes.Sections[0].Commits; len(breaking) != 2 || breaking[0].Subject != "api" {
+		t.Fatalf("breaking = %+v", breaking)
+	}
+	if feat := notes.Sections[1].Commits[0]; feat.Scope != "editor" || feat.Subject != "add cell folding" {
+		t.Fatalf("feature = %+v", feat)
+	}
+	if len(notes.Changesets) != 1 || notes.Changesets[0].Bumps["@runmedev/renderers"] != "minor" || notes.Changesets[0].Summary != "Add table renderer" {
+		t.Fatalf("changesets = %+v", notes.Changesets)
+	}
+
+	markdown := string(renderReleaseNotesMarkdown(notes))
+	for _, want := range []string{
+		"# Release " + shortSHA(from, shortSHALen) + " -> " + shortSHA(to, shortSHALen),
+		"## Changesets\n\n- @runmedev/renderers (minor): Add table renderer",
+		"- **editor:** add cell folding (",
+	} {
+		if !strings.Contains(markdown, want) {
+			t.Fatalf("markdown missing %q:\n%s", want, markdown)
+		}
+	}
+
+	rollback, err := generateReleaseNotes(ctx, repo, nil, to, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rollback.Rollback || len(rollback.Changesets) != 0 || len(rollback.Sections) != 4 {
+		t.Fatalf("rollback = %+v", rollback)
+	}
+}
+
+func TestParseReleaseCommit(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		subject  string
+		typ      string
+		breaking bool
+	}{
+		{"feat: add picker", "feat", false},
+		{"Fix(drive)!: rename scope", "fix", true},
+		{"Merge branch 'main'", "", false},
+		{"docs : spacing", "", false},
+	} {
+		got := parseReleaseCommit("sha", "dev", tc.subject, "")
+		if got.Type != tc.typ || got.Breaking != tc.breaking {
+			t.Errorf("parseReleaseCommit(%q) = %+v", tc.subject, got)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Release outcomes reported to webhooks.
+const (
+	releaseOutcomePublished = "published"
+	releaseOutcomeNoop      = "noop"
+	releaseOutcomeFailed    = "failed"
+)
+
+// Webhook body formats.
+const (
+	webhookFormatJSON     = "json"
+	webhookFormatSlack    = "slack"
+	webhookFormatTemplate = "template"
+)
+
+const defaultWebhookRetries = 3
+
+// webhookBackoff is the delay before the first retry; it doubles after each
+// attempt.
+var webhookBackoff = time.Second
+
+// webhookConfig is one entry under notifications in --config.
+type webhookConfig struct {
+	Name string `yaml:"name"`
+	// URL is the endpoint; URLEnv names an environment variable holding it
+	// instead, which keeps webhook secrets such as Slack URLs out of the file.
+	URL    string `yaml:"url"`
+	URLEnv string `yaml:"urlEnv"`
+	// Format is json (the default), slack, or template.
+	Format string `yaml:"format"`
+	// Template is a Go text/template rendered with the releaseEvent when
+	// Format is template.
+	Template    string `yaml:"template"`
+	ContentType string `yaml:"contentType"`
+	// Events limits the outcomes sent; empty means all of them.
+	Events  []string `yaml:"events"`
+	Retries *int     `yaml:"retries"`
+}
+
+// releaseEvent is the structured payload describing a release outcome.
+type releaseEvent struct {
+	Outcome   string          `json:"outcome"`
+	Version   releaseVersion  `json:"version"`
+	Previous  *releaseVersion `json:"previous,omitempty"`
+	Bucket    string          `json:"bucket"`
+	Publisher string          `json:"publisher"`
+	Timestamp string          `json:"timestamp"`
+	Duration  string          `json:"duration"`
+	Files     int             `json:"files"`
+	Deleted   int             `json:"deleted,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type webhook struct {
+	name        string
+	url         string
+	format      string
+	template    *template.Template
+	contentType string
+	events      []string
+	retries     int
+}
+
+// newWebhooks validates the configured webhooks up front, so a broken
+// template or a missing URL variable fails before anything is built.
+func newWebhooks(cfgs []webhookConfig) ([]webhook, error) {
+	hooks := make([]webhook, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		hook := webhook{
+			name:        firstNonEmpty(cfg.Name, fmt.Sprintf("notifications[%d]", i)),
+			url:         cfg.URL,
+			format:      firstNonEmpty(cfg.Format, webhookFormatJSON),
+			contentType: firstNonEmpty(cfg.ContentType, "application/json"),
+			events:      cfg.Events,
+			retries:     defaultWebhookRetries,
+		}
+		if cfg.URLEnv != "" {
+			hook.url = os.Getenv(cfg.URLEnv)
+			if hook.url == "" {
+				return nil, fmt.Errorf("%s: environment variable %s is not set", hook.name, cfg.URLEnv)
+			}
+		}
+		if hook.url == "" {
+			return nil, fmt.Errorf("%s: url or urlEnv is required", hook.name)
+		}
+		if cfg.Retries != nil {
+			hook.retries = *cfg.Retries
+		}
+		for _, event := range cfg.Events {
+			if event != releaseOutcomePublished && event != releaseOutcomeNoop && event != releaseOutcomeFailed {
+				return nil, fmt.Errorf("%s: unknown event %q", hook.name, event)
+			}
+		}
+		switch hook.format {
+		case webhookFormatJSON, webhookFormatSlack:
+		case webhookFormatTemplate:
+			tmpl, err := template.New(hook.name).Funcs(webhookTemplateFuncs).Option("missingkey=error").Parse(cfg.Template)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", hook.name, err)
+			}
+			hook.template = tmpl
+		default:
+			return nil, fmt.Errorf("%s: unknown format %q", hook.name, hook.format)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		content, err := json.Marshal(value)
+		return string(content), err
+	},
+	"short": func(sha string) string {
+		return shortSHA(sha, shortSHALen)
+	},
+}
+
+// notifyRelease sends event to every webhook subscribed to its outcome.
+// Delivery failures are reported but never change the release result.
+func notifyRelease(ctx context.Context, hooks []webhook, event releaseEvent) {
+	for _, hook := range hooks {
+		if len(hook.events) > 0 && !slices.Contains(hook.events, event.Outcome) {
+			continue
+		}
+		if err := hook.send(ctx, event); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: notify %s: %v\n", hook.name, err)
+		}
+	}
+}
+
+func (h webhook) send(ctx context.Context, event releaseEvent) error {
+	body, err := h.render(event)
+	if err != nil {
+		return err
+	}
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := h.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= h.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying: network errors, 429, and 5xx are; other statuses are not.
+func (h webhook) post(ctx context.Context, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	// The URL is left out of every error on purpose; webhook URLs are
+	// secrets, and *url.Error would print them.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("webhook request invalid: %w", withoutURL(err))
+	}
+	req.Header.Set("Content-Type", h.contentType)
+	req.Header.Set("User-Agent", "runme-web-releaser")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("webhook request failed: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook responded %s", resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// withoutURL returns the cause of a *url.Error, dropping the URL it names.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+func (h webhook) render(event releaseEvent) ([]byte, error) {
+	switch h.format {
+	case webhookFormatSlack:
+		return json.Marshal(slackReleaseMessage(event))
+	case webhookFormatTemplate:
+		var buf bytes.Buffer
+		if err := h.template.Execute(&buf, event); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return json.Marshal(event)
+	}
+}
+
+// slackReleaseMessage formats event as a Slack Block Kit message, with a
+// plain text fallback for notifications.
+func slackReleaseMessage(event releaseEvent) map[string]any {
+	title := map[string]string{
+		releaseOutcomePublished: "web.runme.dev release published",
+		releaseOutcomeNoop:      "web.runme.dev release already current",
+		releaseOutcomeFailed:    "web.runme.dev release failed",
+	}[event.Outcome]
+
+	mrkdwn := func(text string) map[string]string {
+		return map[string]string{"type": "mrkdwn", "text": text}
+	}
+	fields := []map[string]string{
+		mrkdwn("*Commit*\n" + slackCommitLink(event.Version.WebRepo, event.Version.WebCommit)),
+		mrkdwn("*Branch*\n" + firstNonEmpty(event.Version.WebBranch, "-")),
+		mrkdwn("*Bucket*\n" + event.Bucket),
+		mrkdwn("*Duration*\n" + event.Duration),
+	}
+	if event.Previous != nil {
+		fields = append(fields, mrkdwn("*Previous*\n"+slackCommitLink(event.Previous.WebRepo, event.Previous.WebCommit)))
+	}
+	if event.Files > 0 {
+		fields = append(fields, mrkdwn(fmt.Sprintf("*Files*\n%d", event.Files)))
+	}
+
+	blocks := []map[string]any{
+		{"type": "header", "text": map[string]string{"type": "plain_text", "text": title}},
+		{"type": "section", "fields": fields},
+	}
+	if event.Error != "" {
+		blocks = append(blocks, map[string]any{"type": "section", "text": mrkdwn("*Error*\n```" + strings.ReplaceAll(event.Error, "```", "'''") + "```")})
+	}
+	blocks = append(blocks, map[string]any{
+		"type":     "context",
+		"elements": []map[string]string{mrkdwn("by " + event.Publisher + " at " + event.Timestamp)},
+	})
+	return map[string]any{
+		"text":   fmt.Sprintf("%s: %s", title, shortSHA(event.Version.WebCommit, shortSHALen)),
+		"blocks": blocks,
+	}
+}
+
+// slackCommitLink links commits of GitHub slugs and prints others as is.
+func slackCommitLink(repo, sha string) string {
+	if sha == "" {
+		return "-"
+	}
+	short := shortSHA(sha, shortSHALen)
+	if isGitHubSlug(repo) {
+		return fmt.Sprintf("<https://github.com/%s/commit/%s|%s>", repo, sha, short)
+	}
+	return "`" + short + "`"
+}
+
+// finishReleaseEvent fills in the outcome fields once a release is done.
+func finishReleaseEvent(event *releaseEvent, started time.Time, err error) {
+	event.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	event.Duration = time.Since(started).Round(time.Second).String()
+	if err != nil {
+		event.Outcome = releaseOutcomeFailed
+		event.Error = err.Error()
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	bodies   [][]byte
+	failures int
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "try again", http.StatusInternalServerError)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	r.bodies = append(r.bodies, body)
+}
+
+func TestNotifyRelease(t *testing.T) {
+	webhookBackoff = time.Millisecond
+	receiver := &webhookReceiver{failures: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	t.Setenv("RELEASER_TEST_SLACK_URL", server.URL+"/slack")
+	hooks, err := newWebhooks([]webhookConfig{
+		{Name: "generic", URL: server.URL + "/json"},
+		{Name: "slack", URLEnv: "RELEASER_TEST_SLACK_URL", Format: webhookFormatSlack, Events: []string{releaseOutcomeFailed}},
+		{Name: "text", URL: server.URL + "/text", Format: webhookFormatTemplate, ContentType: "text/plain",
+			Template: `{{.Outcome}} {{short .Version.WebCommit}} {{json .Files}}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := releaseEvent{
+		Outcome:   releaseOutcomePublished,
+		Version:   releaseVersion{WebRepo: "runmedev/web", WebBranch: "main", WebCommit: "2222222222aaaa"},
+		Previous:  &releaseVersion{WebRepo: "runmedev/web", WebCommit: "1111111111aaaa"},
+		Bucket:    "gs://bucket",
+		Publisher: "tester",
+		Files:     3,
+	}
+	finishReleaseEvent(&event, time.Now(), nil)
+	notifyRelease(context.Background(), hooks, event)
+
+	// The generic hook is retried past two 500s; the slack hook only wants
+	// failures.
+	if len(receiver.bodies) != 2 {
+		t.Fatalf("received %d bodies, want 2", len(receiver.bodies))
+	}
+	var got releaseEvent
+	if err := json.Unmarshal(receiver.bodies[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Outcome != releaseOutcomePublished || got.Previous == nil || got.Previous.WebCommit != "1111111111aaaa" || got.Files != 3 {
+		t.Fatalf("json payload = %+v", got)
+	}
+	if text := string(receiver.bodies[1]); text != "published 22222222 3" {
+		t.Fatalf("template payload = %q", text)
+	}
+
+	receiver.bodies = nil
+	failed := releaseEvent{Outcome: releaseOutcomePublished, Version: event.Version, Bucket: event.Bucket}
+	finishReleaseEvent(&failed, time.Now(), io.ErrUnexpectedEOF)
+	notifyRelease(context.Background(), hooks, failed)
+	if len(receiver.bodies) != 3 {
+		t.Fatalf("received %d bodies, want 3", len(receiver.bodies))
+	}
+	slack := string(receiver.bodies[1])
+	for _, want := range []string{`"type":"header"`, "release failed", "https://github.com/runmedev/web/commit/2222222222aaaa", "unexpected EOF"} {
+		if !strings.Contains(slack, want) {
+			t.Fatalf("slack payload missing %q:\n%s", want, slack)
+		}
+	}
+}
+
+func TestWebhookErrorsOmitURL(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	secretURL := server.URL + "/services/T000/B000/secret-token"
+	server.Close()
+
+	retryable, err := webhook{url: secretURL, contentType: "application/json"}.post(context.Background(), []byte("{}"))
+	if err == nil || !retryable {
+		t.Fatalf("post() to a closed server = %v, %v", retryable, err)
+	}
+	if strings.Contains(err.Error(), "secret-token") || !strings.HasPrefix(err.Error(), "webhook request failed: ") {
+		t.Fatalf("post() error = %q", err)
+	}
+}
+
+func TestNewWebhooksRejectsBadConfig(t *testing.T) {
+	for _, cfg := range []webhookConfig{
+		{Name: "no-url"},
+		{Name: "unset-env", URLEnv: "RELEASER_TEST_UNSET_WEBHOOK"},
+		{Name: "format", URL: "http://example.invalid", Format: "xml"},
+		{Name: "event", URL: "http://example.invalid", Events: []string{"started"}},
+		{Name: "template", URL: "http://example.invalid", Format: webhookFormatTemplate, Template: "{{.Outcome"},
+	} {
+		if _, err := newWebhooks([]webhookConfig{cfg}); err == nil {
+			t.Errorf("newWebhooks(%s) succeeded", cfg.Name)
+		}
+	}
+}
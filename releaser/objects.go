@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Helpers shared by the HTTP-based object store destinations (s3://, az://).
+
+// objectClient sends every object store request. The timeout is generous
+// enough for the largest release file over a slow link, but keeps a stalled
+// upload from hanging the release.
+var objectClient = &http.Client{Timeout: 5 * time.Minute}
+
+// objectDo sends req with objectClient. A transport error keeps only its
+// cause, because *url.Error prints the full URL and with it any SAS token.
+func objectDo(req *http.Request) (*http.Response, error) {
+	resp, err := objectClient.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return nil, fmt.Errorf("%s %s: %w", req.Method, objectErrorURL(req.URL), urlErr.Err)
+	}
+	return resp, err
+}
+
+// objectErrorURL is u as it may appear in errors and logs: without the query
+// and userinfo, which can carry credentials.
+func objectErrorURL(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + u.EscapedPath()
+}
+
+// objectContentType mirrors gcloud storage cp: an explicit content type wins,
+// otherwise it is guessed from the file extension.
+func objectContentType(file publishFile) string {
+	if file.contentType != "" {
+		return file.contentType
+	}
+	if guessed := mime.TypeByExtension(path.Ext(file.dst)); guessed != "" {
+		return guessed
+	}
+	return "application/octet-stream"
+}
+
+func joinObjectKey(prefix, rel string) string {
+	return strings.TrimLeft(path.Join(prefix, filepath.ToSlash(rel)), "/")
+}
+
+// ifVersionHeader returns the preconditions for a write that only replaces an
+// object still at the given ETag, or only creates one when etag is empty.
+func ifVersionHeader(etag string) http.Header {
+	if etag == "" {
+		return http.Header{"If-None-Match": {"*"}}
+	}
+	return http.Header{"If-Match": {etag}}
+}
+
+// preconditionStatusError maps a failed conditional write to errObjectChanged.
+// 409 is what S3 and Azure answer when a concurrent write to the same key is
+// still in flight, or the blob If-None-Match: * protects already exists.
+func preconditionStatusError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%s %s: %s: %w", resp.Request.Method, objectErrorURL(resp.Request.URL), resp.Status, errObjectChanged)
+	}
+	return httpStatusError(resp)
+}
+
+func httpStatusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		return fmt.Errorf("%s %s: %s", resp.Request.Method, objectErrorURL(resp.Request.URL), resp.Status)
+	}
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, objectErrorURL(resp.Request.URL), resp.Status, message)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
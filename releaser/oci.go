@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// OCI image layout constants. The release is stored as an OCI artifact with
+// one layer per published file, following the ORAS file conventions so that
+// `oras pull --oci-layout <dir>:<tag>` restores the dist tree.
+const (
+	ociLayoutVersion     = "1.0.0"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociEmptyMediaType    = "application/vnd.oci.empty.v1+json"
+	ociReleaseType       = "application/vnd.runme.web.release.v1"
+	ociFileMediaType     = "application/vnd.runme.web.file.v1"
+
+	ociAnnotationTitle    = "org.opencontainers.image.title"
+	ociAnnotationRefName  = "org.opencontainers.image.ref.name"
+	ociAnnotationCreated  = "org.opencontainers.image.created"
+	ociAnnotationRevision = "org.opencontainers.image.revision"
+	ociAnnotationSource   = "org.opencontainers.image.source"
+
+	// Cache rules from classifyFile, so whoever serves the unpacked artifact
+	// can reproduce the bucket object metadata.
+	ociAnnotationCacheControl       = "dev.runme.web.cache-control"
+	ociAnnotationContentType        = "dev.runme.web.content-type"
+	ociAnnotationContentDisposition = "dev.runme.web.content-disposition"
+	ociAnnotationUploadGroup        = "dev.runme.web.upload-group"
+	ociAnnotationWebBranch          = "dev.runme.web.branch"
+)
+
+type ociDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Data         []byte            `json:"data,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+func newPackageCmd() *cobra.Command {
+	cfg := config{}
+	var (
+		distDir string
+		outDir  string
+		tag     string
+	)
+	cmd := &cobra.Command{
+		Use:   "package --out=<dir> (--web=<branch> | --dist=<dir>)",
+		Short: "Package a release as an OCI artifact in an oci-layout directory",
+		Long: `Package a release for air-gapped self-hosting.
+
+The release is written as an OCI artifact into an OCI image layout directory,
+one layer per file, annotated with the cache-control and content-type the
+bucket upload would have used. Push it to a private registry with
+"oras copy --from-oci-layout <dir>:<tag> <registry>/<repo>:<tag>" or unpack it
+with "oras pull --oci-layout <dir>:<tag>".`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			releaserCfg, err := loadReleaserConfig(cfg.configPath)
+			if err != nil {
+				return fmt.Errorf("load --config: %w", err)
+			}
+			absOut, err := filepath.Abs(outDir)
+			if err != nil {
+				return err
+			}
+			cfg.bucket = "oci-layout:" + absOut
+
+			var build releaseBuild
+			if distDir != "" {
+				// Harden and stamp a copy; the user's dist stays as built.
+				staged, err := os.MkdirTemp(cfg.tmpBase, "package-dist-")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(staged)
+				build, err = stageLocalDist(distDir, filepath.Join(staged, "dist"), releaserCfg.Hardening)
+				if err != nil {
+					return err
+				}
+			} else {
+				if cfg.webBranch == "" {
+					return errors.New("one of --web or --dist is required")
+				}
+				webSource, version, err := resolveRelease(cmd.Context(), cfg)
+				if err != nil {
+					return err
+				}
+				build, err = buildRelease(cmd.Context(), cfg.tmpBase, webSource, version, "", releaserCfg.Hardening, nil)
+				if err != nil {
+					return err
+				}
+			}
+			// An artifact ships like a publish, so it passes the same checks.
+			if err := validateRelease(cmd.Context(), cfg, releaserCfg, build); err != nil {
+				build.worktree.keep("for debugging")
+				return err
+			}
+
+			if tag == "" {
+				tag = shortSHA(build.version.WebCommit, shortSHALen)
+			}
+			if tag == "" {
+				tag = "latest"
+			}
+			digest, err := writeOCILayout(absOut, tag, build)
+			if err != nil {
+				build.worktree.keep("for debugging")
+				return fmt.Errorf("write oci layout: %w", err)
+			}
+			build.worktree.remove(cmd.Context())
+			fmt.Printf("packaged %d files into %s:%s (%s)\n", len(build.files), absOut, tag, digest)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&cfg.webBranch, "web", "", "branch or tag name in the web repo to build")
+	cmd.Flags().StringVar(&cfg.webRepo, "web-repo", defaultWebRepo, "web repo slug, URL, or local path")
+	cmd.Flags().StringVar(&cfg.tmpBase, "tmpdir", os.TempDir(), "base temporary directory")
+	cmd.Flags().StringVar(&cfg.gitTokenFile, "git-token-file", "", "file holding an HTTPS token for cloning the web repo")
+	cmd.Flags().StringVar(&cfg.sshKey, "ssh-key", "", "SSH private key for cloning the web repo")
+	cmd.Flags().StringVar(&distDir, "dist", "", "package an already built dist directory instead of building")
+	cmd.Flags().StringVar(&outDir, "out", "", "oci-layout directory to create or add to")
+	cmd.Flags().StringVar(&cfg.configPath, "config", "", "releaser config file (hardening, secrets, budgets, compatibility)")
+	cmd.Flags().StringVar(&tag, "tag", "", "tag for the artifact in the layout (defaults to the short web commit)")
+	cmd.Flags().BoolVar(&cfg.allowBudgetOverrun, "allow-budget-overrun", false, "package even if bundle budgets are exceeded")
+	cmd.Flags().BoolVar(&cfg.forceIncompatible, "force-incompatible", false, "package even if the backend does not meet the release's declared requirements")
+	_ = cmd.MarkFlagRequired("out")
+	cmd.MarkFlagsMutuallyExclusive("web", "dist")
+
+	return cmd
+}
+
+// finalizeLocalDist prepares a dist directory built outside the releaser.
+// It must carry the version.yaml of the releaser run that built it, so the
+// release keeps its provenance.
+func finalizeLocalDist(distDir string, hardening hardeningConfig) (releaseBuild, error) {
+	content, err := os.ReadFile(filepath.Join(distDir, versionFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return releaseBuild{}, fmt.Errorf("%s has no %s; build it with the releaser, or package with --web", distDir, versionFileName)
+	}
+	if err != nil {
+		return releaseBuild{}, err
+	}
+	version, _, err := parseVersionYAML(content)
+	if err != nil {
+		return releaseBuild{}, fmt.Errorf("parse %s: %w", versionFileName, err)
+	}
+	return finalizeDist(distDir, version, hardening)
+}
+
+// stageLocalDist copies distDir to staged and finalizes the copy. The url-map
+// check still looks at the app distDir was built from.
+func stageLocalDist(distDir, staged string, hardening hardeningConfig) (releaseBuild, error) {
+	absDist, err := filepath.Abs(distDir)
+	if err != nil {
+		return releaseBuild{}, err
+	}
+	if err := copyDir(absDist, staged); err != nil {
+		return releaseBuild{}, fmt.Errorf("copy %s: %w", distDir, err)
+	}
+	build, err := finalizeLocalDist(staged, hardening)
+	build.appDir = filepath.Dir(absDist)
+	return build, err
+}
+
+// writeOCILayout stores build as an artifact in the OCI image layout at dir
+// and points tag at it. Blobs are content addressed, so packaging several
+// releases into the same layout only stores changed files once.
+func writeOCILayout(dir, tag string, build releaseBuild) (string, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0o755); err != nil {
+		return "", err
+	}
+	layout := []byte(`{"imageLayoutVersion":"` + ociLayoutVersion + `"}`)
+	if err := os.WriteFile(filepath.Join(dir, "oci-layout"), layout, 0o644); err != nil {
+		return "", err
+	}
+
+	layers := make([]ociDescriptor, 0, len(build.files))
+	for _, file := range build.files {
+		content, err := os.ReadFile(file.src)
+		if err != nil {
+			return "", err
+		}
+		desc, err := writeOCIBlob(dir, ociFileMediaType, content)
+		if err != nil {
+			return "", err
+		}
+		desc.Annotations = map[string]string{
+			ociAnnotationTitle:        file.dst,
+			ociAnnotationCacheControl: file.cacheControl,
+			ociAnnotationUploadGroup:  strconv.Itoa(file.group),
+		}
+		if file.contentType != "" {
+			desc.Annotations[ociAnnotationContentType] = file.contentType
+		}
+		if file.contentDisposition != "" {
+			desc.Annotations[ociAnnotationContentDisposition] = file.contentDisposition
+		}
+		layers = append(layers, desc)
+	}
+
+	config, err := writeOCIBlob(dir, ociEmptyMediaType, []byte("{}"))
+	if err != nil {
+		return "", err
+	}
+	config.Data = []byte("{}")
+
+	manifestJSON, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		ArtifactType:  ociReleaseType,
+		Config:        config,
+		Layers:        layers,
+		Annotations: map[string]string{
+			ociAnnotationCreated:   build.version.BuildDate,
+			ociAnnotationRevision:  build.version.WebCommit,
+			ociAnnotationSource:    build.version.WebRepo,
+			ociAnnotationWebBranch: build.version.WebBranch,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	manifest, err := writeOCIBlob(dir, ociManifestMediaType, manifestJSON)
+	if err != nil {
+		return "", err
+	}
+	manifest.ArtifactType = ociReleaseType
+	manifest.Annotations = map[string]string{ociAnnotationRefName: tag}
+
+	index, err := readOCIIndex(dir)
+	if err != nil {
+		return "", err
+	}
+	manifests := []ociDescriptor{}
+	for _, existing := range index.Manifests {
+		if existing.Annotations[ociAnnotationRefName] != tag {
+			manifests = append(manifests, existing)
+		}
+	}
+	index.Manifests = append(manifests, manifest)
+	indexJSON, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), indexJSON, 0o644); err != nil {
+		return "", err
+	}
+	return manifest.Digest, nil
+}
+
+func readOCIIndex(dir string) (ociIndex, error) {
+	index := ociIndex{SchemaVersion: 2, MediaType: ociIndexMediaType, Manifests: []ociDescriptor{}}
+	content, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return ociIndex{}, err
+	}
+	if err := json.Unmarshal(content, &index); err != nil {
+		return ociIndex{}, fmt.Errorf("parse index.json: %w", err)
+	}
+	return index, nil
+}
+
+func writeOCIBlob(dir, mediaType string, content []byte) (ociDescriptor, error) {
+	hex := sha256Hex(content)
+	path := filepath.Join(dir, "blobs", "sha256", hex)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			return ociDescriptor{}, err
+		}
+	} else if err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex,
+		Size:      int64(len(content)),
+	}, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteOCILayout(t *testing.T) {
+	t.Parallel()
+
+	dist := writeDist(t, map[string]string{
+		"index.html":        "<html></html>",
+		"index.abcdefgh.js": "console.log(1)",
+		versionFileName:     "webRepo: runmedev/web\nwebCommit: 1111111111\n",
+	})
+	out := filepath.Join(t.TempDir(), "layout")
+	build, err := finalizeLocalDist(dist, hardeningConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := writeOCILayout(out, "v1", build)
+	if err != nil {
+		t.Fatalf("writeOCILayout() error = %v", err)
+	}
+	if _, err := writeOCILayout(out, "v2", build); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeOCILayout(out, "v1", build); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(out, "oci-layout")); err != nil || !strings.Contains(string(content), ociLayoutVersion) {
+		t.Fatalf("oci-layout = %q, %v", content, err)
+	}
+	index, err := readOCIIndex(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 2 {
+		t.Fatalf("index has %d manifests, want one per tag: %#v", len(index.Manifests), index.Manifests)
+	}
+
+	manifest := readOCIManifest(t, out, digest)
+	if manifest.ArtifactType != ociReleaseType || manifest.Config.MediaType != ociEmptyMediaType {
+		t.Fatalf("unexpected manifest header: %#v", manifest)
+	}
+	titles := []string{}
+	for _, layer := range manifest.Layers {
+		titles = append(titles, layer.Annotations[ociAnnotationTitle])
+		blob, err := os.ReadFile(filepath.Join(out, "blobs", "sha256", strings.TrimPrefix(layer.Digest, "sha256:")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if "sha256:"+sha256Hex(blob) != layer.Digest || int64(len(blob)) != layer.Size {
+			t.Fatalf("blob for %s does not match its descriptor", layer.Annotations[ociAnnotationTitle])
+		}
+	}
+	// Upload order is preserved: hashed assets first, version.yaml last.
+	if got := strings.Join(titles, ","); got != "index.abcdefgh.js,index.html,manifest.yaml,version.yaml" {
+		t.Fatalf("layer titles = %s", got)
+	}
+	if got := manifest.Layers[0].Annotations[ociAnnotationCacheControl]; got != "public, max-age=31536000, immutable" {
+		t.Fatalf("hashed asset cache-control = %q", got)
+	}
+	if got := manifest.Layers[3].Annotations[ociAnnotationContentType]; got != "text/plain; charset=utf-8" {
+		t.Fatalf("version.yaml content-type = %q", got)
+	}
+}
+
+func TestStageLocalDist(t *testing.T) {
+	t.Parallel()
+
+	dist := writeDist(t, map[string]string{
+		"index.html":        "<html><head></head></html>",
+		"index.abcdefgh.js": "console.log(1)",
+		versionFileName:     "webRepo: runmedev/web\nwebCommit: 1111111111\n",
+	})
+	staged := filepath.Join(t.TempDir(), "dist")
+	build, err := stageLocalDist(dist, staged, hardeningConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.distDir != staged || build.appDir != filepath.Dir(dist) || build.version.WebCommit != "1111111111" {
+		t.Fatalf("build = %+v", build)
+	}
+	// Only the copy gains the release markers.
+	if _, err := os.Stat(filepath.Join(dist, manifestFileName)); !os.IsNotExist(err) {
+		t.Fatalf("%s written into the user's dist: %v", manifestFileName, err)
+	}
+	if _, err := os.Stat(filepath.Join(staged, manifestFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	unversioned := writeDist(t, map[string]string{"index.html": "<html></html>"})
+	if _, err := stageLocalDist(unversioned, filepath.Join(t.TempDir(), "dist"), hardeningConfig{}); err == nil || !strings.Contains(err.Error(), versionFileName) {
+		t.Fatalf("stageLocalDist() without %s = %v", versionFileName, err)
+	}
+}
+
+func readOCIManifest(t *testing.T, dir, digest string) ociManifest {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const releasePlanFormat = 1
+
+// pruneKeepReleases is how many of the latest published releases keep their
+// files when --prune deletes old ones. Clients that loaded one of them, such
+// as an installed PWA or a tab left open, still lazy-load its chunks.
+const pruneKeepReleases = 3
+
+// Change markers for planned uploads.
+const (
+	planChangeAdded     = "added"
+	planChangeChanged   = "changed"
+	planChangeUnchanged = "unchanged"
+	planChangeMarker    = "marker"
+)
+
+// releasePlan is the reviewable output of `plan`: everything `apply` needs to
+// publish a build without resolving or building again.
+type releasePlan struct {
+	Format    int            `json:"format"`
+	CreatedAt string         `json:"createdAt"`
+	Bucket    string         `json:"bucket"`
+	DistDir   string         `json:"distDir"`
+	Version   releaseVersion `json:"version"`
+	// PublishedVersionSHA256 digests the bucket's version.yaml when the plan
+	// was made; it is empty when the bucket had none.
+	PublishedVersionSHA256 string          `json:"publishedVersionSHA256"`
+	Uploads                []plannedUpload `json:"uploads"`
+	Deletes                []string        `json:"deletes"`
+	// Worktree and Mirror name the workspace worktree that holds DistDir, so
+	// apply can remove it once the plan is published.
+	Worktree string `json:"worktree,omitempty"`
+	Mirror   string `json:"mirror,omitempty"`
+	// worktree is where the plan was built.
+	worktree releaseWorktree
+}
+
+// plannedUpload is one file in publish order, with the object metadata it
+// will be uploaded with.
+type plannedUpload struct {
+	Path               string `json:"path"`
+	Size               int64  `json:"size"`
+	SHA256             string `json:"sha256"`
+	Change             string `json:"change"`
+	CacheControl       string `json:"cacheControl"`
+	ContentType        string `json:"contentType,omitempty"`
+	ContentDisposition string `json:"contentDisposition,omitempty"`
+	Group              int    `json:"group"`
+}
+
+func newPlanCmd() *cobra.Command {
+	cfg := config{}
+	var (
+		outPath string
+		prune   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "plan --web=<branch> --out=<plan.json>",
+		Short: "Build a release and save the upload and delete plan for review",
+		Long: `Resolve inputs, build, and run every release check like a normal run,
+then write the exact upload and delete plan to --out instead of publishing.
+Publish it later with "releaser apply <plan.json>".
+
+The build output stays in --tmpdir; pass --dist to apply if it was moved.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			ctx, finishTrace := startTrace(cmd.Context(), cfg.trace, "plan")
+			defer func() { finishTrace(err) }()
+			cfg.bucketSet = cmd.Flags().Changed("bucket")
+			plan, current, err := planRelease(ctx, cfg, prune)
+			if err != nil || current {
+				return err
+			}
+			plan.Worktree, plan.Mirror = plan.worktree.dir, plan.worktree.mirror
+			if err := writeReleasePlan(outPath, plan); err != nil {
+				return fmt.Errorf("write plan: %w", err)
+			}
+			printReleasePlan(plan)
+			plan.worktree.keep("until apply publishes it")
+			fmt.Printf("plan written to %s; publish it with: releaser apply %s\n", outPath, outPath)
+			return nil
+		},
+	}
+
+	addPlanFlags(cmd, &cfg, &prune, "plan")
+	cmd.Flags().StringVar(&outPath, "out", "", "path to write the plan JSON to")
+	_ = cmd.MarkFlagRequired("out")
+
+	return cmd
+}
+
+// addPlanFlags registers the inputs shared by the commands that build a
+// release for later publishing; verb completes the force flag help.
+func addPlanFlags(cmd *cobra.Command, cfg *config, prune *bool, verb string) {
+	cmd.Flags().StringVar(&cfg.webBranch, "web", "", "branch or tag name in the web repo")
+	cmd.Flags().StringVar(&cfg.webRepo, "web-repo", defaultWebRepo, "web repo slug, URL, or local path")
+	cmd.Flags().StringVar(&cfg.bucket, "bucket", defaultBucket, "destination bucket URL (gs://, s3://, az://) or local directory")
+	cmd.Flags().StringVar(&cfg.profile, "profile", "", "deployment profile from --config; sets the bucket and enforces its policy")
+	cmd.Flags().StringVar(&cfg.tmpBase, "tmpdir", os.TempDir(), "base temporary directory; the build output is kept here")
+	cmd.Flags().StringVar(&cfg.configPath, "config", "", "releaser config file (budgets and other release policy)")
+	cmd.Flags().BoolVar(&cfg.allowBudgetOverrun, "allow-budget-overrun", false, verb+" even if bundle budgets are exceeded")
+	cmd.Flags().BoolVar(&cfg.forceIncompatible, "force-incompatible", false, verb+" even if the backend does not meet the release's declared requirements")
+	cmd.Flags().StringVar(&cfg.gitTokenFile, "git-token-file", "", "file holding an HTTPS token for cloning the web repo")
+	cmd.Flags().StringVar(&cfg.sshKey, "ssh-key", "", "SSH private key for cloning the web repo")
+	cmd.Flags().BoolVar(prune, "prune", false, fmt.Sprintf("delete files of earlier releases that neither the new release nor the last %d published ones have", pruneKeepReleases))
+	addTraceFlags(cmd, &cfg.trace)
+	_ = cmd.MarkFlagRequired("web")
+}
+
+// planRelease builds and checks a release like a normal run and plans its
+// publish instead of publishing. It reports current=true when there is
+// nothing to plan.
+func planRelease(ctx context.Context, cfg config, prune bool) (plan releasePlan, current bool, err error) {
+	releaserCfg, err := loadReleaserConfig(cfg.configPath)
+	if err != nil {
+		return releasePlan{}, false, fmt.Errorf("load --config: %w", err)
+	}
+	if err := applyProfile(&cfg, releaserCfg); err != nil {
+		return releasePlan{}, false, err
+	}
+	// Read the marker before building so apply also catches a publish that
+	// lands while this plan is being built.
+	publishedVersion, _, err := readObject(ctx, cfg.bucket, versionFileName)
+	if err != nil {
+		return releasePlan{}, false, fmt.Errorf("read current version marker: %w", err)
+	}
+	published, exists, err := readManifest(ctx, cfg.bucket, manifestFileName)
+	if err != nil {
+		return releasePlan{}, false, fmt.Errorf("read published manifest: %w", err)
+	}
+
+	build, current, err := prepareRelease(ctx, cfg, releaserCfg, nil)
+	if err != nil {
+		return releasePlan{}, false, err
+	}
+	if current {
+		fmt.Println("nothing to plan")
+		return releasePlan{}, true, nil
+	}
+
+	var publishedManifest *releaseManifest
+	if exists {
+		publishedManifest = &published
+	}
+	var scope *pruneScope
+	if prune {
+		if scope, err = loadPruneScope(ctx, cfg.bucket, publishedManifest); err != nil {
+			build.worktree.keep("for debugging")
+			return releasePlan{}, false, fmt.Errorf("load releases to prune: %w", err)
+		}
+	}
+	plan, err = makeReleasePlan(cfg.bucket, build, publishedVersion, publishedManifest, scope)
+	plan.worktree = build.worktree
+	return plan, false, err
+}
+
+func newApplyCmd() *cobra.Command {
+	var (
+		distDir    string
+		publisher  string
+		configPath string
+		trace      traceOptions
+	)
+	cmd := &cobra.Command{
+		Use:   "apply <plan.json>",
+		Short: "Publish a release exactly as saved by plan",
+		Long: `Publish the files listed in a plan made by "releaser plan".
+
+Apply refuses to run if the bucket's version.yaml changed since the plan was
+made, or if any planned file no longer matches its digest.
+
+Notifications configured in --config are sent when apply publishes or fails.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			ctx, finishTrace := startTrace(cmd.Context(), trace, "apply")
+			defer func() { finishTrace(err) }()
+			plan, err := readReleasePlan(args[0])
+			if err != nil {
+				return err
+			}
+			if distDir != "" {
+				plan.DistDir = distDir
+			}
+			if err := applyReleasePlanAndNotify(ctx, plan, publisher, configPath); err != nil {
+				return err
+			}
+			// The build output was only kept for this apply.
+			plannedWorktree(plan).remove(ctx)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&distDir, "dist", "", "build output directory, if it moved since the plan was made")
+	cmd.Flags().StringVar(&publisher, "publisher", "", "publisher identity recorded in the release history (defaults to the CI run or local user)")
+	cmd.Flags().StringVar(&configPath, "config", "", "releaser config file (notifications)")
+	addTraceFlags(cmd, &trace)
+	return cmd
+}
+
+// applyReleasePlanAndNotify applies plan and reports the outcome to the
+// webhooks configured in configPath.
+func applyReleasePlanAndNotify(ctx context.Context, plan releasePlan, publisher, configPath string) (err error) {
+	started := time.Now()
+	releaserCfg, err := loadReleaserConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("load --config: %w", err)
+	}
+	webhooks, err := newWebhooks(releaserCfg.Notifications)
+	if err != nil {
+		return fmt.Errorf("notifications: %w", err)
+	}
+
+	event := releaseEvent{
+		Outcome:   releaseOutcomePublished,
+		Version:   plan.Version,
+		Bucket:    plan.Bucket,
+		Publisher: publisherIdentity(publisher),
+		Files:     len(plan.Uploads),
+		Deleted:   len(plan.Deletes),
+	}
+	if previous, exists, err := readVersion(ctx, plan.Bucket); err == nil && exists {
+		event.Previous = &previous
+	}
+	defer func() {
+		finishReleaseEvent(&event, started, err)
+		notifyRelease(ctx, webhooks, event)
+	}()
+	return applyReleasePlan(ctx, plan, publisher)
+}
+
+// plannedWorktree returns the worktree plan was built in. Paths that are not
+// worktrees of a releaser workspace are ignored, so a hand-edited plan
+// cannot make apply delete anything else.
+func plannedWorktree(plan releasePlan) releaseWorktree {
+	if plan.Worktree == "" || filepath.Base(filepath.Dir(plan.Worktree)) != worktreesDirName ||
+		filepath.Base(filepath.Dir(plan.Mirror)) != mirrorsDirName {
+		return releaseWorktree{}
+	}
+	return releaseWorktree{dir: plan.Worktree, mirror: plan.Mirror}
+}
+
+// pruneScope is what --prune weighs: the files of earlier releases, which
+// may be deleted, and the files of the releases that must stay loadable.
+type pruneScope struct {
+	earlier []releaseManifest
+	kept    []releaseManifest
+}
+
+// loadPruneScope reads the manifests archived for the releases in the bucket
+// history. The last pruneKeepReleases distinct commits, and the published
+// manifest, are kept. Releases archived without a manifest are skipped.
+func loadPruneScope(ctx context.Context, bucket string, published *releaseManifest) (*pruneScope, error) {
+	entries, err := readReleaseHistory(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("read release history: %w", err)
+	}
+	scope := &pruneScope{}
+	if published != nil {
+		scope.kept = append(scope.kept, *published)
+	}
+	seen := map[string]bool{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		commit := entries[i].Version.WebCommit
+		if commit == "" || seen[commit] {
+			continue
+		}
+		seen[commit] = true
+		manifest, exists, err := readManifest(ctx, bucket, path.Join(releaseSnapshotDir(commit), manifestFileName))
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !exists:
+		case len(seen) <= pruneKeepReleases:
+			scope.kept = append(scope.kept, manifest)
+		default:
+			scope.earlier = append(scope.earlier, manifest)
+		}
+	}
+	return scope, nil
+}
+
+// makeReleasePlan lists build.files in publish order, marking each against the
+// published manifest. With a prune scope, files of earlier releases that
+// neither the new release nor a kept one has are scheduled for deletion
+// after version.yaml.
+func makeReleasePlan(bucket string, build releaseBuild, publishedVersion []byte, published *releaseManifest, prune *pruneScope) (releasePlan, error) {
+	distDir, err := filepath.Abs(build.distDir)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	plan := releasePlan{
+		Format:    releasePlanFormat,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Bucket:    bucket,
+		DistDir:   distDir,
+		Version:   build.version,
+		Uploads:   []plannedUpload{},
+		Deletes:   []string{},
+	}
+	if publishedVersion != nil {
+		plan.PublishedVersionSHA256 = sha256Hex(publishedVersion)
+	}
+
+	before := map[string]string{}
+	if published != nil {
+		for _, file := range published.Files {
+			before[file.Path] = file.SHA256
+		}
+	}
+	planned := map[string]bool{}
+	for _, file := range build.files {
+		size, digest, err := fileDigest(file.src)
+		if err != nil {
+			return releasePlan{}, err
+		}
+		change := planChangeAdded
+		switch previous, ok := before[file.dst]; {
+		case file.dst == versionFileName || file.dst == manifestFileName:
+			change = planChangeMarker
+		case ok && previous == digest:
+			change = planChangeUnchanged
+		case ok:
+			change = planChangeChanged
+		}
+		plan.Uploads = append(plan.Uploads, plannedUpload{
+			Path:               file.dst,
+			Size:               size,
+			SHA256:             digest,
+			Change:             change,
+			CacheControl:       file.cacheControl,
+			ContentType:        file.contentType,
+			ContentDisposition: file.contentDisposition,
+			Group:              file.group,
+		})
+		planned[file.dst] = true
+	}
+
+	if prune != nil {
+		kept := maps.Clone(planned)
+		for _, manifest := range prune.kept {
+			for _, file := range manifest.Files {
+				kept[file.Path] = true
+			}
+		}
+		for _, manifest := range prune.earlier {
+			for _, file := range manifest.Files {
+				if !kept[file.Path] && !slices.Contains(plan.Deletes, file.Path) {
+					plan.Deletes = append(plan.Deletes, file.Path)
+				}
+			}
+		}
+		sort.Strings(plan.Deletes)
+	}
+	return plan, nil
+}
+
+// applyReleasePlan publishes plan after checking that neither the bucket nor
+// the build output moved since it was made.
+func applyReleasePlan(ctx context.Context, plan releasePlan, publisher string) error {
+	started := time.Now()
+	if plan.Format != releasePlanFormat {
+		return fmt.Errorf("unsupported plan format %d", plan.Format)
+	}
+
+	content, exists, err := readObject(ctx, plan.Bucket, versionFileName)
+	if err != nil {
+		return fmt.Errorf("read current version marker: %w", err)
+	}
+	current := ""
+	if exists {
+		current = sha256Hex(content)
+	}
+	if current != plan.PublishedVersionSHA256 {
+		return fmt.Errorf("%s in %s changed since the plan was made (planned %s, now %s); make a new plan",
+			versionFileName, plan.Bucket, firstNonEmpty(plan.PublishedVersionSHA256, "absent"), firstNonEmpty(current, "absent"))
+	}
+
+	files := make([]publishFile, 0, len(plan.Uploads))
+	for _, upload := range plan.Uploads {
+		src := filepath.Join(plan.DistDir, filepath.FromSlash(upload.Path))
+		size, digest, err := fileDigest(src)
+		if err != nil {
+			return fmt.Errorf("planned file %s: %w", upload.Path, err)
+		}
+		if size != upload.Size || digest != upload.SHA256 {
+			return fmt.Errorf("planned file %s changed since the plan was made", upload.Path)
+		}
+		files = append(files, publishFile{
+			src:                src,
+			dst:                upload.Path,
+			cacheControl:       upload.CacheControl,
+			contentType:        upload.ContentType,
+			contentDisposition: upload.ContentDisposition,
+			group:              upload.Group,
+		})
+	}
+
+	build := releaseBuild{version: plan.Version, distDir: plan.DistDir, files: files}
+	return publishRelease(ctx, plan.Bucket, publisher, build, plan.Deletes, started)
+}
+
+func writeReleasePlan(path string, plan releasePlan) error {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0o644)
+}
+
+func readReleasePlan(path string) (releasePlan, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	var plan releasePlan
+	if err := json.Unmarshal(content, &plan); err != nil {
+		return releasePlan{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if plan.Bucket == "" || plan.DistDir == "" {
+		return releasePlan{}, errors.New("plan is missing its bucket or dist directory")
+	}
+	return plan, nil
+}
+
+func printReleasePlan(plan releasePlan) {
+	counts := map[string]int{}
+	for _, upload := range plan.Uploads {
+		counts[upload.Change]++
+	}
+	fmt.Printf("plan: web=%s bucket=%s\n", shortSHA(plan.Version.WebCommit, shortSHALen), plan.Bucket)
+	fmt.Printf("  upload %d files (%d added, %d changed, %d unchanged), delete %d\n",
+		len(plan.Uploads), counts[planChangeAdded], counts[planChangeChanged], counts[planChangeUnchanged], len(plan.Deletes))
+	for _, upload := range plan.Uploads {
+		if upload.Change == planChangeAdded || upload.Change == planChangeChanged {
+			fmt.Printf("  %-9s %s (%s)\n", upload.Change, upload.Path, formatBytes(upload.Size))
+		}
+	}
+	for _, rel := range plan.Deletes {
+		fmt.Printf("  %-9s %s\n", "delete", rel)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanAndApply(t *testing.T) {
+	ctx := context.Background()
+	bucket := t.TempDir()
+
+	// Publish one release more than --prune keeps, so only the first
+	// release's chunk falls out of the window.
+	for n := 1; n <= pruneKeepReleases+1; n++ {
+		build, err := finalizeLocalDist(numberedReleaseDist(t, n), hardeningConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := publishRelease(ctx, bucket, "tester", build, nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next := pruneKeepReleases + 2
+	second := numberedReleaseDist(t, next)
+	build, err := finalizeLocalDist(second, hardeningConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishedVersion, _, err := readObject(ctx, bucket, versionFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, _, err := readManifest(ctx, bucket, manifestFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope, err := loadPruneScope(ctx, bucket, &published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := makeReleasePlan(bucket, build, publishedVersion, &published, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := map[string]string{}
+	for _, upload := range plan.Uploads {
+		changes[upload.Path] = upload.Change
+	}
+	if changes["index.html"] != planChangeChanged || changes[numberedChunk(next)] != planChangeAdded ||
+		changes["logo.png"] != planChangeUnchanged || changes[versionFileName] != planChangeMarker {
+		t.Fatalf("changes = %v", changes)
+	}
+	if last := plan.Uploads[len(plan.Uploads)-1].Path; last != versionFileName {
+		t.Fatalf("last upload = %s, want %s", last, versionFileName)
+	}
+	if strings.Join(plan.Deletes, ",") != numberedChunk(1) {
+		t.Fatalf("deletes = %v", plan.Deletes)
+	}
+
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	if err := writeReleasePlan(planPath, plan); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := readReleasePlan(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A tampered build output is refused.
+	if err := os.WriteFile(filepath.Join(second, "logo.png"), []byte("evil"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyReleasePlan(ctx, saved, "tester"); err == nil || !strings.Contains(err.Error(), "logo.png changed") {
+		t.Fatalf("apply with tampered file error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(second, "logo.png"), []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyReleasePlan(ctx, saved, "tester"); err != nil {
+		t.Fatalf("apply error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(bucket, numberedChunk(1))); !os.IsNotExist(err) {
+		t.Fatalf("pruned file still present: %v", err)
+	}
+	// Tabs still running a recent release can lazy-load its chunks.
+	if _, err := os.Stat(filepath.Join(bucket, numberedChunk(2))); err != nil {
+		t.Fatalf("chunk of a recent release pruned: %v", err)
+	}
+	version, _, err := readVersion(ctx, bucket)
+	if err != nil || version.WebCommit != numberedCommit(next) {
+		t.Fatalf("published version = %+v, %v", version, err)
+	}
+	history, err := readReleaseHistory(ctx, bucket)
+	if err != nil || len(history) != next {
+		t.Fatalf("history = %+v, %v", history, err)
+	}
+
+	// The bucket moved on, so applying the same plan again is refused.
+	if err := applyReleasePlan(ctx, saved, "tester"); err == nil || !strings.Contains(err.Error(), "changed since the plan was made") {
+		t.Fatalf("stale apply error = %v", err)
+	}
+}
+
+// numberedReleaseDist writes the build output of the n-th test release: a
+// changed index.html, a chunk of its own, and an unchanged logo.
+func numberedReleaseDist(t *testing.T, n int) string {
+	t.Helper()
+	return writeDist(t, map[string]string{
+		"index.html":     fmt.Sprintf("<html>v%d</html>", n),
+		numberedChunk(n): fmt.Sprintf("console.log(%d)", n),
+		"logo.png":       "png",
+		versionFileName:  "webRepo: runmedev/web\nwebCommit: " + numberedCommit(n) + "\n",
+	})
+}
+
+func numberedChunk(n int) string {
+	return "index." + strings.Repeat(string(rune('a'+n-1)), 8) + ".js"
+}
+
+func numberedCommit(n int) string {
+	return strings.Repeat(fmt.Sprint(n), 10)
+}
+
+func TestPlannedWorktree(t *testing.T) {
+	t.Parallel()
+
+	work := filepath.Join(t.TempDir(), "releaser-work")
+	plan := releasePlan{
+		Worktree: filepath.Join(work, worktreesDirName, "web-1111111-20260101"),
+		Mirror:   filepath.Join(work, mirrorsDirName, "runmedev-web.git"),
+	}
+	if got := plannedWorktree(plan); got.dir != plan.Worktree || got.mirror != plan.Mirror {
+		t.Fatalf("plannedWorktree() = %+v", got)
+	}
+	// Anything outside a releaser workspace is left alone.
+	plan.Worktree = t.TempDir()
+	if got := plannedWorktree(plan); got.dir != "" {
+		t.Fatalf("plannedWorktree() outside the workspace = %+v", got)
+	}
+}
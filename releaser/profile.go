@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Verifications a profile can require. Each one must be configured in
+// --config and may not be bypassed with a force flag.
+const (
+	profileRequireBudgets       = "budgets"
+	profileRequireCompatibility = "compatibility"
+	profileRequireSRI           = "sri"
+	profileRequireCSP           = "csp"
+)
+
+// profileConfig is a named deployment target under profiles in --config.
+type profileConfig struct {
+	// Bucket is where the profile publishes; --bucket may only repeat it.
+	Bucket string `yaml:"bucket"`
+	// Repos lists the --web-repo values allowed; empty allows any.
+	Repos []string `yaml:"repos"`
+	// Branches and Tags are path.Match globs for the allowed --web refs. A
+	// profile that lists neither allows any branch and no tags.
+	Branches []string `yaml:"branches"`
+	Tags     []string `yaml:"tags"`
+	// Require names verifications that must run: budgets, compatibility,
+	// sri, and csp.
+	Require []string `yaml:"require"`
+	// AppConfig is merged over the built configs/app-configs.yaml.
+	AppConfig map[string]any `yaml:"appConfig"`
+}
+
+// applyProfile validates --profile against releaserCfg and points cfg at the
+// profile's bucket. Without --profile, buckets that belong to a profile are
+// refused so production cannot be published to by accident.
+func applyProfile(cfg *config, releaserCfg releaserConfig) error {
+	if cfg.profile == "" {
+		for _, name := range sortedProfileNames(releaserCfg.Profiles) {
+			if sameBucket(releaserCfg.Profiles[name].Bucket, cfg.bucket) {
+				return fmt.Errorf("bucket %s belongs to profile %q; pass --profile=%s", cfg.bucket, name, name)
+			}
+		}
+		return nil
+	}
+
+	profile, ok := releaserCfg.Profiles[cfg.profile]
+	if !ok {
+		known := sortedProfileNames(releaserCfg.Profiles)
+		if len(known) == 0 {
+			return fmt.Errorf("unknown profile %q: --config defines no profiles", cfg.profile)
+		}
+		return fmt.Errorf("unknown profile %q (known: %s)", cfg.profile, strings.Join(known, ", "))
+	}
+	if profile.Bucket == "" {
+		return fmt.Errorf("profile %q has no bucket", cfg.profile)
+	}
+	if cfg.bucketSet && !sameBucket(cfg.bucket, profile.Bucket) {
+		return fmt.Errorf("profile %q publishes to %s, not --bucket=%s", cfg.profile, profile.Bucket, cfg.bucket)
+	}
+	cfg.bucket = profile.Bucket
+
+	for _, pattern := range slices.Concat(profile.Branches, profile.Tags) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("profile %q: invalid ref pattern %q: %w", cfg.profile, pattern, err)
+		}
+	}
+	return checkProfileRequirements(cfg.profile, profile, *cfg, releaserCfg)
+}
+
+func checkProfileRequirements(name string, profile profileConfig, cfg config, releaserCfg releaserConfig) error {
+	var problems []string
+	for _, required := range profile.Require {
+		switch required {
+		case profileRequireBudgets:
+			if releaserCfg.Budgets == nil {
+				problems = append(problems, "budgets must be configured")
+			}
+			if cfg.allowBudgetOverrun {
+				problems = append(problems, "--allow-budget-overrun is not allowed")
+			}
+		case profileRequireCompatibility:
+			if releaserCfg.Compatibility == nil {
+				problems = append(problems, "compatibility must be configured")
+			}
+			if cfg.forceIncompatible {
+				problems = append(problems, "--force-incompatible is not allowed")
+			}
+		case profileRequireSRI:
+			if releaserCfg.Hardening.DisableSRI {
+				problems = append(problems, "hardening.disableSRI is not allowed")
+			}
+		case profileRequireCSP:
+			if releaserCfg.Hardening.CSP == nil {
+				problems = append(problems, "hardening.csp must be configured")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("unknown requirement %q", required))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("profile %q: %s", name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checkProfileSource refuses a resolved release whose repo or ref the profile
+// does not allow.
+func checkProfileSource(name string, profile profileConfig, version releaseVersion) error {
+	if len(profile.Repos) > 0 && !profileAllowsRepo(profile.Repos, version.WebRepo) {
+		return fmt.Errorf("profile %q does not allow web repo %s (allowed: %s)", name, version.WebRepo, strings.Join(profile.Repos, ", "))
+	}
+	kind, patterns := "branch", profile.Branches
+	if version.WebTag {
+		kind, patterns = "tag", profile.Tags
+	} else if len(profile.Branches) == 0 && len(profile.Tags) == 0 {
+		return nil
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, version.WebBranch); ok {
+			return nil
+		}
+	}
+	if len(patterns) == 0 {
+		return fmt.Errorf("profile %q does not allow publishing from a %s", name, kind)
+	}
+	return fmt.Errorf("profile %q does not allow %s %q (allowed: %s)", name, kind, version.WebBranch, strings.Join(patterns, ", "))
+}
+
+func profileAllowsRepo(allowed []string, repo string) bool {
+	for _, candidate := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(candidate, ".git"), strings.TrimSuffix(repo, ".git")) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyAppConfigOverlay merges overlay over the app-configs.yaml in distDir.
+// Maps merge key by key; any other value, including lists, replaces the
+// built one.
+func applyAppConfigOverlay(distDir string, overlay map[string]any) error {
+	if len(overlay) == 0 {
+		return nil
+	}
+	configPath := filepath.Join(distDir, filepath.FromSlash(appConfigsPath))
+	base := map[string]any{}
+	content, err := os.ReadFile(configPath)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(content, &base); err != nil {
+			return fmt.Errorf("parse %s: %w", appConfigsPath, err)
+		}
+		if base == nil {
+			base = map[string]any{}
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	merged, err := yaml.Marshal(mergeYAMLMaps(base, overlay))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configPath, merged, 0o644)
+}
+
+func mergeYAMLMaps(base, overlay map[string]any) map[string]any {
+	for key, value := range overlay {
+		overlayMap, overlayIsMap := value.(map[string]any)
+		baseMap, baseIsMap := base[key].(map[string]any)
+		if overlayIsMap && baseIsMap {
+			base[key] = mergeYAMLMaps(baseMap, overlayMap)
+			continue
+		}
+		base[key] = value
+	}
+	return base
+}
+
+func sortedProfileNames(profiles map[string]profileConfig) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sameBucket(a, b string) bool {
+	return a != "" && strings.TrimRight(a, "/") == strings.TrimRight(b, "/")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	releaserCfg := releaserConfig{
+		Budgets: &budgetConfig{MaxTotalBytes: 1 << 20},
+		Profiles: map[string]profileConfig{
+			"prod":    {Bucket: "gs://runme-hosted", Require: []string{profileRequireBudgets, profileRequireSRI}},
+			"staging": {Bucket: "gs://runme-staging/"},
+			"strict":  {Bucket: "gs://strict", Require: []string{profileRequireCSP}},
+		},
+	}
+
+	cfg := config{bucket: defaultBucket}
+	if err := applyProfile(&cfg, releaserCfg); err == nil || !strings.Contains(err.Error(), "--profile=prod") {
+		t.Fatalf("default bucket without profile: err = %v", err)
+	}
+
+	cfg = config{bucket: defaultBucket, profile: "staging"}
+	if err := applyProfile(&cfg, releaserCfg); err != nil || cfg.bucket != "gs://runme-staging/" {
+		t.Fatalf("staging: bucket = %s, err = %v", cfg.bucket, err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		cfg  config
+		want string
+	}{
+		{"unknown", config{profile: "qa"}, "known: prod, staging, strict"},
+		{"bucket override", config{profile: "staging", bucket: "gs://other", bucketSet: true}, "not --bucket=gs://other"},
+		{"forced", config{profile: "prod", allowBudgetOverrun: true}, "--allow-budget-overrun is not allowed"},
+		{"missing check", config{profile: "strict"}, "hardening.csp must be configured"},
+	} {
+		if err := applyProfile(&tc.cfg, releaserCfg); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestCheckProfileSource(t *testing.T) {
+	profile := profileConfig{Repos: []string{"runmedev/web"}, Branches: []string{"main", "release/*"}, Tags: []string{"v*"}}
+	for _, tc := range []struct {
+		version releaseVersion
+		ok      bool
+	}{
+		{releaseVersion{WebRepo: "runmedev/web", WebBranch: "main"}, true},
+		{releaseVersion{WebRepo: "runmedev/web", WebBranch: "release/2026-10"}, true},
+		{releaseVersion{WebRepo: "runmedev/web", WebBranch: "v1.4.0", WebTag: true}, true},
+		{releaseVersion{WebRepo: "runmedev/web", WebBranch: "feature/x"}, false},
+		{releaseVersion{WebRepo: "runmedev/web", WebBranch: "main", WebTag: true}, false},
+		{releaseVersion{WebRepo: "someone/web", WebBranch: "main"}, false},
+	} {
+		err := checkProfileSource("prod", profile, tc.version)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s %s (tag=%v): err = %v", tc.version.WebRepo, tc.version.WebBranch, tc.version.WebTag, err)
+		}
+	}
+
+	if err := checkProfileSource("dev", profileConfig{}, releaseVersion{WebBranch: "v1", WebTag: true}); err == nil {
+		t.Error("profile without tags allowed a tag")
+	}
+}
+
+func TestApplyAppConfigOverlay(t *testing.T) {
+	dist := writeDist(t, map[string]string{
+		appConfigsPath: "agent:\n  endpoint: https://api.runme.dev\n  defaultRunnerEndpoint: wss://runner.runme.dev\noidc:\n  scopes: [openid]\n",
+	})
+	overlay := map[string]any{
+		"agent": map[string]any{"endpoint": "https://api.staging.runme.dev"},
+		"oidc":  map[string]any{"scopes": []any{"openid", "email"}},
+		"extra": true,
+	}
+	if err := applyAppConfigOverlay(dist, overlay); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dist, appConfigsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(content)
+	for _, want := range []string{"endpoint: https://api.staging.runme.dev", "defaultRunnerEndpoint: wss://runner.runme.dev", "- email", "extra: true"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("app-configs.yaml missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestGitRemoteRefResolvesTags(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Dev", "GIT_AUTHOR_EMAIL=dev@example.com",
+			"GIT_COMMITTER_NAME=Dev", "GIT_COMMITTER_EMAIL=dev@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "initial")
+	head := git("rev-parse", "HEAD")
+	git("tag", "-a", "v1.0.0", "-m", "v1.0.0")
+
+	ctx := context.Background()
+	if sha, tag, err := gitRemoteRef(ctx, repo, "main", nil); err != nil || sha != head || tag {
+		t.Fatalf("main: sha = %s, tag = %v, err = %v", sha, tag, err)
+	}
+	if sha, tag, err := gitRemoteRef(ctx, repo, "v1.0.0", nil); err != nil || sha != head || !tag {
+		t.Fatalf("v1.0.0: sha = %s, tag = %v, err = %v", sha, tag, err)
+	}
+	if _, _, err := gitRemoteRef(ctx, repo, "missing", nil); err == nil {
+		t.Fatal("missing ref resolved")
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Location is a parsed s3://bucket/prefix destination. Options that the
+// AWS CLI would take as flags are carried as query parameters so the bucket
+// stays a single string everywhere else in the releaser:
+//
+//	s3://bucket/prefix?endpoint=http://127.0.0.1:9000&region=us-east-1&path_style=true
+type s3Location struct {
+	bucket    string
+	prefix    string
+	endpoint  *url.URL
+	region    string
+	pathStyle bool
+}
+
+type s3Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+func isS3Bucket(bucket string) bool {
+	return strings.HasPrefix(bucket, "s3://")
+}
+
+func parseS3Location(raw string) (s3Location, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return s3Location{}, err
+	}
+	if u.Host == "" {
+		return s3Location{}, fmt.Errorf("s3 destination %q has no bucket", raw)
+	}
+	query := u.Query()
+
+	loc := s3Location{
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+		region: firstNonEmpty(query.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+	}
+
+	endpoint := firstNonEmpty(query.Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	custom := endpoint != ""
+	if !custom {
+		endpoint = "https://s3." + loc.region + ".amazonaws.com"
+	}
+	if loc.endpoint, err = url.Parse(endpoint); err != nil {
+		return s3Location{}, fmt.Errorf("s3 endpoint %q: %w", endpoint, err)
+	}
+
+	// S3-compatible servers such as MinIO rarely have wildcard DNS for
+	// virtual-hosted buckets, so a custom endpoint defaults to path style.
+	loc.pathStyle = custom
+	if value := query.Get("path_style"); value != "" {
+		if loc.pathStyle, err = strconv.ParseBool(value); err != nil {
+			return s3Location{}, fmt.Errorf("s3 path_style %q: %w", value, err)
+		}
+	}
+	return loc, nil
+}
+
+// displayURL is the s3:// form of rel without the connection parameters.
+func (loc s3Location) displayURL(rel string) string {
+	return "s3://" + loc.bucket + "/" + joinObjectKey(loc.prefix, rel)
+}
+
+func (loc s3Location) objectURL(rel string) *url.URL {
+	key := joinObjectKey(loc.prefix, rel)
+	u := *loc.endpoint
+	if loc.pathStyle {
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + loc.bucket + "/" + key
+	} else {
+		u.Host = loc.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = awsURIEncode(u.Path, false)
+	return &u
+}
+
+func s3CredentialsFromEnv() (s3Credentials, error) {
+	creds := s3Credentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return s3Credentials{}, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for s3:// destinations")
+	}
+	return creds, nil
+}
+
+func s3Upload(ctx context.Context, bucket string, file publishFile) error {
+	return s3Put(ctx, bucket, file, nil)
+}
+
+// s3Put uploads file with the given precondition headers, if any.
+func s3Put(ctx context.Context, bucket string, file publishFile, conditions http.Header) error {
+	loc, err := parseS3Location(bucket)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(file.src)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, loc.objectURL(file.dst).String(), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Cache-Control", file.cacheControl)
+	req.Header.Set("Content-Type", objectContentType(file))
+	if file.contentDisposition != "" {
+		req.Header.Set("Content-Disposition", file.contentDisposition)
+	}
+	for name, values := range conditions {
+		req.Header[name] = values
+	}
+	resp, err := s3Do(req, loc, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		if len(conditions) > 0 {
+			return preconditionStatusError(resp)
+		}
+		return httpStatusError(resp)
+	}
+	return nil
+}
+
+func s3Read(ctx context.Context, bucket, rel string) ([]byte, bool, error) {
+	content, _, exists, err := s3Get(ctx, bucket, rel)
+	return content, exists, err
+}
+
+// s3Get reads rel along with its ETag.
+func s3Get(ctx context.Context, bucket, rel string) ([]byte, string, bool, error) {
+	loc, err := parseS3Location(bucket)
+	if err != nil {
+		return nil, "", false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc.objectURL(rel).String(), nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	resp, err := s3Do(req, loc, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", false, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, "", false, httpStatusError(resp)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return content, resp.Header.Get("ETag"), true, nil
+}
+
+func s3Delete(ctx context.Context, bucket, rel string) error {
+	loc, err := parseS3Location(bucket)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, loc.objectURL(rel).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s3Do(req, loc, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return httpStatusError(resp)
+	}
+	return nil
+}
+
+func s3Do(req *http.Request, loc s3Location, payload []byte) (*http.Response, error) {
+	creds, err := s3CredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	signS3Request(req, payload, creds, loc.region, time.Now().UTC())
+	return objectDo(req)
+}
+
+// signS3Request adds AWS Signature Version 4 headers to req.
+func signS3Request(req *http.Request, payload []byte, creds s3Credentials, region string, now time.Time) {
+	const service = "s3"
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode percent-encodes everything except the unreserved characters,
+// as SigV4 requires. Slashes are kept in paths.
+func awsURIEncode(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
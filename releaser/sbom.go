@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The SBOM and license bundle are published next to version.yaml and
+// referenced from it, and archived with the release snapshot.
+const (
+	sbomFileName     = "sbom.cdx.json"
+	licensesFileName = "THIRD_PARTY_LICENSES.txt"
+
+	// sbomImporter is the workspace package that becomes app/dist. Only its
+	// runtime dependencies ship; devDependencies stay in the build.
+	sbomImporter = "app"
+)
+
+// pnpmLockfile is the subset of a pnpm v9 lockfile needed to walk the
+// runtime dependency graph.
+type pnpmLockfile struct {
+	LockfileVersion string                  `yaml:"lockfileVersion"`
+	Importers       map[string]pnpmImporter `yaml:"importers"`
+	Packages        map[string]pnpmPackage  `yaml:"packages"`
+	Snapshots       map[string]pnpmSnapshot `yaml:"snapshots"`
+}
+
+type pnpmImporter struct {
+	Dependencies         map[string]pnpmImporterDep `yaml:"dependencies"`
+	OptionalDependencies map[string]pnpmImporterDep `yaml:"optionalDependencies"`
+}
+
+type pnpmImporterDep struct {
+	Specifier string `yaml:"specifier"`
+	Version   string `yaml:"version"`
+}
+
+type pnpmPackage struct {
+	Resolution struct {
+		Integrity string `yaml:"integrity"`
+		Tarball   string `yaml:"tarball"`
+	} `yaml:"resolution"`
+}
+
+type pnpmSnapshot struct {
+	Dependencies         map[string]string `yaml:"dependencies"`
+	OptionalDependencies map[string]string `yaml:"optionalDependencies"`
+}
+
+// npmPackage is one third-party package in the release, with the license
+// details found in node_modules when it is installed.
+type npmPackage struct {
+	name         string
+	version      string
+	integrity    string
+	dependencies []string
+	license      string
+	licenseText  string
+	licenseFile  string
+}
+
+func (p npmPackage) key() string {
+	return p.name + "@" + p.version
+}
+
+func (p npmPackage) purl() string {
+	return "pkg:npm/" + strings.Replace(p.name, "@", "%40", 1) + "@" + url.PathEscape(p.version)
+}
+
+// cycloneDXBOM is a CycloneDX 1.5 JSON document.
+type cycloneDXBOM struct {
+	BOMFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	SerialNumber string                `json:"serialNumber"`
+	Version      int                   `json:"version"`
+	Metadata     cycloneDXMetadata     `json:"metadata"`
+	Components   []cycloneDXComponent  `json:"components"`
+	Dependencies []cycloneDXDependency `json:"dependencies"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp,omitempty"`
+	Tools     cycloneDXTools     `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTools struct {
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Type       string             `json:"type"`
+	BOMRef     string             `json:"bom-ref,omitempty"`
+	Name       string             `json:"name"`
+	Version    string             `json:"version,omitempty"`
+	PURL       string             `json:"purl,omitempty"`
+	Hashes     []cycloneDXHash    `json:"hashes,omitempty"`
+	Licenses   []cycloneDXLicense `json:"licenses,omitempty"`
+	Properties []cycloneDXProp    `json:"properties,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cycloneDXLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cycloneDXProp struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// writeThirdPartyInventory writes the SBOM and the aggregated license file for
+// the web checkout at repoDir into distDir. It only reads pnpm-lock.yaml and
+// the node_modules that buildReleasePayload installed, so it works offline.
+func writeThirdPartyInventory(repoDir, distDir string, version releaseVersion) error {
+	content, err := os.ReadFile(filepath.Join(repoDir, "pnpm-lock.yaml"))
+	if err != nil {
+		return err
+	}
+	packages, err := runtimePackages(content, sbomImporter)
+	if err != nil {
+		return fmt.Errorf("parse pnpm-lock.yaml: %w", err)
+	}
+	if err := resolveInstalledLicenses(filepath.Join(repoDir, "node_modules", ".pnpm"), packages); err != nil {
+		return fmt.Errorf("read installed package licenses: %w", err)
+	}
+
+	bom, err := buildCycloneDX(packages, version)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(distDir, sbomFileName), bom, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(distDir, licensesFileName), buildLicenseBundle(packages, version), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("sbom: %d third-party packages\n", len(packages))
+	return nil
+}
+
+// runtimePackages walks the lockfile from the runtime dependencies of
+// importer, following workspace links, and returns every reachable package
+// sorted by name and version.
+func runtimePackages(content []byte, importer string) ([]npmPackage, error) {
+	var lock pnpmLockfile
+	if err := yaml.Unmarshal(content, &lock); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(lock.LockfileVersion, "9.") {
+		return nil, fmt.Errorf("unsupported lockfile version %q", lock.LockfileVersion)
+	}
+	if _, ok := lock.Importers[importer]; !ok {
+		return nil, fmt.Errorf("no importer %q", importer)
+	}
+
+	found := map[string]*npmPackage{}
+	visitedSnapshots := map[string]bool{}
+	visitedImporters := map[string]bool{}
+
+	var visitSnapshot func(snapshotKey string) (string, error)
+	visitSnapshot = func(snapshotKey string) (string, error) {
+		packageKey, _, _ := strings.Cut(snapshotKey, "(")
+		if visitedSnapshots[snapshotKey] {
+			return packageKey, nil
+		}
+		visitedSnapshots[snapshotKey] = true
+
+		name, version, ok := splitPackageKey(packageKey)
+		if !ok {
+			return "", fmt.Errorf("malformed package key %q", snapshotKey)
+		}
+		pkg := found[packageKey]
+		if pkg == nil {
+			pkg = &npmPackage{name: name, version: version, integrity: lock.Packages[packageKey].Resolution.Integrity}
+			found[packageKey] = pkg
+		}
+		snapshot, ok := lock.Snapshots[snapshotKey]
+		if !ok {
+			return "", fmt.Errorf("no snapshot for %q", snapshotKey)
+		}
+		for _, deps := range []map[string]string{snapshot.Dependencies, snapshot.OptionalDependencies} {
+			for depName, ref := range deps {
+				depKey, err := visitSnapshot(pnpmSnapshotKey(depName, ref))
+				if err != nil {
+					return "", err
+				}
+				if !slices.Contains(pkg.dependencies, depKey) {
+					pkg.dependencies = append(pkg.dependencies, depKey)
+				}
+			}
+		}
+		return packageKey, nil
+	}
+
+	var visitImporter func(importerPath string) error
+	visitImporter = func(importerPath string) error {
+		if visitedImporters[importerPath] {
+			return nil
+		}
+		visitedImporters[importerPath] = true
+		entry, ok := lock.Importers[importerPath]
+		if !ok {
+			return fmt.Errorf("no importer %q", importerPath)
+		}
+		for _, deps := range []map[string]pnpmImporterDep{entry.Dependencies, entry.OptionalDependencies} {
+			for depName, dep := range deps {
+				// Workspace packages are first party; their own runtime
+				// dependencies ship with the app.
+				if target, ok := strings.CutPrefix(dep.Version, "link:"); ok {
+					if err := visitImporter(path.Join(importerPath, target)); err != nil {
+						return err
+					}
+					continue
+				}
+				if _, err := visitSnapshot(pnpmSnapshotKey(depName, dep.Version)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := visitImporter(importer); err != nil {
+		return nil, err
+	}
+
+	packages := make([]npmPackage, 0, len(found))
+	for _, pkg := range found {
+		sort.Strings(pkg.dependencies)
+		packages = append(packages, *pkg)
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].name != packages[j].name {
+			return packages[i].name < packages[j].name
+		}
+		return packages[i].version < packages[j].version
+	})
+	return packages, nil
+}
+
+// pnpmSnapshotKey turns a dependency entry into a snapshots key. The
+// reference is a version, possibly with a peer suffix, or for aliased
+// dependencies the full `name@version` of the real package.
+func pnpmSnapshotKey(name, ref string) string {
+	if at := strings.Index(ref[min(1, len(ref)):], "@") + 1; at > 0 && !strings.Contains(ref[:at], ":") && (ref[0] < '0' || ref[0] > '9') {
+		return ref
+	}
+	return name + "@" + ref
+}
+
+// splitPackageKey splits `@scope/name@1.2.3` into name and version.
+func splitPackageKey(key string) (string, string, bool) {
+	at := strings.LastIndex(key, "@")
+	if at <= 0 {
+		return "", "", false
+	}
+	return key[:at], key[at+1:], true
+}
+
+// resolveInstalledLicenses fills in license details from the pnpm virtual
+// store. Each store entry holds the package itself as a real directory next
+// to symlinks to its dependencies, so symlinks are skipped. A missing store
+// leaves the licenses unknown rather than failing the release.
+func resolveInstalledLicenses(storeDir string, packages []npmPackage) error {
+	entries, err := os.ReadDir(storeDir)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("no pnpm store at %s; licenses will be reported as unknown\n", storeDir)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	installed := map[string]string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		modules := filepath.Join(storeDir, entry.Name(), "node_modules")
+		candidates, err := os.ReadDir(modules)
+		if err != nil {
+			continue
+		}
+		for _, candidate := range candidates {
+			dirs := []string{filepath.Join(modules, candidate.Name())}
+			if strings.HasPrefix(candidate.Name(), "@") {
+				scoped, _ := os.ReadDir(dirs[0])
+				dirs = dirs[:0]
+				for _, child := range scoped {
+					dirs = append(dirs, filepath.Join(modules, candidate.Name(), child.Name()))
+				}
+			}
+			for _, dir := range dirs {
+				if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+					continue
+				}
+				name, version, err := readPackageIdentity(dir)
+				if err == nil && name != "" {
+					if _, seen := installed[name+"@"+version]; !seen {
+						installed[name+"@"+version] = dir
+					}
+				}
+			}
+		}
+	}
+
+	for i := range packages {
+		dir, ok := installed[packages[i].key()]
+		if !ok {
+			continue
+		}
+		license, err := readPackageLicense(dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", packages[i].key(), err)
+		}
+		packages[i].license = license
+		packages[i].licenseFile, packages[i].licenseText, err = readLicenseText(dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", packages[i].key(), err)
+		}
+	}
+	return nil
+}
+
+func readPackageIdentity(dir string) (string, string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return "", "", err
+	}
+	var pkg struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(content, &pkg); err != nil {
+		return "", "", err
+	}
+	return pkg.Name, pkg.Version, nil
+}
+
+// readPackageLicense returns the SPDX expression from package.json, including
+// the deprecated object and array forms.
+func readPackageLicense(dir string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return "", err
+	}
+	var pkg struct {
+		License  json.RawMessage   `json:"license"`
+		Licenses []json.RawMessage `json:"licenses"`
+	}
+	if err := json.Unmarshal(content, &pkg); err != nil {
+		return "", fmt.Errorf("parse package.json: %w", err)
+	}
+	types := []string{}
+	for _, raw := range append([]json.RawMessage{pkg.License}, pkg.Licenses...) {
+		if len(raw) == 0 {
+			continue
+		}
+		var value string
+		if json.Unmarshal(raw, &value) != nil {
+			var legacy struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal(raw, &legacy)
+			value = legacy.Type
+		}
+		if value != "" {
+			types = append(types, value)
+		}
+	}
+	if len(types) > 1 {
+		return "(" + strings.Join(types, " OR ") + ")", nil
+	}
+	return strings.Join(types, ""), nil
+}
+
+// readLicenseText returns the first LICENSE, LICENCE, COPYING, or NOTICE file
+// in dir.
+func readLicenseText(dir string) (string, string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		upper := strings.ToUpper(entry.Name())
+		for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "NOTICE"} {
+			if !entry.IsDir() && strings.HasPrefix(upper, prefix) {
+				names = append(names, entry.Name())
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		return "", "", nil
+	}
+	sort.Strings(names)
+	content, err := os.ReadFile(filepath.Join(dir, names[0]))
+	if err != nil {
+		return "", "", err
+	}
+	return names[0], strings.TrimSpace(string(content)), nil
+}
+
+func buildCycloneDX(packages []npmPackage, version releaseVersion) ([]byte, error) {
+	serial := make([]byte, 16)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+	serial[6] = serial[6]&0x0f | 0x40
+	serial[8] = serial[8]&0x3f | 0x80
+	u := hex.EncodeToString(serial)
+
+	app := cycloneDXComponent{
+		Type:    "application",
+		BOMRef:  "web.runme.dev",
+		Name:    "web.runme.dev",
+		Version: version.WebCommit,
+		Properties: []cycloneDXProp{
+			{Name: "runme:webRepo", Value: version.WebRepo},
+			{Name: "runme:webBranch", Value: version.WebBranch},
+		},
+	}
+	bom := cycloneDXBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: fmt.Sprintf("urn:uuid:%s-%s-%s-%s-%s", u[0:8], u[8:12], u[12:16], u[16:20], u[20:32]),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: version.BuildDate,
+			Tools:     cycloneDXTools{Components: []cycloneDXComponent{{Type: "application", Name: "runme-web-releaser"}}},
+			Component: app,
+		},
+		Components:   []cycloneDXComponent{},
+		Dependencies: []cycloneDXDependency{},
+	}
+
+	purls := map[string]string{}
+	for _, pkg := range packages {
+		purls[pkg.key()] = pkg.purl()
+	}
+	roots := []string{}
+	for _, pkg := range packages {
+		component := cycloneDXComponent{
+			Type:    "library",
+			BOMRef:  pkg.purl(),
+			Name:    pkg.name,
+			Version: pkg.version,
+			PURL:    pkg.purl(),
+		}
+		if hash, ok := cycloneDXHashFromIntegrity(pkg.integrity); ok {
+			component.Hashes = []cycloneDXHash{hash}
+		}
+		if pkg.license != "" {
+			component.Licenses = []cycloneDXLicense{{Expression: pkg.license}}
+		}
+		bom.Components = append(bom.Components, component)
+
+		dependsOn := []string{}
+		for _, dep := range pkg.dependencies {
+			dependsOn = append(dependsOn, purls[dep])
+		}
+		bom.Dependencies = append(bom.Dependencies, cycloneDXDependency{Ref: pkg.purl(), DependsOn: dependsOn})
+		roots = append(roots, pkg.purl())
+	}
+	bom.Dependencies = append([]cycloneDXDependency{{Ref: app.BOMRef, DependsOn: roots}}, bom.Dependencies...)
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(bom); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cycloneDXHashFromIntegrity converts an npm integrity string such as
+// `sha512-<base64>` into a CycloneDX hex hash.
+func cycloneDXHashFromIntegrity(integrity string) (cycloneDXHash, bool) {
+	algorithm, encoded, ok := strings.Cut(integrity, "-")
+	if !ok {
+		return cycloneDXHash{}, false
+	}
+	names := map[string]string{"sha1": "SHA-1", "sha256": "SHA-256", "sha384": "SHA-384", "sha512": "SHA-512"}
+	name, ok := names[algorithm]
+	if !ok {
+		return cycloneDXHash{}, false
+	}
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return cycloneDXHash{}, false
+	}
+	return cycloneDXHash{Alg: name, Content: hex.EncodeToString(digest)}, true
+}
+
+// buildLicenseBundle concatenates the license of every shipped package.
+func buildLicenseBundle(packages []npmPackage, version releaseVersion) []byte {
+	var buf bytes.Buffer
+	rule := strings.Repeat("=", 80)
+	fmt.Fprintf(&buf, "Third-party software included in web.runme.dev (%s@%s).\n", version.WebRepo, version.WebCommit)
+	fmt.Fprintf(&buf, "Generated from pnpm-lock.yaml; see %s for the full dependency graph.\n", sbomFileName)
+	for _, pkg := range packages {
+		license := firstNonEmpty(pkg.license, "UNKNOWN")
+		fmt.Fprintf(&buf, "\n%s\n%s\nLicense: %s\n%s\n\n", rule, pkg.key(), license, rule)
+		if pkg.licenseText == "" {
+			buf.WriteString("(no license file in the package)\n")
+			continue
+		}
+		buf.WriteString(pkg.licenseText)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPNPMLock = `lockfileVersion: '9.0'
+
+importers:
+  .:
+    devDependencies:
+      typescript:
+        specifier: ^5.0.0
+        version: 5.4.5
+  app:
+    dependencies:
+      '@runmedev/renderers':
+        specifier: workspace:*
+        version: link:../packages/renderers
+      react-dom:
+        specifier: ^19.0.0
+        version: 19.2.0(react@19.2.0)
+    devDependencies:
+      vite:
+        specifier: ^6.0.0
+        version: 6.3.6
+  packages/renderers:
+    dependencies:
+      lit:
+        specifier: ^3.0.0
+        version: 3.3.1
+
+packages:
+  '@lit/reactive-element@2.1.1':
+    resolution: {integrity: sha512-AAAA}
+  lit@3.3.1:
+    resolution: {integrity: sha1-3q2+7w==}
+  react-dom@19.2.0:
+    resolution: {integrity: sha512-AAAA}
+  react@19.2.0:
+    resolution: {integrity: sha512-AAAA}
+  scheduler@0.27.0:
+    resolution: {integrity: sha512-AAAA}
+  typescript@5.4.5:
+    resolution: {integrity: sha512-AAAA}
+  vite@6.3.6:
+    resolution: {integrity: sha512-AAAA}
+
+snapshots:
+  '@lit/reactive-element@2.1.1': {}
+  lit@3.3.1:
+    dependencies:
+      '@lit/reactive-element': 2.1.1
+  react-dom@19.2.0(react@19.2.0):
+    dependencies:
+      react: 19.2.0
+      scheduler-alias: scheduler@0.27.0
+  react@19.2.0: {}
+  scheduler@0.27.0: {}
+  typescript@5.4.5: {}
+  vite@6.3.6: {}
+`
+
+func TestRuntimePackages(t *testing.T) {
+	t.Parallel()
+
+	packages, err := runtimePackages([]byte(testPNPMLock), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, pkg := range packages {
+		keys = append(keys, pkg.key())
+	}
+	want := "@lit/reactive-element@2.1.1,lit@3.3.1,react@19.2.0,react-dom@19.2.0,scheduler@0.27.0"
+	if got := strings.Join(keys, ","); got != want {
+		t.Fatalf("packages = %s, want %s", got, want)
+	}
+	if got := strings.Join(packages[3].dependencies, ","); got != "react@19.2.0,scheduler@0.27.0" {
+		t.Fatalf("react-dom dependencies = %s", got)
+	}
+	if got := packages[0].purl(); got != "pkg:npm/%40lit/reactive-element@2.1.1" {
+		t.Fatalf("purl = %s", got)
+	}
+
+	if _, err := runtimePackages([]byte("lockfileVersion: '6.0'\n"), "app"); err == nil {
+		t.Fatal("old lockfile versions should be rejected")
+	}
+}
+
+func TestWriteThirdPartyInventory(t *testing.T) {
+	t.Parallel()
+
+	store := "node_modules/.pnpm/"
+	repo := writeDist(t, map[string]string{
+		"pnpm-lock.yaml": testPNPMLock,
+		store + "lit@3.3.1/node_modules/lit/package.json":                                     `{"name":"lit","version":"3.3.1","license":"BSD-3-Clause"}`,
+		store + "lit@3.3.1/node_modules/lit/LICENSE":                                          "BSD 3-Clause License\nCopyright Google LLC\n",
+		store + "@lit+reactive-element@2.1.1/node_modules/@lit/reactive-element/package.json": `{"name":"@lit/reactive-element","version":"2.1.1","licenses":[{"type":"MIT"},{"type":"Apache-2.0"}]}`,
+		store + "react-dom@19.2.0_react@19.2.0/node_modules/react-dom/package.json":           `{"name":"react-dom","version":"19.2.0","license":"MIT"}`,
+		store + "react-dom@19.2.0_react@19.2.0/node_modules/react-dom/LICENSE.md":             "MIT License\n",
+		store + "react-dom@19.2.0_react@19.2.0/node_modules/react/package.json":               `{"name":"react","version":"0.0.0-stale"}`,
+		store + "react-dom@19.2.0_react@19.2.0/node_modules/scheduler/package.json":           `{"name":"scheduler","version":"0.27.0","license":{"type":"MIT"}}`,
+		"app/dist/index.html": "<html></html>",
+	})
+	distDir := filepath.Join(repo, "app", "dist")
+	version := releaseVersion{WebRepo: "runmedev/web", WebBranch: "main", WebCommit: "abc123"}
+	if err := writeThirdPartyInventory(repo, distDir, version); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(distDir, sbomFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bom cycloneDXBOM
+	if err := json.Unmarshal(content, &bom); err != nil {
+		t.Fatal(err)
+	}
+	if bom.BOMFormat != "CycloneDX" || bom.Metadata.Component.Version != "abc123" || len(bom.Components) != 5 {
+		t.Fatalf("bom = %+v", bom)
+	}
+	licenses := map[string]string{}
+	for _, component := range bom.Components {
+		if len(component.Licenses) == 1 {
+			licenses[component.Name] = component.Licenses[0].Expression
+		}
+	}
+	if licenses["lit"] != "BSD-3-Clause" || licenses["@lit/reactive-element"] != "(MIT OR Apache-2.0)" || licenses["scheduler"] != "MIT" {
+		t.Fatalf("licenses = %v", licenses)
+	}
+	if _, ok := licenses["react"]; ok {
+		t.Fatal("a package.json with another version must not supply the license")
+	}
+	if hash := bom.Components[1].Hashes; len(hash) != 1 || hash[0].Alg != "SHA-1" || hash[0].Content != "deadbeef" {
+		t.Fatalf("lit hashes = %+v", hash)
+	}
+	if root := bom.Dependencies[0]; root.Ref != "web.runme.dev" || len(root.DependsOn) != 5 {
+		t.Fatalf("root dependency = %+v", root)
+	}
+
+	bundle, err := os.ReadFile(filepath.Join(distDir, licensesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"lit@3.3.1\nLicense: BSD-3-Clause",
+		"Copyright Google LLC",
+		"react@19.2.0\nLicense: UNKNOWN\n" + strings.Repeat("=", 80) + "\n\n(no license file in the package)",
+	} {
+		if !strings.Contains(string(bundle), want) {
+			t.Fatalf("license bundle missing %q:\n%s", want, bundle)
+		}
+	}
+}
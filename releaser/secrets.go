@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// secretsConfig configures the pre-upload secret scan. Everything in dist is
+// public once published, so any finding that is not allowlisted blocks the
+// release.
+type secretsConfig struct {
+	// DisableDefaultRules drops defaultSecretRules and the default allowlist.
+	DisableDefaultRules bool          `yaml:"disableDefaultRules"`
+	Rules               []secretRule  `yaml:"rules"`
+	Allow               []secretAllow `yaml:"allow"`
+	Entropy             *entropyRule  `yaml:"entropy"`
+}
+
+// secretRule flags every match of Pattern in files matching Paths (all files
+// when empty). Paths use the same glob rules as bundle budgets.
+type secretRule struct {
+	ID      string   `yaml:"id"`
+	Pattern string   `yaml:"pattern"`
+	Paths   []string `yaml:"paths"`
+}
+
+// secretAllow suppresses findings. Every set field must match: Rule is a
+// rule ID, Paths are globs, and Pattern must match text covering the finding.
+type secretAllow struct {
+	Rule    string   `yaml:"rule"`
+	Pattern string   `yaml:"pattern"`
+	Paths   []string `yaml:"paths"`
+}
+
+// entropyRule flags long base64-like tokens with high Shannon entropy, which
+// catches credentials no pattern knows about. It is limited to config-like
+// files by default because minified JS is full of such tokens.
+type entropyRule struct {
+	MinLength int      `yaml:"minLength"`
+	Threshold float64  `yaml:"threshold"`
+	Paths     []string `yaml:"paths"`
+}
+
+type secretFinding struct {
+	path  string
+	line  int
+	rule  string
+	match string
+}
+
+const entropyRuleID = "high-entropy-string"
+
+var defaultSecretRules = []secretRule{
+	{ID: "private-key", Pattern: `-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`},
+	{ID: "gcp-service-account", Pattern: `"client_email"\s*:\s*"[^"@\s]+@[^"\s]+\.iam\.gserviceaccount\.com"`},
+	{ID: "google-api-key", Pattern: `AIza[0-9A-Za-z_-]{35}`},
+	{ID: "google-oauth-client-secret", Pattern: `GOCSPX-[A-Za-z0-9_-]{28}`},
+	{ID: "github-token", Pattern: `\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`},
+	{ID: "aws-access-key-id", Pattern: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{ID: "slack-token", Pattern: `\bxox[abprs]-[A-Za-z0-9-]{10,}`},
+	{ID: "slack-webhook", Pattern: `https://hooks\.slack\.com/services/[A-Za-z0-9_/]+`},
+	{ID: "openai-api-key", Pattern: `\bsk-(?:proj-)?[A-Za-z0-9_-]{32,}`},
+	{ID: "jwt", Pattern: `\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`},
+}
+
+var defaultSecretAllow = []secretAllow{
+	// OAuth client IDs identify the app and are public by design.
+	{Pattern: `[0-9]+-[a-z0-9]+\.apps\.googleusercontent\.com`},
+}
+
+var defaultEntropyRule = entropyRule{
+	MinLength: 32,
+	Threshold: 4.5,
+	Paths:     []string{"*.yaml", "*.yml", "*.json", "*.env", "*.webmanifest"},
+}
+
+var entropyTokenPattern = regexp.MustCompile(`[A-Za-z0-9+/=_-]+`)
+
+type compiledSecretRule struct {
+	secretRule
+	re *regexp.Regexp
+}
+
+type compiledSecretAllow struct {
+	secretAllow
+	re *regexp.Regexp
+}
+
+type secretScanner struct {
+	rules   []compiledSecretRule
+	allow   []compiledSecretAllow
+	entropy entropyRule
+}
+
+func newSecretScanner(cfg secretsConfig) (*secretScanner, error) {
+	rules := cfg.Rules
+	allow := cfg.Allow
+	entropy := defaultEntropyRule
+	if !cfg.DisableDefaultRules {
+		rules = append(append([]secretRule(nil), defaultSecretRules...), rules...)
+		allow = append(append([]secretAllow(nil), defaultSecretAllow...), allow...)
+	}
+	if cfg.Entropy != nil {
+		entropy = *cfg.Entropy
+	} else if cfg.DisableDefaultRules {
+		entropy = entropyRule{}
+	}
+
+	scanner := &secretScanner{entropy: entropy}
+	for _, rule := range rules {
+		if rule.ID == "" {
+			return nil, fmt.Errorf("secret rule %q has no id", rule.Pattern)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("secret rule %s: %w", rule.ID, err)
+		}
+		scanner.rules = append(scanner.rules, compiledSecretRule{secretRule: rule, re: re})
+	}
+	for _, entry := range allow {
+		compiled := compiledSecretAllow{secretAllow: entry}
+		if entry.Pattern != "" {
+			re, err := regexp.Compile(entry.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("secret allow %q: %w", entry.Pattern, err)
+			}
+			compiled.re = re
+		}
+		scanner.allow = append(scanner.allow, compiled)
+	}
+	return scanner, nil
+}
+
+// scanFiles scans every publish file. Binary files are skipped.
+func (s *secretScanner) scanFiles(files []publishFile) ([]secretFinding, error) {
+	findings := []secretFinding{}
+	for _, file := range files {
+		content, err := os.ReadFile(file.src)
+		if err != nil {
+			return nil, err
+		}
+		if isBinaryContent(content) {
+			continue
+		}
+		findings = append(findings, s.scan(file.dst, content)...)
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].path < findings[j].path })
+	return findings, nil
+}
+
+func (s *secretScanner) scan(rel string, content []byte) []secretFinding {
+	findings := []secretFinding{}
+	report := func(rule string, loc []int) {
+		if s.allowed(rel, rule, content, loc) {
+			return
+		}
+		findings = append(findings, secretFinding{
+			path:  rel,
+			line:  bytes.Count(content[:loc[0]], []byte("\n")) + 1,
+			rule:  rule,
+			match: string(content[loc[0]:loc[1]]),
+		})
+	}
+
+	for _, rule := range s.rules {
+		if !matchAnyGlob(rule.Paths, rel) {
+			continue
+		}
+		for _, loc := range rule.re.FindAllIndex(content, -1) {
+			report(rule.ID, loc)
+		}
+	}
+
+	if s.entropy.MinLength > 0 && len(s.entropy.Paths) > 0 && matchAnyGlob(s.entropy.Paths, rel) {
+		for _, loc := range entropyTokenPattern.FindAllIndex(content, -1) {
+			token := content[loc[0]:loc[1]]
+			if len(token) >= s.entropy.MinLength && shannonEntropy(token) >= s.entropy.Threshold {
+				report(entropyRuleID, loc)
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].line < findings[j].line })
+	return findings
+}
+
+// allowed reports whether an allowlist entry covers the finding at loc. A
+// pattern entry must match a span of the file that contains the finding, so
+// a client ID allow entry also covers high-entropy substrings of that ID.
+func (s *secretScanner) allowed(rel, rule string, content []byte, loc []int) bool {
+	for _, entry := range s.allow {
+		if entry.Rule != "" && entry.Rule != rule {
+			continue
+		}
+		if len(entry.Paths) > 0 && !matchAnyGlob(entry.Paths, rel) {
+			continue
+		}
+		if entry.re == nil {
+			return true
+		}
+		for _, allowed := range entry.re.FindAllIndex(content, -1) {
+			if allowed[0] <= loc[0] && loc[1] <= allowed[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchAnyGlob reports whether rel matches one of globs; no globs matches
+// every file.
+func matchAnyGlob(globs []string, rel string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		if matchBudgetGlob(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func shannonEntropy(token []byte) float64 {
+	counts := map[byte]int{}
+	for _, c := range token {
+		counts[c]++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(len(token))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func isBinaryContent(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// redactSecret keeps just enough of a match to find it again.
+func redactSecret(match string) string {
+	match = strings.ReplaceAll(match, "\n", " ")
+	if len(match) <= 8 {
+		return strings.Repeat("*", len(match))
+	}
+	return match[:6] + "..." + fmt.Sprintf(" (%d chars)", len(match))
+}
+
+// scanForSecrets is the release-time gate: it fails if any file about to be
+// published contains a secret that is not allowlisted.
+func scanForSecrets(cfg secretsConfig, files []publishFile) error {
+	scanner, err := newSecretScanner(cfg)
+	if err != nil {
+		return err
+	}
+	findings, err := scanner.scanFiles(files)
+	if err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		fmt.Printf("secret scan ok: %d files\n", len(files))
+		return nil
+	}
+	for _, finding := range findings {
+		fmt.Printf("secret: %s:%d: %s: %s\n", finding.path, finding.line, finding.rule, redactSecret(finding.match))
+	}
+	return fmt.Errorf("%d possible secret(s) in the build output; remove them or add an allow entry under secrets in --config", len(findings))
+}
+
+func newScanSecretsCmd() *cobra.Command {
+	var (
+		distDir    string
+		configPath string
+	)
+	cmd := &cobra.Command{
+		Use:   "scan-secrets --dist=<dir>",
+		Short: "Scan a build output directory for secrets with the release rules",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			releaserCfg, err := loadReleaserConfig(configPath)
+			if err != nil {
+				return fmt.Errorf("load --config: %w", err)
+			}
+			files, err := collectPublishFiles(distDir)
+			if err != nil {
+				return err
+			}
+			return scanForSecrets(releaserCfg.Secrets, files)
+		},
+	}
+	cmd.Flags().StringVar(&distDir, "dist", "../app/dist", "build output directory to scan")
+	cmd.Flags().StringVar(&configPath, "config", "", "releaser config file with secrets rules and allowlist")
+	return cmd
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newServeCmd() *cobra.Command {
+	var (
+		bucket     string
+		addr       string
+		urlMapPath string
+	)
+	cmd := &cobra.Command{
+		Use:   "serve --bucket=<dir>",
+		Short: "Serve a local-directory release the way web.runme.dev hosting would",
+		Long: `Serve a release published to a local directory over HTTP.
+
+Requests are rewritten with the rules from the url-map (for example
+/oidc/callback -> /index.html) and responses carry the cache-control,
+content-type, and content-disposition the bucket upload would have set.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if isRemoteBucket(bucket) {
+				return fmt.Errorf("serve only supports local directories, got %s", bucket)
+			}
+			if err := assertDir(bucket); err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("url-map") {
+				urlMapPath = defaultURLMap(bucket)
+				if urlMapPath == "" {
+					fmt.Printf("no url-map.yaml next to or inside %s; serving without rewrites\n", bucket)
+				}
+			}
+			m := urlMap{}
+			if urlMapPath != "" {
+				var err error
+				if m, err = loadURLMap(urlMapPath); err != nil {
+					return fmt.Errorf("load --url-map: %w", err)
+				}
+			}
+
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("serving %s on http://%s\n", bucket, listener.Addr())
+			server := &http.Server{Handler: newReleaseHandler(bucket, m)}
+			go func() {
+				<-cmd.Context().Done()
+				_ = server.Close()
+			}()
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&bucket, "bucket", "", "local directory holding a published release")
+	cmd.Flags().StringVar(&addr, "addr", "127.0.0.1:8080", "address to listen on")
+	cmd.Flags().StringVar(&urlMapPath, "url-map", "", "url-map YAML whose rewrites to apply (default <bucket>/../url-map.yaml or <bucket>/url-map.yaml if present; empty to disable)")
+	_ = cmd.MarkFlagRequired("bucket")
+
+	return cmd
+}
+
+// defaultURLMap finds the url-map for dir when --url-map is not given: next
+// to it, where it sits when dir is an app's dist, or else inside it. It
+// returns "" when there is neither.
+func defaultURLMap(dir string) string {
+	for _, candidate := range []string{
+		filepath.Join(dir, "..", "url-map.yaml"),
+		filepath.Join(dir, "url-map.yaml"),
+	} {
+		if assertFile(candidate) == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// newReleaseHandler serves objects from dir like a backend bucket behind the
+// url map: rewrite first, then look up the object, `/` maps to index.html.
+func newReleaseHandler(dir string, m urlMap) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		target, _ := m.rewrite(host, r.URL.Path)
+
+		rel := strings.TrimPrefix(path.Clean("/"+target), "/")
+		if rel == "" {
+			rel = "index.html"
+		}
+
+		full := filepath.Join(dir, filepath.FromSlash(rel))
+		f, err := os.Open(full)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		cacheControl, contentType, contentDisposition, group := classifyFile(rel)
+		file := publishFile{dst: rel, cacheControl: cacheControl, contentType: contentType, contentDisposition: contentDisposition, group: group}
+		w.Header().Set("Cache-Control", file.cacheControl)
+		w.Header().Set("Content-Type", objectContentType(file))
+		if file.contentDisposition != "" {
+			w.Header().Set("Content-Disposition", file.contentDisposition)
+		}
+		http.ServeContent(w, r, rel, info.ModTime(), f)
+	})
+}
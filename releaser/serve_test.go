@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestReleaseHandlerAppliesURLMapAndCacheRules(t *testing.T) {
+	t.Parallel()
+
+	m, err := loadURLMap("../app/url-map.yaml")
+	if err != nil {
+		t.Fatalf("loadURLMap() error = %v", err)
+	}
+	dir := writeDist(t, map[string]string{
+		"index.html":               "<html>app</html>",
+		"index.abcdefgh.js":        "console.log(1)",
+		"configs/app-configs.yaml": "agent: {}\n",
+		versionFileName:            "webCommit: abc\n",
+	})
+	server := httptest.NewServer(newReleaseHandler(dir, m))
+	defer server.Close()
+
+	cases := []struct {
+		path         string
+		status       int
+		body         string
+		cacheControl string
+		contentType  string
+	}{
+		{path: "/", status: 200, body: "<html>app</html>", cacheControl: "no-cache, max-age=0, must-revalidate", contentType: "text/html; charset=utf-8"},
+		{path: "/oidc/callback?code=abc", status: 200, body: "<html>app</html>", cacheControl: "no-cache, max-age=0, must-revalidate"},
+		{path: "/gdrive/callback", status: 200, body: "<html>app</html>"},
+		{path: "/index.abcdefgh.js", status: 200, cacheControl: "public, max-age=31536000, immutable", contentType: "text/javascript; charset=utf-8"},
+		{path: "/version.yaml", status: 200, contentType: "text/plain; charset=utf-8"},
+		{path: "/runs", status: 404},
+		{path: "/../main.go", status: 404},
+	}
+	for _, tc := range cases {
+		resp, err := http.Get(server.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("GET %s = %d, want %d", tc.path, resp.StatusCode, tc.status)
+		}
+		if tc.body != "" && string(body) != tc.body {
+			t.Fatalf("GET %s body = %q, want %q", tc.path, body, tc.body)
+		}
+		if tc.cacheControl != "" && resp.Header.Get("Cache-Control") != tc.cacheControl {
+			t.Fatalf("GET %s Cache-Control = %q, want %q", tc.path, resp.Header.Get("Cache-Control"), tc.cacheControl)
+		}
+		if tc.contentType != "" && resp.Header.Get("Content-Type") != tc.contentType {
+			t.Fatalf("GET %s Content-Type = %q, want %q", tc.path, resp.Header.Get("Content-Type"), tc.contentType)
+		}
+	}
+}
+
+func TestDefaultURLMap(t *testing.T) {
+	t.Parallel()
+
+	app := writeDist(t, map[string]string{
+		"url-map.yaml":    "hostRules: []\n",
+		"dist/index.html": "<html></html>",
+	})
+	if got := defaultURLMap(filepath.Join(app, "dist")); got != filepath.Join(app, "url-map.yaml") {
+		t.Fatalf("defaultURLMap(dist) = %q", got)
+	}
+
+	bucket := writeDist(t, map[string]string{
+		"index.html":   "<html></html>",
+		"url-map.yaml": "hostRules: []\n",
+	})
+	if got := defaultURLMap(bucket); got != filepath.Join(bucket, "url-map.yaml") {
+		t.Fatalf("defaultURLMap(bucket) = %q", got)
+	}
+	if got := defaultURLMap(t.TempDir()); got != "" {
+		t.Fatalf("defaultURLMap() without a url-map = %q", got)
+	}
+}
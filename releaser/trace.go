@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// traceOptions are the tracing flags shared by the publishing commands.
+type traceOptions struct {
+	// endpoint is an OTLP/HTTP base URL; spans are posted to
+	// <endpoint>/v1/traces as OTLP JSON.
+	endpoint string
+	// file receives the same OTLP JSON document.
+	file string
+	// logDir receives one log file per traced step with subprocess output.
+	logDir string
+}
+
+func (o traceOptions) enabled() bool {
+	return o.endpoint != "" || o.file != "" || o.logDir != ""
+}
+
+func addTraceFlags(cmd *cobra.Command, opts *traceOptions) {
+	flags := cmd.Flags()
+	flags.StringVar(&opts.endpoint, "trace-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP endpoint to export release step spans to (defaults to $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flags.StringVar(&opts.file, "trace-file", "", "write release step spans to this file as OTLP JSON")
+	flags.StringVar(&opts.logDir, "log-dir", "", "capture subprocess output of each release step to a log file in this directory")
+}
+
+// tracer records the spans of one command run. Spans are exported together
+// when the run ends; a release is short enough that streaming is not needed.
+type tracer struct {
+	opts    traceOptions
+	traceID string
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+// span is one traced step. A nil *span is valid and records nothing, so
+// helpers can trace unconditionally.
+type span struct {
+	tracer   *tracer
+	id       string
+	parentID string
+	name     string
+	depth    int
+	start    time.Time
+	end      time.Time
+	attrs    []traceAttr
+	err      string
+	logPath  string
+	logFile  *os.File
+}
+
+type traceAttr struct {
+	key   string
+	value any
+}
+
+type traceContextKey struct{}
+
+// startTrace begins a trace for a command with a root span called name. The
+// returned finish ends the root span with the command's error, exports the
+// spans, and prints the step report. Without any tracing flags it returns
+// ctx unchanged and a no-op finish.
+func startTrace(ctx context.Context, opts traceOptions, name string) (context.Context, func(error)) {
+	if !opts.enabled() {
+		return ctx, func(error) {}
+	}
+	t := &tracer{opts: opts, traceID: randomHex(16)}
+	ctx, root := t.start(ctx, nil, name)
+	return ctx, func(err error) {
+		root.finish(err)
+		t.report(os.Stdout)
+		if err := t.export(context.WithoutCancel(ctx)); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: export trace: %v\n", err)
+		}
+	}
+}
+
+// startSpan starts a child of the span in ctx. Attributes are key, value
+// pairs.
+func startSpan(ctx context.Context, name string, attrs ...any) (context.Context, *span) {
+	parent, _ := ctx.Value(traceContextKey{}).(*span)
+	if parent == nil {
+		return ctx, nil
+	}
+	ctx, s := parent.tracer.start(ctx, parent, name)
+	s.set(attrs...)
+	return ctx, s
+}
+
+func (t *tracer) start(ctx context.Context, parent *span, name string) (context.Context, *span) {
+	s := &span{tracer: t, id: randomHex(8), name: name, start: time.Now()}
+	if parent != nil {
+		s.parentID = parent.id
+		s.depth = parent.depth + 1
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return context.WithValue(ctx, traceContextKey{}, s), s
+}
+
+func (s *span) set(attrs ...any) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs = append(s.attrs, traceAttr{key: fmt.Sprint(attrs[i]), value: attrs[i+1]})
+	}
+}
+
+// finish ends s, marking it failed when err is not nil.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	if s.logFile != nil {
+		_ = s.logFile.Close()
+		s.logFile = nil
+	}
+}
+
+var logNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// logWriter returns the step log of the span in ctx, creating it on first
+// use, or nil when step logs are off.
+func logWriter(ctx context.Context) io.Writer {
+	s, _ := ctx.Value(traceContextKey{}).(*span)
+	if s == nil || s.tracer.opts.logDir == "" {
+		return nil
+	}
+	if s.logFile != nil {
+		return s.logFile
+	}
+	t := s.tracer
+	if err := os.MkdirAll(t.opts.logDir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: step log: %v\n", err)
+		return nil
+	}
+	t.mu.Lock()
+	index := len(t.spans)
+	for i, candidate := range t.spans {
+		if candidate == s {
+			index = i
+		}
+	}
+	t.mu.Unlock()
+	name := fmt.Sprintf("%02d-%s.log", index, strings.Trim(logNameUnsafe.ReplaceAllString(s.name, "-"), "-"))
+	path := filepath.Join(t.opts.logDir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: step log: %v\n", err)
+		return nil
+	}
+	s.logFile = f
+	s.logPath = path
+	s.set("log.file", path)
+	return f
+}
+
+// report prints every span in start order with its duration and log file.
+func (t *tracer) report(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(w, "trace %s\n", t.traceID)
+	for _, s := range t.spans {
+		line := fmt.Sprintf("%s%-*s %8s", strings.Repeat("  ", s.depth+1), 28-2*s.depth, s.name, s.duration().Round(time.Millisecond))
+		if s.err != "" {
+			line += "  FAILED"
+		}
+		if s.logPath != "" {
+			line += "  " + s.logPath
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+func (s *span) duration() time.Duration {
+	if s.end.IsZero() {
+		return time.Since(s.start)
+	}
+	return s.end.Sub(s.start)
+}
+
+func (t *tracer) export(ctx context.Context) error {
+	if t.opts.endpoint == "" && t.opts.file == "" {
+		return nil
+	}
+	body, err := json.Marshal(t.otlp())
+	if err != nil {
+		return err
+	}
+	if t.opts.file != "" {
+		if err := os.WriteFile(t.opts.file, append(body, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+	if t.opts.endpoint != "" {
+		return postOTLP(ctx, t.opts.endpoint, body)
+	}
+	return nil
+}
+
+func postOTLP(ctx context.Context, endpoint string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	url := strings.TrimRight(endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return httpStatusError(resp)
+	}
+	return nil
+}
+
+// OTLP JSON encoding of the trace, as accepted by OTLP/HTTP collectors.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+func (t *tracer) otlp() otlpTraces {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := make([]otlpSpan, 0, len(t.spans))
+	for _, s := range t.spans {
+		end := s.end
+		if end.IsZero() {
+			end = time.Now()
+		}
+		out := otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            s.id,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		for _, attr := range s.attrs {
+			out.Attributes = append(out.Attributes, otlpAttr(attr.key, attr.value))
+		}
+		if s.err != "" {
+			out.Status = otlpStatus{Code: otlpStatusError, Message: s.err}
+		}
+		spans = append(spans, out)
+	}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{otlpAttr("service.name", "runme-web-releaser")}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "releaser"},
+			Spans: spans,
+		}},
+	}}}
+}
+
+func otlpAttr(key string, value any) otlpKeyValue {
+	switch v := value.(type) {
+	case bool:
+		return otlpKeyValue{Key: key, Value: map[string]any{"boolValue": v}}
+	case int:
+		return otlpKeyValue{Key: key, Value: map[string]any{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpKeyValue{Key: key, Value: map[string]any{"intValue": strconv.FormatInt(v, 10)}}
+	case float64:
+		return otlpKeyValue{Key: key, Value: map[string]any{"doubleValue": v}}
+	default:
+		return otlpKeyValue{Key: key, Value: map[string]any{"stringValue": fmt.Sprint(v)}}
+	}
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
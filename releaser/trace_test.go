@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTraceExportAndStepLogs(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	opts := traceOptions{endpoint: server.URL, file: filepath.Join(dir, "trace.json"), logDir: filepath.Join(dir, "logs")}
+	ctx, finish := startTrace(context.Background(), opts, "release")
+
+	stepCtx, step := startSpan(ctx, "pnpm build:app", "process.command_line", "pnpm build:app")
+	if err := runShell(stepCtx, "", nil, "echo building; echo warning >&2"); err != nil {
+		t.Fatal(err)
+	}
+	step.finish(nil)
+
+	_, upload := startSpan(ctx, "upload group 1", "files", 3, "bytes", int64(42))
+	upload.finish(errors.New("denied"))
+	finish(errors.New("upload failed"))
+
+	fromFile, err := os.ReadFile(opts.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(fromFile)) != string(received) {
+		t.Fatal("trace file and exported body differ")
+	}
+
+	var doc otlpTraces
+	if err := json.Unmarshal(received, &doc); err != nil {
+		t.Fatal(err)
+	}
+	spans := doc.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	root, build, group := spans[0], spans[1], spans[2]
+	if len(root.TraceID) != 32 || build.TraceID != root.TraceID || build.ParentSpanID != root.SpanID || root.ParentSpanID != "" {
+		t.Fatalf("span ids: root=%+v build=%+v", root, build)
+	}
+	if root.Status.Code != otlpStatusError || group.Status.Message != "denied" || build.Status.Code != otlpStatusOK {
+		t.Fatalf("statuses: root=%+v group=%+v build=%+v", root.Status, group.Status, build.Status)
+	}
+	if got := group.Attributes[1]; got.Key != "bytes" || got.Value["intValue"] != "42" {
+		t.Fatalf("bytes attribute = %+v", got)
+	}
+
+	var logPath string
+	for _, attr := range build.Attributes {
+		if attr.Key == "log.file" {
+			logPath, _ = attr.Value["stringValue"].(string)
+		}
+	}
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("step log %q: %v", logPath, err)
+	}
+	for _, want := range []string{"$ /bin/sh -lc", "building", "warning"} {
+		if !strings.Contains(string(log), want) {
+			t.Fatalf("step log missing %q:\n%s", want, log)
+		}
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	ctx, finish := startTrace(context.Background(), traceOptions{}, "release")
+	ctx, span := startSpan(ctx, "clone")
+	if span != nil || logWriter(ctx) != nil {
+		t.Fatal("spans recorded without tracing flags")
+	}
+	span.set("key", "value")
+	span.finish(nil)
+	finish(nil)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	updatePolicyFileName = "update-policy.json"
+	updatePolicyFormat   = 1
+)
+
+// updatePolicyAttempts bounds how often updateUpdatePolicy retries when
+// another publisher changes the policy between its read and its write.
+const updatePolicyAttempts = 5
+
+// updatePolicy is published next to version.yaml for running clients to
+// poll. The service worker keeps serving an old build until it updates, so
+// clients compare their own build against this document:
+//
+//   - a client whose build date is before MinimumBuildDate, and whose commit
+//     is not MinimumCommit, is unsupported and must reload;
+//   - with ForceReload, every client not on LatestCommit reloads now
+//     instead of waiting for the service worker update.
+//
+// Serial grows with every change, so clients can tell a new signal from one
+// they already acted on.
+type updatePolicy struct {
+	Format           int    `json:"format"`
+	Serial           int    `json:"serial"`
+	LatestCommit     string `json:"latestCommit"`
+	LatestBuildDate  string `json:"latestBuildDate"`
+	MinimumCommit    string `json:"minimumCommit,omitempty"`
+	MinimumBuildDate string `json:"minimumBuildDate,omitempty"`
+	ForceReload      bool   `json:"forceReload"`
+	Reason           string `json:"reason,omitempty"`
+	UpdatedAt        string `json:"updatedAt"`
+	UpdatedBy        string `json:"updatedBy"`
+}
+
+func newForceUpdateCmd() *cobra.Command {
+	cfg := config{}
+	var (
+		minimum     string
+		reason      string
+		forceReload bool
+	)
+	cmd := &cobra.Command{
+		Use:   "force-update --reason=<why>",
+		Short: "Raise the minimum supported build and signal clients to reload",
+		Long: `Bump the update-policy.json served next to version.yaml without a release.
+
+By default the minimum supported build becomes the published release, and
+forceReload tells every client on an older build to reload now. Use
+--minimum to name an earlier release from the history instead, for example
+the first build with a security fix.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			releaserCfg, err := loadReleaserConfig(cfg.configPath)
+			if err != nil {
+				return fmt.Errorf("load --config: %w", err)
+			}
+			cfg.bucketSet = cmd.Flags().Changed("bucket")
+			if err := applyProfile(&cfg, releaserCfg); err != nil {
+				return err
+			}
+
+			published, exists, err := readVersion(ctx, cfg.bucket)
+			if err != nil {
+				return fmt.Errorf("read current version marker: %w", err)
+			}
+			if !exists {
+				return fmt.Errorf("no %s in %s; publish a release first", versionFileName, cfg.bucket)
+			}
+			minimumVersion := published
+			if minimum != "" {
+				history, err := readReleaseHistory(ctx, cfg.bucket)
+				if err != nil {
+					return fmt.Errorf("read release history: %w", err)
+				}
+				if minimumVersion, err = findHistoryVersion(history, minimum); err != nil {
+					return err
+				}
+			}
+			force := func(previous *updatePolicy) (updatePolicy, error) {
+				if previous != nil && previous.LatestCommit != published.WebCommit {
+					return updatePolicy{}, fmt.Errorf("%s moved to %s while forcing the update; run force-update again",
+						updatePolicyFileName, shortSHA(previous.LatestCommit, shortSHALen))
+				}
+				return forcedUpdatePolicy(previous, published, minimumVersion, forceReload, reason, publisherIdentity(cfg.publisher))
+			}
+			if cfg.dryRun {
+				previous, _, err := readUpdatePolicy(ctx, cfg.bucket)
+				if err != nil {
+					return err
+				}
+				policy, err := force(previous)
+				if err != nil {
+					return err
+				}
+				return printUpdatePolicy(policy)
+			}
+			policy, err := updateUpdatePolicy(ctx, cfg.bucket, force)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("update policy %d published to %s: minimum=%s forceReload=%t\n",
+				policy.Serial, destinationURL(cfg.bucket, updatePolicyFileName), shortSHA(policy.MinimumCommit, shortSHALen), policy.ForceReload)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&cfg.bucket, "bucket", defaultBucket, "destination bucket URL (gs://, s3://, az://) or local directory")
+	cmd.Flags().StringVar(&cfg.profile, "profile", "", "deployment profile from --config; sets the bucket")
+	cmd.Flags().StringVar(&cfg.configPath, "config", "", "releaser config file (profiles)")
+	cmd.Flags().StringVar(&cfg.publisher, "publisher", "", "identity recorded in the policy (defaults to the CI run or local user)")
+	cmd.Flags().BoolVar(&cfg.dryRun, "dry-run", false, "print the new policy without publishing it")
+	cmd.Flags().StringVar(&minimum, "minimum", "", "commit (or unique prefix) of a published release to require instead of the current one")
+	cmd.Flags().StringVar(&reason, "reason", "", "why clients must update, shown to users")
+	cmd.Flags().BoolVar(&forceReload, "force-reload", true, "tell clients on older builds to reload now")
+	_ = cmd.MarkFlagRequired("reason")
+	return cmd
+}
+
+// releasedUpdatePolicy is the policy after publishing version: the latest
+// build moves, the minimum is kept, and any force signal is cleared since
+// the new release supersedes it.
+func releasedUpdatePolicy(previous *updatePolicy, version releaseVersion, publisher string) updatePolicy {
+	policy := updatePolicy{Format: updatePolicyFormat}
+	if previous != nil {
+		policy = *previous
+		policy.Format = updatePolicyFormat
+		policy.ForceReload = false
+		policy.Reason = ""
+	}
+	policy.Serial++
+	policy.LatestCommit = version.WebCommit
+	policy.LatestBuildDate = version.BuildDate
+	policy.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	policy.UpdatedBy = publisher
+	return policy
+}
+
+// forcedUpdatePolicy raises the minimum to minimum, which must not be newer
+// than the published release, and sets the force signal.
+func forcedUpdatePolicy(previous *updatePolicy, published, minimum releaseVersion, forceReload bool, reason, publisher string) (updatePolicy, error) {
+	minimumDate, err := time.Parse(time.RFC3339, minimum.BuildDate)
+	if err != nil {
+		return updatePolicy{}, fmt.Errorf("release %s has no usable build date: %w", shortSHA(minimum.WebCommit, shortSHALen), err)
+	}
+	if publishedDate, err := time.Parse(time.RFC3339, published.BuildDate); err == nil && minimumDate.After(publishedDate) {
+		return updatePolicy{}, fmt.Errorf("release %s is newer than the published release %s", shortSHA(minimum.WebCommit, shortSHALen), shortSHA(published.WebCommit, shortSHALen))
+	}
+	if previous != nil && previous.MinimumBuildDate != "" {
+		if current, err := time.Parse(time.RFC3339, previous.MinimumBuildDate); err == nil && minimumDate.Before(current) {
+			return updatePolicy{}, fmt.Errorf("release %s is older than the current minimum %s", shortSHA(minimum.WebCommit, shortSHALen), shortSHA(previous.MinimumCommit, shortSHALen))
+		}
+	}
+
+	policy := releasedUpdatePolicy(previous, published, publisher)
+	policy.MinimumCommit = minimum.WebCommit
+	policy.MinimumBuildDate = minimum.BuildDate
+	policy.ForceReload = forceReload
+	policy.Reason = reason
+	return policy, nil
+}
+
+// findHistoryVersion returns the published release whose commit starts with
+// prefix.
+func findHistoryVersion(history []releaseHistoryEntry, prefix string) (releaseVersion, error) {
+	var found *releaseVersion
+	for i := range history {
+		version := history[i].Version
+		if !strings.HasPrefix(version.WebCommit, prefix) {
+			continue
+		}
+		if found != nil && found.WebCommit != version.WebCommit {
+			return releaseVersion{}, fmt.Errorf("commit prefix %q is ambiguous", prefix)
+		}
+		found = &history[i].Version
+	}
+	if found == nil {
+		return releaseVersion{}, fmt.Errorf("no published release with commit %q in the history", prefix)
+	}
+	return *found, nil
+}
+
+func readUpdatePolicy(ctx context.Context, bucket string) (*updatePolicy, bool, error) {
+	content, exists, err := readObject(ctx, bucket, updatePolicyFileName)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	policy, err := parseUpdatePolicy(content)
+	if err != nil {
+		return nil, false, err
+	}
+	return policy, true, nil
+}
+
+func parseUpdatePolicy(content []byte) (*updatePolicy, error) {
+	var policy updatePolicy
+	if err := json.Unmarshal(content, &policy); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", updatePolicyFileName, err)
+	}
+	return &policy, nil
+}
+
+// updateUpdatePolicy writes the policy change makes from the published one,
+// which is nil before the first release. The write only lands if the policy
+// is unchanged since it was read; otherwise change runs again on the newer
+// policy, so a concurrent release or force-update is not lost.
+func updateUpdatePolicy(ctx context.Context, bucket string, change func(previous *updatePolicy) (updatePolicy, error)) (updatePolicy, error) {
+	for attempt := 1; ; attempt++ {
+		content, version, err := readObjectVersion(ctx, bucket, updatePolicyFileName)
+		if err != nil {
+			return updatePolicy{}, err
+		}
+		var previous *updatePolicy
+		if version != "" {
+			if previous, err = parseUpdatePolicy(content); err != nil {
+				return updatePolicy{}, err
+			}
+		}
+		policy, err := change(previous)
+		if err != nil {
+			return updatePolicy{}, err
+		}
+		encoded, err := json.MarshalIndent(policy, "", "  ")
+		if err != nil {
+			return updatePolicy{}, err
+		}
+
+		err = writeObjectIfVersion(ctx, bucket, updatePolicyFileName, append(encoded, '\n'), "no-cache, max-age=0, must-revalidate", "application/json", version)
+		if err == nil {
+			return policy, nil
+		}
+		if !errors.Is(err, errObjectChanged) {
+			return updatePolicy{}, fmt.Errorf("write %s: %w", updatePolicyFileName, err)
+		}
+		if attempt == updatePolicyAttempts {
+			return updatePolicy{}, fmt.Errorf("%s kept changing after %d attempts: %w", updatePolicyFileName, attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return updatePolicy{}, ctx.Err()
+		case <-time.After(time.Duration(attempt) * 200 * time.Millisecond):
+		}
+	}
+}
+
+func printUpdatePolicy(policy updatePolicy) error {
+	content, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", content)
+	return err
+}
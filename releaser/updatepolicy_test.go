@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdatePolicy(t *testing.T) {
+	ctx := context.Background()
+	bucket := t.TempDir()
+	publish := func(commit, buildDate string) releaseVersion {
+		t.Helper()
+		dist := writeDist(t, map[string]string{
+			"index.html":    "<html>" + commit + "</html>",
+			versionFileName: "webRepo: runmedev/web\nwebCommit: " + commit + "\nbuildDate: " + buildDate + "\n",
+		})
+		build, err := finalizeLocalDist(dist, hardeningConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := publishRelease(ctx, bucket, "tester", build, nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		return build.version
+	}
+
+	first := publish("1111111111", "2026-10-01T00:00:00Z")
+	second := publish("2222222222", "2026-10-02T00:00:00Z")
+	policy, exists, err := readUpdatePolicy(ctx, bucket)
+	if err != nil || !exists {
+		t.Fatalf("policy = %v, %v", exists, err)
+	}
+	if policy.Serial != 2 || policy.LatestCommit != "2222222222" || policy.MinimumCommit != "" || policy.ForceReload {
+		t.Fatalf("policy after releases = %+v", policy)
+	}
+
+	history, err := readReleaseHistory(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minimum, err := findHistoryVersion(history, "1111")
+	if err != nil || minimum.WebCommit != first.WebCommit {
+		t.Fatalf("findHistoryVersion = %+v, %v", minimum, err)
+	}
+	forced, err := forcedUpdatePolicy(policy, second, minimum, true, "security fix", "oncall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forced.Serial != 3 || forced.MinimumCommit != "1111111111" || forced.MinimumBuildDate != "2026-10-01T00:00:00Z" || !forced.ForceReload || forced.Reason != "security fix" {
+		t.Fatalf("forced policy = %+v", forced)
+	}
+	if _, err := updateUpdatePolicy(ctx, bucket, func(*updatePolicy) (updatePolicy, error) { return forced, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The minimum only moves forward and never past the published release.
+	if _, err := forcedUpdatePolicy(&forced, second, releaseVersion{WebCommit: "0000000000", BuildDate: "2026-09-01T00:00:00Z"}, true, "", ""); err == nil || !strings.Contains(err.Error(), "older than the current minimum") {
+		t.Fatalf("lowering the minimum: err = %v", err)
+	}
+	if _, err := forcedUpdatePolicy(&forced, first, second, true, "", ""); err == nil || !strings.Contains(err.Error(), "newer than the published release") {
+		t.Fatalf("minimum past published: err = %v", err)
+	}
+
+	// The next release keeps the minimum and clears the force signal.
+	publish("3333333333", "2026-10-03T00:00:00Z")
+	policy, _, err = readUpdatePolicy(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Serial != 4 || policy.LatestCommit != "3333333333" || policy.MinimumCommit != "1111111111" || policy.ForceReload || policy.Reason != "" {
+		t.Fatalf("policy after next release = %+v", policy)
+	}
+}
+
+func TestUpdateUpdatePolicyRetriesOnConflict(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	bucket := t.TempDir()
+
+	// A force-update lands between the release's read and its write.
+	attempts := 0
+	policy, err := updateUpdatePolicy(ctx, bucket, func(previous *updatePolicy) (updatePolicy, error) {
+		attempts++
+		if attempts == 1 {
+			forced := updatePolicy{Format: updatePolicyFormat, Serial: 7, MinimumCommit: "1111111111", ForceReload: true}
+			content, err := json.Marshal(forced)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writeObject(ctx, bucket, updatePolicyFileName, content, "", "application/json"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return releasedUpdatePolicy(previous, releaseVersion{WebCommit: "2222222222"}, "tester"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("change ran %d times, want a retry after the conflict", attempts)
+	}
+	stored, _, err := readUpdatePolicy(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The release builds on the concurrent policy instead of dropping it.
+	if stored.Serial != 8 || stored.MinimumCommit != "1111111111" || stored.LatestCommit != "2222222222" || *stored != policy {
+		t.Fatalf("stored policy = %+v", stored)
+	}
+}
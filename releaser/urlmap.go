@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// urlMap is the subset of a Google Cloud load balancer URL map
+// (app/url-map.yaml) that affects which bucket object serves a request.
+type urlMap struct {
+	Name           string           `yaml:"name"`
+	DefaultService string           `yaml:"defaultService"`
+	HostRules      []urlMapHostRule `yaml:"hostRules"`
+	PathMatchers   []urlMapMatcher  `yaml:"pathMatchers"`
+}
+
+type urlMapHostRule struct {
+	Hosts       []string `yaml:"hosts"`
+	PathMatcher string   `yaml:"pathMatcher"`
+}
+
+type urlMapMatcher struct {
+	Name           string           `yaml:"name"`
+	DefaultService string           `yaml:"defaultService"`
+	PathRules      []urlMapPathRule `yaml:"pathRules"`
+}
+
+type urlMapPathRule struct {
+	Paths       []string `yaml:"paths"`
+	Service     string   `yaml:"service"`
+	RouteAction struct {
+		URLRewrite struct {
+			PathPrefixRewrite string `yaml:"pathPrefixRewrite"`
+		} `yaml:"urlRewrite"`
+	} `yaml:"routeAction"`
+}
+
+func loadURLMap(path string) (urlMap, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return urlMap{}, err
+	}
+	var m urlMap
+	if err := yaml.Unmarshal(content, &m); err != nil {
+		return urlMap{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// matcherForHost returns the path matcher selected by host, falling back to
+// the first host rule so a local server behaves like the production host.
+func (m urlMap) matcherForHost(host string) *urlMapMatcher {
+	name := ""
+	for _, rule := range m.HostRules {
+		for _, candidate := range rule.Hosts {
+			if candidate == "*" || strings.EqualFold(candidate, host) {
+				name = rule.PathMatcher
+				break
+			}
+		}
+		if name != "" {
+			break
+		}
+	}
+	if name == "" && len(m.HostRules) > 0 {
+		name = m.HostRules[0].PathMatcher
+	}
+	for i := range m.PathMatchers {
+		if m.PathMatchers[i].Name == name {
+			return &m.PathMatchers[i]
+		}
+	}
+	return nil
+}
+
+// rewrite applies the path rules for host to path. Like the load balancer, an
+// exact path beats a `/prefix/*` pattern and longer prefixes win.
+func (m urlMap) rewrite(host, path string) (string, bool) {
+	matcher := m.matcherForHost(host)
+	if matcher == nil {
+		return path, false
+	}
+
+	var best *urlMapPathRule
+	bestPattern := ""
+	for i := range matcher.PathRules {
+		rule := &matcher.PathRules[i]
+		for _, pattern := range rule.Paths {
+			if !urlMapPathMatches(pattern, path) {
+				continue
+			}
+			if best == nil || urlMapPatternRank(pattern) > urlMapPatternRank(bestPattern) {
+				best = rule
+				bestPattern = pattern
+			}
+		}
+	}
+	if best == nil || best.RouteAction.URLRewrite.PathPrefixRewrite == "" {
+		return path, false
+	}
+
+	prefix := strings.TrimSuffix(bestPattern, "*")
+	return best.RouteAction.URLRewrite.PathPrefixRewrite + strings.TrimPrefix(path, prefix), true
+}
+
+func urlMapPathMatches(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(path, prefix+"/")
+	}
+	return pattern == path
+}
+
+func urlMapPatternRank(pattern string) int {
+	if strings.HasSuffix(pattern, "*") {
+		return len(pattern)
+	}
+	// Exact matches always outrank prefix matches.
+	return len(pattern) + 1<<16
+}
+
+// callbackRoutePattern finds callback entries in the SPA route table, for
+// example `oidcCallback: "/oidc/callback"` in app/src/lib/appBase.ts.
+var callbackRoutePattern = regexp.MustCompile(`(?m)^\s*\w*[Cc]allback\w*\s*:\s*["'](/[^"']*)["']`)
+
+const appRoutesPath = "src/lib/appBase.ts"
+
+// checkURLMap cross-checks the url map in appDir against the routes the app
+// expects the host to serve. It reports callback paths the map does not
+// rewrite to the SPA, and rewrites whose target is missing from distDir.
+// distDir may be empty to skip the target check.
+func checkURLMap(appDir, distDir string) ([]string, error) {
+	m, err := loadURLMap(filepath.Join(appDir, "url-map.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	callbacks, err := declaredCallbackPaths(appDir, distDir)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+	for _, rule := range m.HostRules {
+		host := "*"
+		if len(rule.Hosts) > 0 {
+			host = rule.Hosts[0]
+		}
+		for _, callback := range callbacks {
+			if _, ok := m.rewrite(host, callback); !ok {
+				problems = append(problems, fmt.Sprintf("callback %s has no rewrite for host %s (path matcher %s)", callback, host, rule.PathMatcher))
+			}
+		}
+	}
+
+	if distDir != "" {
+		for _, matcher := range m.PathMatchers {
+			for _, rule := range matcher.PathRules {
+				target := rule.RouteAction.URLRewrite.PathPrefixRewrite
+				if target == "" {
+					continue
+				}
+				rel := filepath.FromSlash(strings.TrimPrefix(target, "/"))
+				if _, err := os.Stat(filepath.Join(distDir, rel)); err != nil {
+					problems = append(problems, fmt.Sprintf("rewrite %s -> %s targets a path missing from the build output", strings.Join(rule.Paths, ","), target))
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+// declaredCallbackPaths collects the callback routes from the SPA route table
+// and the paths of any redirect URLs in the shipped app-configs.yaml.
+func declaredCallbackPaths(appDir, distDir string) ([]string, error) {
+	seen := map[string]bool{}
+	paths := []string{}
+	add := func(p string) {
+		if p != "" && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	routes, err := os.ReadFile(filepath.Join(appDir, filepath.FromSlash(appRoutesPath)))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, match := range callbackRoutePattern.FindAllSubmatch(routes, -1) {
+		add(string(match[1]))
+	}
+
+	// Prefer the app-configs.yaml that actually ships in the build output.
+	configPath := filepath.Join(appDir, "assets", filepath.FromSlash(appConfigsPath))
+	if distDir != "" {
+		if shipped := filepath.Join(distDir, filepath.FromSlash(appConfigsPath)); assertFile(shipped) == nil {
+			configPath = shipped
+		}
+	}
+	content, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	flat, err := flattenYAML(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", configPath, err)
+	}
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lower := strings.ToLower(key)
+		if !strings.Contains(lower, "redirecturl") && !strings.Contains(lower, "redirecturi") {
+			continue
+		}
+		if u, err := url.Parse(flat[key]); err == nil && u.Path != "" {
+			add(u.Path)
+		}
+	}
+	return paths, nil
+}
+
+// validateReleaseURLMap runs checkURLMap as part of a release. Web trees
+// without a url map are not hosted behind one and are skipped.
+func validateReleaseURLMap(appDir, distDir string) error {
+	if err := assertFile(filepath.Join(appDir, "url-map.yaml")); err != nil {
+		fmt.Printf("no url-map.yaml in %s; skipping url map checks\n", appDir)
+		return nil
+	}
+	problems, err := checkURLMap(appDir, distDir)
+	if err != nil {
+		return err
+	}
+	return reportURLMapProblems(problems)
+}
+
+func newCheckURLMapCmd() *cobra.Command {
+	var (
+		appDir  string
+		distDir string
+	)
+	cmd := &cobra.Command{
+		Use:   "check-url-map",
+		Short: "Check app/url-map.yaml against the app's callback routes and build output",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if distDir == "" {
+				distDir = filepath.Join(appDir, "dist")
+			}
+			if err := assertDir(distDir); err != nil {
+				fmt.Printf("no build output at %s; skipping rewrite target checks\n", distDir)
+				distDir = ""
+			}
+			problems, err := checkURLMap(appDir, distDir)
+			if err != nil {
+				return err
+			}
+			return reportURLMapProblems(problems)
+		},
+	}
+	cmd.Flags().StringVar(&appDir, "app", "../app", "web app directory containing url-map.yaml")
+	cmd.Flags().StringVar(&distDir, "dist", "", "build output to check rewrite targets against (defaults to <app>/dist)")
+	return cmd
+}
+
+func reportURLMapProblems(problems []string) error {
+	if len(problems) == 0 {
+		fmt.Println("url map ok")
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Printf("url map: %s\n", problem)
+	}
+	return fmt.Errorf("%d url map problem(s)", len(problems))
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestURLMapRewritePrefixRules(t *testing.T) {
+	t.Parallel()
+
+	m := urlMap{
+		HostRules: []urlMapHostRule{{Hosts: []string{"web.runme.dev"}, PathMatcher: "m"}},
+		PathMatchers: []urlMapMatcher{{
+			Name: "m",
+			PathRules: []urlMapPathRule{
+				pathRule("/static/*", "/assets/"),
+				pathRule("/static/app.js", "/index.html"),
+			},
+		}},
+	}
+	if got, ok := m.rewrite("localhost", "/static/logo.svg"); !ok || got != "/assets/logo.svg" {
+		t.Fatalf("prefix rewrite = %q, %v", got, ok)
+	}
+	if got, _ := m.rewrite("localhost", "/static/app.js"); got != "/index.html" {
+		t.Fatalf("exact match should win, got %q", got)
+	}
+	if got, ok := m.rewrite("localhost", "/static"); ok {
+		t.Fatalf("/static should not match /static/*, got %q", got)
+	}
+}
+
+func pathRule(path, rewrite string) urlMapPathRule {
+	rule := urlMapPathRule{Paths: []string{path}}
+	rule.RouteAction.URLRewrite.PathPrefixRewrite = rewrite
+	return rule
+}
+
+func TestCheckURLMap(t *testing.T) {
+	t.Parallel()
+
+	dist := writeDist(t, map[string]string{"index.html": "<html></html>"})
+	problems, err := checkURLMap("../app", dist)
+	if err != nil {
+		t.Fatalf("checkURLMap() error = %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("checkURLMap() on the repo url map = %v, want none", problems)
+	}
+
+	app := writeDist(t, map[string]string{
+		"url-map.yaml": `hostRules:
+- hosts: [web.runme.dev]
+  pathMatcher: m
+pathMatchers:
+- name: m
+  pathRules:
+  - paths: [/oidc/callback]
+    routeAction:
+      urlRewrite:
+        pathPrefixRewrite: /app.html
+`,
+		"src/lib/appBase.ts":              "export const APP_ROUTE_PATHS = {\n  oidcCallback: \"/oidc/callback\",\n  runs: \"/runs\",\n} as const;\n",
+		"assets/configs/app-configs.yaml": "oidc:\n  generic:\n    redirectURL: 'https://web.runme.dev/sso/callback'\n",
+	})
+	problems, err = checkURLMap(app, dist)
+	if err != nil {
+		t.Fatalf("checkURLMap() error = %v", err)
+	}
+	want := []string{
+		"callback /sso/callback has no rewrite for host web.runme.dev (path matcher m)",
+		"rewrite /oidc/callback -> /app.html targets a path missing from the build output",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("checkURLMap() = %v, want %v", problems, want)
+	}
+	for i := range want {
+		if problems[i] != want[i] {
+			t.Fatalf("problem[%d] = %q, want %q", i, problems[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	workspaceDirName = "releaser-work"
+	mirrorsDirName   = "mirrors"
+	worktreesDirName = "releases"
+)
+
+// workspace is the releaser's managed directory under --tmpdir. It keeps a
+// bare mirror per web repo, fetched incrementally, and checks each release
+// out into its own worktree of that mirror:
+//
+//	<tmpdir>/releaser-work/mirrors/<repo>-<hash>.git
+//	<tmpdir>/releaser-work/releases/web-<sha>-<time>
+type workspace struct {
+	root string
+}
+
+func openWorkspace(tmpBase string) workspace {
+	return workspace{root: filepath.Join(tmpBase, workspaceDirName)}
+}
+
+// releaseWorktree is a release checkout and the mirror it belongs to. The
+// zero value stands for a build that was not checked out by the releaser.
+type releaseWorktree struct {
+	dir    string
+	mirror string
+}
+
+// checkout updates the mirror of source and adds a detached worktree at sha.
+func (w workspace) checkout(ctx context.Context, source repoSource, sha string) (releaseWorktree, error) {
+	mirror, err := w.updateMirror(ctx, source, sha)
+	if err != nil {
+		return releaseWorktree{}, err
+	}
+	if err := os.MkdirAll(filepath.Join(w.root, worktreesDirName), 0o755); err != nil {
+		return releaseWorktree{}, fmt.Errorf("create worktree directory: %w", err)
+	}
+	dir := filepath.Join(w.root, worktreesDirName, fmt.Sprintf("web-%s-%s", shortSHA(sha, shortSHALen), time.Now().UTC().Format("20060102T150405Z")))
+	// A worktree of the same commit made within the same second, or left by
+	// a crashed run, is registered already; prune it before adding again.
+	if err := os.RemoveAll(dir); err != nil {
+		return releaseWorktree{}, fmt.Errorf("clean worktree: %w", err)
+	}
+	_ = runCmd(ctx, "", source.gitEnv, "git", "-C", mirror, "worktree", "prune")
+	if err := runCmd(ctx, "", source.gitEnv, "git", "-C", mirror, "worktree", "add", "--detach", dir, sha); err != nil {
+		return releaseWorktree{}, fmt.Errorf("add worktree: %w", err)
+	}
+	return releaseWorktree{dir: dir, mirror: mirror}, nil
+}
+
+// updateMirror clones a bare mirror of source on first use and fetches it
+// otherwise. Blobs are fetched on demand, so only the commits and trees of
+// new history are transferred. sha is fetched directly if no ref has it.
+func (w workspace) updateMirror(ctx context.Context, source repoSource, sha string) (string, error) {
+	mirror := filepath.Join(w.root, mirrorsDirName, mirrorName(source))
+	remote := source.cloneSource
+	if isLocalPath(remote) {
+		remote = "file://" + filepath.ToSlash(remote)
+	}
+
+	if _, err := os.Stat(filepath.Join(mirror, "HEAD")); err == nil {
+		if err := runCmd(ctx, "", source.gitEnv, "git", "-C", mirror, "fetch", "--prune", "origin"); err != nil {
+			return "", fmt.Errorf("fetch mirror: %w", err)
+		}
+	} else {
+		if err := os.RemoveAll(mirror); err != nil {
+			return "", fmt.Errorf("clean mirror: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(mirror), 0o755); err != nil {
+			return "", fmt.Errorf("create mirror directory: %w", err)
+		}
+		if err := runCmd(ctx, "", source.gitEnv, "git", "clone", "--mirror", "--filter=blob:none", remote, mirror); err != nil {
+			return "", fmt.Errorf("clone mirror: %w", err)
+		}
+	}
+
+	if runCmd(ctx, "", source.gitEnv, "git", "-C", mirror, "cat-file", "-e", sha+"^{commit}") != nil {
+		if err := runCmd(ctx, "", source.gitEnv, "git", "-C", mirror, "fetch", "origin", sha); err != nil {
+			return "", fmt.Errorf("fetch %s: %w", shortSHA(sha, shortSHALen), err)
+		}
+	}
+	return mirror, nil
+}
+
+var unsafeMirrorChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// mirrorName names the mirror of source after the repo, with a hash of the
+// clone source so forks and local paths with the same name do not collide.
+func mirrorName(source repoSource) string {
+	base := strings.TrimSuffix(filepath.Base(strings.TrimRight(filepath.ToSlash(source.cloneSource), "/")), ".git")
+	base = unsafeMirrorChars.ReplaceAllString(base, "-")
+	if base == "" || base == "." {
+		base = "web"
+	}
+	sum := sha256.Sum256([]byte(source.cloneSource))
+	return base + "-" + hex.EncodeToString(sum[:4]) + ".git"
+}
+
+// remove deletes the worktree and unregisters it from its mirror. Cleanup
+// never fails a release, so errors are reported as warnings.
+func (wt releaseWorktree) remove(ctx context.Context) {
+	if wt.dir == "" {
+		return
+	}
+	if err := os.RemoveAll(wt.dir); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: remove work directory %s: %v\n", wt.dir, err)
+		return
+	}
+	if err := runCmd(ctx, "", nil, "git", "-C", wt.mirror, "worktree", "prune"); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: prune worktrees of %s: %v\n", wt.mirror, err)
+	}
+	fmt.Printf("removed work directory %s\n", wt.dir)
+}
+
+// keep reports that the worktree stays on disk, for example to debug a
+// failed release.
+func (wt releaseWorktree) keep(why string) {
+	if wt.dir != "" {
+		fmt.Printf("keeping work directory %s %s; remove it with: releaser clean\n", wt.dir, why)
+	}
+}
+
+func newCleanCmd() *cobra.Command {
+	var (
+		tmpBase string
+		maxAge  time.Duration
+		maxSize string
+		dryRun  bool
+	)
+	cmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove old release worktrees and unused mirrors from --tmpdir",
+		Long: `Remove release worktrees kept for debugging or for "releaser apply".
+
+Worktrees older than --max-age are removed first. If the workspace is still
+larger than --max-size, the oldest remaining worktrees are removed until it
+fits. Mirrors with no worktrees left that were not fetched within --max-age
+are removed too.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			limit, err := parseByteSize(maxSize)
+			if err != nil {
+				return fmt.Errorf("--max-size: %w", err)
+			}
+			return cleanWorkspace(cmd.Context(), openWorkspace(tmpBase), maxAge, limit, dryRun, time.Now())
+		},
+	}
+	cmd.Flags().StringVar(&tmpBase, "tmpdir", os.TempDir(), "base temporary directory the releases were built in")
+	cmd.Flags().DurationVar(&maxAge, "max-age", 7*24*time.Hour, "remove worktrees and unused mirrors older than this")
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "remove the oldest worktrees until the workspace is under this size (for example 20GB or 500MiB)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print what would be removed without removing it")
+	return cmd
+}
+
+// workspaceEntry is a worktree or mirror with its last use and size.
+type workspaceEntry struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// cleanWorkspace applies the clean policy to w. A maxSize of zero means no
+// size limit.
+func cleanWorkspace(ctx context.Context, w workspace, maxAge time.Duration, maxSize int64, dryRun bool, now time.Time) error {
+	worktrees, err := listWorkspaceEntries(filepath.Join(w.root, worktreesDirName), nil)
+	if err != nil {
+		return err
+	}
+	mirrors, err := listWorkspaceEntries(filepath.Join(w.root, mirrorsDirName), mirrorLastFetch)
+	if err != nil {
+		return err
+	}
+	// Oldest first, so the size limit removes the least recent releases.
+	slices.SortFunc(worktrees, func(a, b workspaceEntry) int { return a.modTime.Compare(b.modTime) })
+
+	var total int64
+	for _, entry := range append(slices.Clone(worktrees), mirrors...) {
+		total += entry.size
+	}
+
+	var removed, freed int64
+	remove := func(entry workspaceEntry, why string) error {
+		verb := "removed"
+		if dryRun {
+			verb = "would remove"
+		} else if err := os.RemoveAll(entry.path); err != nil {
+			return fmt.Errorf("remove %s: %w", entry.path, err)
+		}
+		fmt.Printf("%s %s (%s, %s)\n", verb, entry.path, formatBytes(entry.size), why)
+		removed++
+		freed += entry.size
+		total -= entry.size
+		return nil
+	}
+
+	kept := worktrees[:0]
+	for _, entry := range worktrees {
+		if age := now.Sub(entry.modTime); age > maxAge {
+			if err := remove(entry, "last used "+age.Round(time.Minute).String()+" ago"); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	for len(kept) > 0 && maxSize > 0 && total > maxSize {
+		if err := remove(kept[0], "workspace over "+formatBytes(maxSize)); err != nil {
+			return err
+		}
+		kept = kept[1:]
+	}
+
+	for _, mirror := range mirrors {
+		if !dryRun {
+			if err := runCmd(ctx, "", nil, "git", "-C", mirror.path, "worktree", "prune"); err != nil {
+				return fmt.Errorf("prune worktrees of %s: %w", mirror.path, err)
+			}
+		}
+		if age := now.Sub(mirror.modTime); age > maxAge && !mirrorHasWorktrees(mirror.path, kept) {
+			if err := remove(mirror, "unused, last fetched "+age.Round(time.Minute).String()+" ago"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("dry-run: would remove %d entries, freeing %s\n", removed, formatBytes(freed))
+	} else {
+		fmt.Printf("removed %d entries, freed %s; %s left in %s\n", removed, formatBytes(freed), formatBytes(total), w.root)
+	}
+	return nil
+}
+
+// listWorkspaceEntries lists the directories in dir with their sizes. The
+// last use is the directory's modification time unless lastUse says
+// otherwise.
+func listWorkspaceEntries(dir string, lastUse func(string) (time.Time, bool)) ([]workspaceEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []workspaceEntry
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, dirEntry.Name())
+		size, err := dirSize(path)
+		if err != nil {
+			return nil, err
+		}
+		entry := workspaceEntry{path: path, modTime: info.ModTime(), size: size}
+		if lastUse != nil {
+			if t, ok := lastUse(path); ok {
+				entry.modTime = t
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// mirrorLastFetch is when the mirror was last fetched, from FETCH_HEAD.
+func mirrorLastFetch(mirror string) (time.Time, bool) {
+	info, err := os.Stat(filepath.Join(mirror, "FETCH_HEAD"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// mirrorHasWorktrees reports whether any of worktrees belongs to mirror,
+// going by the gitdir recorded in the worktree's .git file.
+func mirrorHasWorktrees(mirror string, worktrees []workspaceEntry) bool {
+	for _, wt := range worktrees {
+		content, err := os.ReadFile(filepath.Join(wt.path, ".git"))
+		if err != nil {
+			continue
+		}
+		gitDir := strings.TrimSpace(strings.TrimPrefix(string(content), "gitdir:"))
+		if strings.HasPrefix(filepath.Clean(gitDir), filepath.Clean(mirror)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+var byteSizeUnits = map[string]int64{
+	"": 1, "b": 1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+}
+
+// parseByteSize parses sizes like 500MiB, 20GB, or 1048576. An empty value
+// is zero, meaning no limit.
+func parseByteSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	split := strings.IndexFunc(value, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if split < 0 {
+		split = len(value)
+	}
+	number, unit := value[:split], strings.ToLower(strings.TrimSpace(value[split:]))
+	multiplier, ok := byteSizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit %q in %q", unit, value)
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkspaceCheckoutReusesMirror(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Dev", "GIT_AUTHOR_EMAIL=dev@example.com",
+			"GIT_COMMITTER_NAME=Dev", "GIT_COMMITTER_EMAIL=dev@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(content string) string {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, "index.html"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "index.html")
+		git("commit", "-q", "-m", content)
+		return git("rev-parse", "HEAD")
+	}
+	git("init", "-q", "-b", "main")
+	first := commit("v1")
+
+	ctx := context.Background()
+	w := openWorkspace(t.TempDir())
+	source := repoSource{identity: repo, cloneSource: repo}
+	wt1, err := w.checkout(ctx, source, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second release fetches into the same mirror.
+	second := commit("v2")
+	wt2, err := w.checkout(ctx, source, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wt1.mirror != wt2.mirror || wt1.dir == wt2.dir {
+		t.Fatalf("worktrees = %+v, %+v", wt1, wt2)
+	}
+	for dir, want := range map[string]string{wt1.dir: "v1", wt2.dir: "v2"} {
+		content, err := os.ReadFile(filepath.Join(dir, "index.html"))
+		if err != nil || string(content) != want {
+			t.Fatalf("%s: index.html = %q, %v", dir, content, err)
+		}
+	}
+
+	wt1.remove(ctx)
+	if _, err := os.Stat(wt1.dir); !os.IsNotExist(err) {
+		t.Fatalf("removed worktree still exists: %v", err)
+	}
+	if out := git("-C", wt2.mirror, "worktree", "list"); strings.Contains(out, wt1.dir) || !strings.Contains(out, wt2.dir) {
+		t.Fatalf("worktree list after remove:\n%s", out)
+	}
+}
+
+func TestCleanWorkspace(t *testing.T) {
+	w := openWorkspace(t.TempDir())
+	now := time.Now()
+	worktree := func(name string, size int, age time.Duration) string {
+		t.Helper()
+		dir := filepath.Join(w.root, worktreesDirName, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "bundle.js"), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(dir, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	stale := worktree("web-11111111-a", 100, 10*24*time.Hour)
+	older := worktree("web-22222222-b", 300, 2*time.Hour)
+	newer := worktree("web-33333333-c", 300, time.Hour)
+
+	// A dry run removes nothing.
+	if err := cleanWorkspace(context.Background(), w, 7*24*time.Hour, 400, true, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("dry run removed %s: %v", stale, err)
+	}
+
+	if err := cleanWorkspace(context.Background(), w, 7*24*time.Hour, 400, false, now); err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]bool{stale: false, older: false, newer: true} {
+		if _, err := os.Stat(dir); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", filepath.Base(dir), err == nil, want)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for value, want := range map[string]int64{
+		"":       0,
+		"1024":   1024,
+		"500MiB": 500 << 20,
+		"20GB":   20e9,
+		"1.5G":   3 << 29,
+		"2 kb":   2000,
+	} {
+		if got, err := parseByteSize(value); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"10 parsecs", "-1GB", "GB"} {
+		if _, err := parseByteSize(value); err == nil {
+			t.Errorf("parseByteSize(%q) accepted", value)
+		}
+	}
+}
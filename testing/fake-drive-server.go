@@ -1,17 +1,25 @@
 package main
 
 import (
+	"cmp"
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -20,8 +28,24 @@ const (
 	seedFolderID     = "shared-folder-123"
 	seedFileID       = "shared-file-123"
 	seedFileName     = "shared-drive-notebook.json"
+	seedSharedDrive  = "fake-shared-drive-1"
 	driveFolderMime  = "application/vnd.google-apps.folder"
 	notebookJSONMime = "application/json"
+	driveTimeFormat  = "2006-01-02T15:04:05.000Z"
+	defaultUserEmail = "fake-drive-user@example.com"
+	seedOwnerEmail   = "shared-drive-owner@example.com"
+
+	defaultRevisionPageSize = 200
+	maxRevisionPageSize     = 1000
+	defaultCommentPageSize  = 20
+	maxCommentPageSize      = 100
+	defaultChangePageSize   = 100
+	maxChangePageSize       = 1000
+	defaultFilePageSize     = 100
+	maxFilePageSize         = 1000
+
+	defaultChannelLifetime = time.Hour
+	channelQueueSize       = 256
 )
 
 type driveFile struct {
@@ -30,42 +54,229 @@ type driveFile struct {
 	MimeType      string            `json:"mimeType"`
 	Parents       []string          `json:"parents,omitempty"`
 	AppProperties map[string]string `json:"appProperties,omitempty"`
+	Properties    map[string]string `json:"properties,omitempty"`
 	Content       string            `json:"-"`
 	Version       int               `json:"version"`
-	HeadRev       string            `json:"headRevisionId"`
+	HeadRev       string            `json:"headRevisionId,omitempty"`
 	MD5Checksum   string            `json:"md5Checksum,omitempty"`
+	Trashed       bool              `json:"trashed"`
+	Starred       bool              `json:"starred"`
+	DriveID       string            `json:"driveId,omitempty"`
+	CreatedTime   string            `json:"createdTime"`
+	ModifiedTime  string            `json:"modifiedTime"`
+	// owner is the email of the user who created the file. Files in a
+	// shared drive have one too, though Drive reports none.
+	owner string
+	// seq is the creation order, the order files are listed in when
+	// nothing else tells them apart.
+	seq int
+}
+
+type driveUser struct {
+	Kind         string `json:"kind"`
+	DisplayName  string `json:"displayName"`
+	EmailAddress string `json:"emailAddress,omitempty"`
+	Me           bool   `json:"me"`
+}
+
+// driveRevision is one version of a file's content. Every content write
+// adds one; metadata updates do not.
+type driveRevision struct {
+	Kind              string     `json:"kind"`
+	ID                string     `json:"id"`
+	MimeType          string     `json:"mimeType"`
+	ModifiedTime      string     `json:"modifiedTime"`
+	MD5Checksum       string     `json:"md5Checksum"`
+	Size              string     `json:"size"`
+	KeepForever       bool       `json:"keepForever"`
+	LastModifyingUser *driveUser `json:"lastModifyingUser,omitempty"`
+	Content           string     `json:"-"`
+}
+
+type driveComment struct {
+	Kind              string             `json:"kind"`
+	ID                string             `json:"id"`
+	CreatedTime       string             `json:"createdTime"`
+	ModifiedTime      string             `json:"modifiedTime"`
+	Author            *driveUser         `json:"author"`
+	HTMLContent       string             `json:"htmlContent,omitempty"`
+	Content           string             `json:"content,omitempty"`
+	Deleted           bool               `json:"deleted"`
+	Resolved          bool               `json:"resolved"`
+	Anchor            string             `json:"anchor,omitempty"`
+	QuotedFileContent *quotedFileContent `json:"quotedFileContent,omitempty"`
+	Replies           []*driveReply      `json:"replies"`
+}
+
+type quotedFileContent struct {
+	MimeType string `json:"mimeType"`
+	Value    string `json:"value"`
+}
+
+// driveReply is a reply to a comment. A reply with action "resolve" or
+// "reopen" changes the comment's resolved state and may have no content.
+type driveReply struct {
+	Kind         string     `json:"kind"`
+	ID           string     `json:"id"`
+	CreatedTime  string     `json:"createdTime"`
+	ModifiedTime string     `json:"modifiedTime"`
+	Action       string     `json:"action,omitempty"`
+	Author       *driveUser `json:"author"`
+	HTMLContent  string     `json:"htmlContent,omitempty"`
+	Content      string     `json:"content,omitempty"`
+	Deleted      bool       `json:"deleted"`
+}
+
+// driveChange is an entry of the changes feed. File is the file as the
+// change left it; it is nil when the file was deleted.
+type driveChange struct {
+	Kind       string     `json:"kind"`
+	ChangeType string     `json:"changeType"`
+	Time       string     `json:"time"`
+	Removed    bool       `json:"removed"`
+	FileID     string     `json:"fileId"`
+	File       *driveFile `json:"file,omitempty"`
+	// driveID is the shared drive the file is in, kept for filtering after
+	// the file is gone.
+	driveID string
+}
+
+// changeFilter selects the changes a changes.list or changes.watch caller
+// sees.
+type changeFilter struct {
+	driveID           string
+	includeRemoved    bool
+	restrictToMyDrive bool
+	allDrives         bool
+}
+
+// changeChannel is a changes.watch subscription. Notifications are queued
+// under the store lock and posted in order by the channel's own goroutine.
+type changeChannel struct {
+	ID          string
+	ResourceID  string
+	ResourceURI string
+	Token       string
+	Address     string
+	Expiration  time.Time
+	filter      changeFilter
+	messages    int
+	queue       chan channelNotification
+}
+
+type channelNotification struct {
+	state  string
+	number int
+}
+
+// driveAPIError is an error the handler reports in Drive's JSON error shape.
+type driveAPIError struct {
+	Code    int
+	Reason  string
+	Message string
+	// Location names the request parameter at fault, if any.
+	Location string
+}
+
+func (e *driveAPIError) Error() string {
+	return e.Message
+}
+
+func fileNotFound(id string) error {
+	return &driveAPIError{Code: http.StatusNotFound, Reason: "notFound", Message: "File not found: " + id + "."}
+}
+
+func revisionNotFound(id string) error {
+	return &driveAPIError{Code: http.StatusNotFound, Reason: "notFound", Message: "Revision not found: " + id + "."}
+}
+
+func commentNotFound(id string) error {
+	return &driveAPIError{Code: http.StatusNotFound, Reason: "notFound", Message: "Comment not found: " + id + "."}
+}
+
+func replyNotFound(id string) error {
+	return &driveAPIError{Code: http.StatusNotFound, Reason: "notFound", Message: "Reply not found: " + id + "."}
+}
+
+func notAuthor(user driveUser) error {
+	return &driveAPIError{Code: http.StatusForbidden, Reason: "insufficientPermissions", Message: "The user " + user.EmailAddress + " is not the author and cannot change it."}
+}
+
+func requiredParameter(name string) error {
+	return &driveAPIError{Code: http.StatusBadRequest, Reason: "required", Message: "Required parameter: " + name}
+}
+
+func sharedDriveNotFound(id string) error {
+	return &driveAPIError{Code: http.StatusNotFound, Reason: "notFound", Message: "Shared drive not found: " + id}
+}
+
+func invalidParameter(name, value string) error {
+	return &driveAPIError{Code: http.StatusBadRequest, Reason: "invalid", Message: fmt.Sprintf("Invalid value for %s: %s", name, value), Location: name}
 }
 
 type driveStore struct {
-	mu      sync.Mutex
-	files   map[string]*driveFile
-	counter int
+	mu        sync.Mutex
+	files     map[string]*driveFile
+	revisions map[string][]*driveRevision
+	// lastRevision numbers each file's revisions; it never goes down, so
+	// deleted revision IDs are not reused.
+	lastRevision map[string]int
+	// created numbers files in creation order.
+	created int
+	// comments holds each file's comments in creation order, with their
+	// replies.
+	comments       map[string][]*driveComment
+	commentCounter int
+	// changes is the changes feed; a page token is the 1-based position of
+	// the first change it reads.
+	changes  []*driveChange
+	channels map[string]*changeChannel
+	// sharedDrives are the shared drives by ID. A shared drive's ID is also
+	// the ID of its root folder.
+	sharedDrives map[string]string
+	counter      int
+	now          func() time.Time
 }
 
 func newDriveStore() *driveStore {
 	store := &driveStore{
-		files:   map[string]*driveFile{},
-		counter: 1,
+		files:        map[string]*driveFile{},
+		revisions:    map[string][]*driveRevision{},
+		lastRevision: map[string]int{},
+		comments:     map[string][]*driveComment{},
+		channels:     map[string]*changeChannel{},
+		sharedDrives: map[string]string{seedSharedDrive: "Fake Shared Drive"},
+		counter:      1,
+		now:          time.Now,
 	}
 
+	created := store.now().UTC().Format(driveTimeFormat)
 	store.files[seedFolderID] = &driveFile{
-		ID:       seedFolderID,
-		Name:     "Shared Drive Folder",
-		MimeType: driveFolderMime,
-		Version:  1,
-		HeadRev:  "rev-1",
+		ID:           seedFolderID,
+		Name:         "Shared Drive Folder",
+		MimeType:     driveFolderMime,
+		Version:      1,
+		CreatedTime:  created,
+		ModifiedTime: created,
+		owner:        seedOwnerEmail,
+		seq:          1,
 	}
 
 	store.files[seedFileID] = &driveFile{
-		ID:       seedFileID,
-		Name:     seedFileName,
-		MimeType: notebookJSONMime,
-		Parents:  []string{seedFolderID},
-		Content:  `{"cells":[{"refId":"cell_shared_drive","kind":"CODE","languageId":"bash","value":"echo \"shared drive\"","metadata":{"runner":"default"},"outputs":[]}],"metadata":{}}`,
-		Version:  1,
-		HeadRev:  "rev-1",
+		ID:           seedFileID,
+		Name:         seedFileName,
+		MimeType:     notebookJSONMime,
+		Parents:      []string{seedFolderID},
+		Content:      `{"cells":[{"refId":"cell_shared_drive","kind":"CODE","languageId":"bash","value":"echo \"shared drive\"","metadata":{"runner":"default"},"outputs":[]}],"metadata":{}}`,
+		Version:      1,
+		CreatedTime:  created,
+		ModifiedTime: created,
+		owner:        seedOwnerEmail,
+		seq:          2,
 	}
+	store.created = 2
 	store.refreshChecksum(seedFileID)
+	store.addRevisionLocked(store.files[seedFileID], newDriveUser(seedOwnerEmail, "Shared Drive Owner"))
 
 	return store
 }
@@ -77,7 +288,27 @@ func (s *driveStore) refreshChecksum(id string) {
 	}
 	sum := md5.Sum([]byte(file.Content))
 	file.MD5Checksum = hex.EncodeToString(sum[:])
-	file.HeadRev = fmt.Sprintf("rev-%d", file.Version)
+}
+
+// addRevisionLocked records the file's current content as its new head
+// revision. Folders have no content and so no revisions.
+func (s *driveStore) addRevisionLocked(file *driveFile, user driveUser) {
+	if file.MimeType == driveFolderMime {
+		return
+	}
+	s.lastRevision[file.ID]++
+	revision := &driveRevision{
+		Kind:              "drive#revision",
+		ID:                fmt.Sprintf("rev-%d", s.lastRevision[file.ID]),
+		MimeType:          file.MimeType,
+		ModifiedTime:      s.now().UTC().Format(driveTimeFormat),
+		MD5Checksum:       file.MD5Checksum,
+		Size:              strconv.Itoa(len(file.Content)),
+		LastModifyingUser: &user,
+		Content:           file.Content,
+	}
+	s.revisions[file.ID] = append(s.revisions[file.ID], revision)
+	file.HeadRev = revision.ID
 }
 
 func (s *driveStore) nextIDLocked() string {
@@ -92,7 +323,7 @@ func (s *driveStore) generateID() string {
 	return s.nextIDLocked()
 }
 
-func (s *driveStore) create(resource map[string]any) (*driveFile, bool) {
+func (s *driveStore) create(resource map[string]any, user driveUser) (*driveFile, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -110,11 +341,20 @@ func (s *driveStore) create(resource map[string]any) (*driveFile, bool) {
 		MimeType:      stringValue(resource["mimeType"], notebookJSONMime),
 		Parents:       stringSlice(resource["parents"]),
 		AppProperties: stringMap(resource["appProperties"]),
+		Properties:    stringMap(resource["properties"]),
+		Starred:       resource["starred"] == true,
 		Version:       1,
-		HeadRev:       "rev-1",
+		CreatedTime:   s.now().UTC().Format(driveTimeFormat),
+		owner:         user.EmailAddress,
 	}
+	s.created++
+	file.seq = s.created
+	file.ModifiedTime = file.CreatedTime
+	file.DriveID = s.parentDriveLocked(file.Parents)
 	s.files[id] = file
 	s.refreshChecksum(id)
+	s.addRevisionLocked(file, user)
+	s.recordChangeLocked(id)
 	return cloneFile(file), true
 }
 
@@ -139,6 +379,15 @@ func (s *driveStore) updateMetadata(id string, resource map[string]any, addParen
 	if appProperties, ok := resource["appProperties"]; ok {
 		file.AppProperties = stringMap(appProperties)
 	}
+	if properties, ok := resource["properties"]; ok {
+		file.Properties = stringMap(properties)
+	}
+	if trashed, ok := resource["trashed"].(bool); ok {
+		file.Trashed = trashed
+	}
+	if starred, ok := resource["starred"].(bool); ok {
+		file.Starred = starred
+	}
 	if addParents != "" && !containsString(file.Parents, addParents) {
 		file.Parents = append(file.Parents, addParents)
 	}
@@ -147,12 +396,15 @@ func (s *driveStore) updateMetadata(id string, resource map[string]any, addParen
 			return value != removeParents
 		})
 	}
+	file.DriveID = s.parentDriveLocked(file.Parents)
 	file.Version++
+	file.ModifiedTime = s.now().UTC().Format(driveTimeFormat)
 	s.refreshChecksum(id)
+	s.recordChangeLocked(id)
 	return cloneFile(file), true
 }
 
-func (s *driveStore) setContentIfMatch(id, content, expectedETag string) (*driveFile, bool, bool) {
+func (s *driveStore) setContentIfMatch(id, content, expectedETag string, user driveUser) (*driveFile, bool, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -165,10 +417,43 @@ func (s *driveStore) setContentIfMatch(id, content, expectedETag string) (*drive
 	}
 	file.Content = content
 	file.Version++
+	file.ModifiedTime = s.now().UTC().Format(driveTimeFormat)
 	s.refreshChecksum(id)
+	s.addRevisionLocked(file, user)
+	s.recordChangeLocked(id)
 	return cloneFile(file), true, true
 }
 
+// delete permanently removes a file with its revisions and comments.
+func (s *driveStore) delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file := s.files[id]
+	if file == nil {
+		return false
+	}
+	delete(s.files, id)
+	delete(s.revisions, id)
+	delete(s.comments, id)
+	s.appendChangeLocked(&driveChange{FileID: id, Removed: true, driveID: file.DriveID})
+	return true
+}
+
+// parentDriveLocked returns the shared drive that parents are in, or ""
+// for My Drive.
+func (s *driveStore) parentDriveLocked(parents []string) string {
+	for _, parent := range parents {
+		if _, ok := s.sharedDrives[parent]; ok {
+			return parent
+		}
+		if folder := s.files[parent]; folder != nil && folder.DriveID != "" {
+			return folder.DriveID
+		}
+	}
+	return ""
+}
+
 func driveFileETag(file *driveFile) string {
 	return fmt.Sprintf("\"version-%d\"", file.Version)
 }
@@ -183,20 +468,543 @@ func (s *driveStore) get(id string) (*driveFile, bool) {
 	return cloneFile(file), true
 }
 
-func (s *driveStore) list(parentID, propertyKey, propertyValue string) []*driveFile {
+// list returns the files that query matches for viewer, sorted by order.
+func (s *driveStore) list(query driveQuery, viewer string, order []fileOrder) []*driveFile {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	files := make([]*driveFile, 0)
 	for _, file := range s.files {
-		if containsString(file.Parents, parentID) &&
-			(propertyKey == "" || file.AppProperties[propertyKey] == propertyValue) {
+		if query(file, viewer) {
 			files = append(files, cloneFile(file))
 		}
 	}
+	sortFiles(files, order)
 	return files
 }
 
+func (s *driveStore) listRevisions(fileID string) ([]*driveRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.files[fileID] == nil {
+		return nil, fileNotFound(fileID)
+	}
+	revisions := make([]*driveRevision, 0, len(s.revisions[fileID]))
+	for _, revision := range s.revisions[fileID] {
+		revisions = append(revisions, cloneRevision(revision))
+	}
+	return revisions, nil
+}
+
+func (s *driveStore) getRevision(fileID, revisionID string) (*driveRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.findRevisionLocked(fileID, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	return cloneRevision(s.revisions[fileID][index]), nil
+}
+
+// updateRevision applies the writable revision fields in resource; the fake
+// only models keepForever.
+func (s *driveStore) updateRevision(fileID, revisionID string, resource map[string]any) (*driveRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.findRevisionLocked(fileID, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	revision := s.revisions[fileID][index]
+	if keepForever, ok := resource["keepForever"].(bool); ok {
+		revision.KeepForever = keepForever
+	}
+	return cloneRevision(revision), nil
+}
+
+// deleteRevision removes a revision. Like Drive, it refuses to delete the
+// only one left; deleting the head makes the previous revision the file's
+// content again.
+func (s *driveStore) deleteRevision(fileID, revisionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.findRevisionLocked(fileID, revisionID)
+	if err != nil {
+		return err
+	}
+	revisions := s.revisions[fileID]
+	if len(revisions) == 1 {
+		return &driveAPIError{Code: http.StatusBadRequest, Reason: "cannotDeleteOnlyRevision", Message: "The revision cannot be deleted because it is the only revision of the file."}
+	}
+	s.revisions[fileID] = append(revisions[:index:index], revisions[index+1:]...)
+	if index == len(revisions)-1 {
+		file := s.files[fileID]
+		head := s.revisions[fileID][len(s.revisions[fileID])-1]
+		file.Content = head.Content
+		file.HeadRev = head.ID
+		file.Version++
+		file.ModifiedTime = s.now().UTC().Format(driveTimeFormat)
+		s.refreshChecksum(fileID)
+		s.recordChangeLocked(fileID)
+	}
+	return nil
+}
+
+func (s *driveStore) findRevisionLocked(fileID, revisionID string) (int, error) {
+	if s.files[fileID] == nil {
+		return 0, fileNotFound(fileID)
+	}
+	for index, revision := range s.revisions[fileID] {
+		if revision.ID == revisionID {
+			return index, nil
+		}
+	}
+	return 0, revisionNotFound(revisionID)
+}
+
+func cloneRevision(revision *driveRevision) *driveRevision {
+	clone := *revision
+	if revision.LastModifyingUser != nil {
+		user := *revision.LastModifyingUser
+		clone.LastModifyingUser = &user
+	}
+	return &clone
+}
+
+func (s *driveStore) createComment(fileID string, resource map[string]any, user driveUser) (*driveComment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.files[fileID] == nil {
+		return nil, fileNotFound(fileID)
+	}
+	content := stringValue(resource["content"], "")
+	if content == "" {
+		return nil, requiredParameter("content")
+	}
+	now := s.now().UTC().Format(driveTimeFormat)
+	s.commentCounter++
+	comment := &driveComment{
+		Kind:         "drive#comment",
+		ID:           fmt.Sprintf("comment-%d", s.commentCounter),
+		CreatedTime:  now,
+		ModifiedTime: now,
+		Author:       &user,
+		Content:      content,
+		HTMLContent:  commentHTML(content),
+		Anchor:       stringValue(resource["anchor"], ""),
+		Replies:      []*driveReply{},
+	}
+	if quoted, ok := resource["quotedFileContent"].(map[string]any); ok {
+		comment.QuotedFileContent = &quotedFileContent{
+			MimeType: stringValue(quoted["mimeType"], "text/plain"),
+			Value:    stringValue(quoted["value"], ""),
+		}
+	}
+	s.comments[fileID] = append(s.comments[fileID], comment)
+	return cloneComment(comment, true), nil
+}
+
+// listComments returns the file's comments in creation order, modified at
+// or after since when it is set. Deleted comments and replies are only
+// included with includeDeleted.
+func (s *driveStore) listComments(fileID string, includeDeleted bool, since time.Time) ([]*driveComment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.files[fileID] == nil {
+		return nil, fileNotFound(fileID)
+	}
+	comments := make([]*driveComment, 0, len(s.comments[fileID]))
+	for _, comment := range s.comments[fileID] {
+		if comment.Deleted && !includeDeleted {
+			continue
+		}
+		if modified, err := time.Parse(time.RFC3339, comment.ModifiedTime); err == nil && modified.Before(since) {
+			continue
+		}
+		comments = append(comments, cloneComment(comment, includeDeleted))
+	}
+	return comments, nil
+}
+
+func (s *driveStore) getComment(fileID, commentID string, includeDeleted bool) (*driveComment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, err := s.findCommentLocked(fileID, commentID, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	return cloneComment(comment, includeDeleted), nil
+}
+
+// updateComment changes the content of a comment; only its author may.
+func (s *driveStore) updateComment(fileID, commentID string, resource map[string]any, user driveUser) (*driveComment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, err := s.findCommentLocked(fileID, commentID, false)
+	if err != nil {
+		return nil, err
+	}
+	if comment.Author.EmailAddress != user.EmailAddress {
+		return nil, notAuthor(user)
+	}
+	content := stringValue(resource["content"], "")
+	if content == "" {
+		return nil, requiredParameter("content")
+	}
+	comment.Content = content
+	comment.HTMLContent = commentHTML(content)
+	comment.ModifiedTime = s.now().UTC().Format(driveTimeFormat)
+	return cloneComment(comment, false), nil
+}
+
+// deleteComment marks a comment deleted and drops its content, as Drive
+// does; only its author may delete it.
+func (s *driveStore) deleteComment(fileID, commentID string, user driveUser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, err := s.findCommentLocked(fileID, commentID, false)
+	if err != nil {
+		return err
+	}
+	if comment.Author.EmailAddress != user.EmailAddress {
+		return notAuthor(user)
+	}
+	comment.Deleted = true
+	comment.Content = ""
+	comment.HTMLContent = ""
+	comment.QuotedFileContent = nil
+	comment.ModifiedTime = s.now().UTC().Format(driveTimeFormat)
+	return nil
+}
+
+func (s *driveStore) createReply(fileID, commentID string, resource map[string]any, user driveUser) (*driveReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, err := s.findCommentLocked(fileID, commentID, false)
+	if err != nil {
+		return nil, err
+	}
+	action := stringValue(resource["action"], "")
+	content := stringValue(resource["content"], "")
+	switch action {
+	case "":
+		if content == "" {
+			return nil, requiredParameter("content")
+		}
+	case "resolve":
+		comment.Resolved = true
+	case "reopen":
+		comment.Resolved = false
+	default:
+		return nil, invalidParameter("action", action)
+	}
+	now := s.now().UTC().Format(driveTimeFormat)
+	s.commentCounter++
+	reply := &driveReply{
+		Kind:         "drive#reply",
+		ID:           fmt.Sprintf("reply-%d", s.commentCounter),
+		CreatedTime:  now,
+		ModifiedTime: now,
+		Action:       action,
+		Author:       &user,
+		Content:      content,
+		HTMLContent:  commentHTML(content),
+	}
+	comment.Replies = append(comment.Replies, reply)
+	comment.ModifiedTime = now
+	return cloneReply(reply), nil
+}
+
+func (s *driveStore) listReplies(fileID, commentID string, includeDeleted bool) ([]*driveReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, err := s.findCommentLocked(fileID, commentID, false)
+	if err != nil {
+		return nil, err
+	}
+	return cloneComment(comment, includeDeleted).Replies, nil
+}
+
+func (s *driveStore) getReply(fileID, commentID, replyID string, includeDeleted bool) (*driveReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reply, err := s.findReplyLocked(fileID, commentID, replyID, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	return cloneReply(reply), nil
+}
+
+func (s *driveStore) updateReply(fileID, commentID, replyID string, resource map[string]any, user driveUser) (*driveReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reply, err := s.findReplyLocked(fileID, commentID, replyID, false)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Author.EmailAddress != user.EmailAddress {
+		return nil, notAuthor(user)
+	}
+	content := stringValue(resource["content"], "")
+	if content == "" {
+		return nil, requiredParameter("content")
+	}
+	reply.Content = content
+	reply.HTMLContent = commentHTML(content)
+	reply.ModifiedTime = s.now().UTC().Format(driveTimeFormat)
+	return cloneReply(reply), nil
+}
+
+func (s *driveStore) deleteReply(fileID, commentID, replyID string, user driveUser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reply, err := s.findReplyLocked(fileID, commentID, replyID, false)
+	if err != nil {
+		return err
+	}
+	if reply.Author.EmailAddress != user.EmailAddress {
+		return notAuthor(user)
+	}
+	reply.Deleted = true
+	reply.Content = ""
+	reply.HTMLContent = ""
+	reply.ModifiedTime = s.now().UTC().Format(driveTimeFormat)
+	return nil
+}
+
+func (s *driveStore) findCommentLocked(fileID, commentID string, includeDeleted bool) (*driveComment, error) {
+	if s.files[fileID] == nil {
+		return nil, fileNotFound(fileID)
+	}
+	for _, comment := range s.comments[fileID] {
+		if comment.ID == commentID && (includeDeleted || !comment.Deleted) {
+			return comment, nil
+		}
+	}
+	return nil, commentNotFound(commentID)
+}
+
+func (s *driveStore) findReplyLocked(fileID, commentID, replyID string, includeDeleted bool) (*driveReply, error) {
+	comment, err := s.findCommentLocked(fileID, commentID, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, reply := range comment.Replies {
+		if reply.ID == replyID && (includeDeleted || !reply.Deleted) {
+			return reply, nil
+		}
+	}
+	return nil, replyNotFound(replyID)
+}
+
+// commentHTML renders plain comment content the way Drive does for
+// htmlContent: escaped, with line breaks kept.
+func commentHTML(content string) string {
+	return strings.ReplaceAll(html.EscapeString(content), "\n", "<br>")
+}
+
+func cloneComment(comment *driveComment, includeDeleted bool) *driveComment {
+	clone := *comment
+	author := *comment.Author
+	clone.Author = &author
+	if comment.QuotedFileContent != nil {
+		quoted := *comment.QuotedFileContent
+		clone.QuotedFileContent = &quoted
+	}
+	clone.Replies = make([]*driveReply, 0, len(comment.Replies))
+	for _, reply := range comment.Replies {
+		if includeDeleted || !reply.Deleted {
+			clone.Replies = append(clone.Replies, cloneReply(reply))
+		}
+	}
+	return &clone
+}
+
+func cloneReply(reply *driveReply) *driveReply {
+	clone := *reply
+	author := *reply.Author
+	clone.Author = &author
+	return &clone
+}
+
+// recordChangeLocked appends the current state of file id to the changes
+// feed.
+func (s *driveStore) recordChangeLocked(id string) {
+	file := s.files[id]
+	s.appendChangeLocked(&driveChange{FileID: id, File: cloneFile(file), driveID: file.DriveID})
+}
+
+// appendChangeLocked adds change to the feed and queues a notification on
+// every live channel that sees it.
+func (s *driveStore) appendChangeLocked(change *driveChange) {
+	now := s.now()
+	change.Kind = "drive#change"
+	change.ChangeType = "file"
+	change.Time = now.UTC().Format(driveTimeFormat)
+	s.changes = append(s.changes, change)
+	for id, channel := range s.channels {
+		if now.After(channel.Expiration) {
+			close(channel.queue)
+			delete(s.channels, id)
+			continue
+		}
+		if channel.filter.matches(change) {
+			s.notifyLocked(channel, "change")
+		}
+	}
+}
+
+func (s *driveStore) notifyLocked(channel *changeChannel, state string) {
+	channel.messages++
+	select {
+	case channel.queue <- channelNotification{state: state, number: channel.messages}:
+	default:
+		log.Printf("[fake-drive] channel %s is backed up; dropped notification %d", channel.ID, channel.messages)
+	}
+}
+
+func (s *driveStore) startPageToken(driveID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sharedDrives[driveID]; driveID != "" && !ok {
+		return "", sharedDriveNotFound(driveID)
+	}
+	return strconv.Itoa(len(s.changes) + 1), nil
+}
+
+// listChanges returns up to pageSize changes that filter selects, starting
+// at token. It returns the token of the next page if there are more
+// changes, and otherwise the start token for future changes.
+func (s *driveStore) listChanges(token string, filter changeFilter, pageSize int) (changes []*driveChange, nextPageToken, newStartPageToken string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start, err := s.parseChangeTokenLocked(token)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if _, ok := s.sharedDrives[filter.driveID]; filter.driveID != "" && !ok {
+		return nil, "", "", sharedDriveNotFound(filter.driveID)
+	}
+	changes = []*driveChange{}
+	position := start
+	for ; position <= len(s.changes) && len(changes) < pageSize; position++ {
+		change := s.changes[position-1]
+		if filter.matches(change) {
+			clone := *change
+			clone.File = cloneFile(change.File)
+			changes = append(changes, &clone)
+		}
+	}
+	if position <= len(s.changes) {
+		return changes, strconv.Itoa(position), "", nil
+	}
+	return changes, "", strconv.Itoa(position), nil
+}
+
+// watchChanges opens a channel that posts to channel.Address whenever a
+// change that filter selects is recorded at or after token. Like Drive, it
+// first posts a "sync" message.
+func (s *driveStore) watchChanges(token string, filter changeFilter, channel *changeChannel) (*changeChannel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.parseChangeTokenLocked(token); err != nil {
+		return nil, err
+	}
+	if _, exists := s.channels[channel.ID]; exists {
+		return nil, &driveAPIError{Code: http.StatusBadRequest, Reason: "channelIdNotUnique", Message: "Channel id " + channel.ID + " not unique"}
+	}
+	channel.ResourceID = "changes-" + channel.ID
+	channel.filter = filter
+	channel.queue = make(chan channelNotification, channelQueueSize)
+	s.channels[channel.ID] = channel
+	go channel.deliver()
+	s.notifyLocked(channel, "sync")
+	return channel, nil
+}
+
+func (s *driveStore) stopChannel(id, resourceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channel := s.channels[id]
+	if channel == nil || channel.ResourceID != resourceID {
+		return &driveAPIError{Code: http.StatusNotFound, Reason: "notFound", Message: "Channel '" + id + "' not found for project"}
+	}
+	close(channel.queue)
+	delete(s.channels, id)
+	return nil
+}
+
+func (s *driveStore) parseChangeTokenLocked(token string) (int, error) {
+	if token == "" {
+		return 0, requiredParameter("pageToken")
+	}
+	position, err := strconv.Atoi(token)
+	if err != nil || position < 1 || position > len(s.changes)+1 {
+		return 0, invalidParameter("pageToken", token)
+	}
+	return position, nil
+}
+
+func (f changeFilter) matches(change *driveChange) bool {
+	if change.Removed && !f.includeRemoved {
+		return false
+	}
+	if f.driveID != "" {
+		return change.driveID == f.driveID
+	}
+	if change.driveID != "" {
+		return f.allDrives && !f.restrictToMyDrive
+	}
+	return true
+}
+
+// deliver posts the channel's notifications in order until the channel is
+// stopped. Delivery failures are logged, as Drive does not retry them
+// either.
+func (c *changeChannel) deliver() {
+	client := &http.Client{Timeout: 5 * time.Second}
+	for notification := range c.queue {
+		request, err := http.NewRequest(http.MethodPost, c.Address, nil)
+		if err != nil {
+			log.Printf("[fake-drive] channel %s: %v", c.ID, err)
+			continue
+		}
+		request.Header.Set("X-Goog-Channel-ID", c.ID)
+		request.Header.Set("X-Goog-Channel-Expiration", c.Expiration.UTC().Format(http.TimeFormat))
+		request.Header.Set("X-Goog-Resource-ID", c.ResourceID)
+		request.Header.Set("X-Goog-Resource-URI", c.ResourceURI)
+		request.Header.Set("X-Goog-Resource-State", notification.state)
+		request.Header.Set("X-Goog-Message-Number", strconv.Itoa(notification.number))
+		if c.Token != "" {
+			request.Header.Set("X-Goog-Channel-Token", c.Token)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			log.Printf("[fake-drive] channel %s: %v", c.ID, err)
+			continue
+		}
+		_ = response.Body.Close()
+	}
+}
+
 func cloneFile(file *driveFile) *driveFile {
 	if file == nil {
 		return nil
@@ -206,16 +1014,31 @@ func cloneFile(file *driveFile) *driveFile {
 	for key, value := range file.AppProperties {
 		appProperties[key] = value
 	}
+	var properties map[string]string
+	if file.Properties != nil {
+		properties = make(map[string]string, len(file.Properties))
+		for key, value := range file.Properties {
+			properties[key] = value
+		}
+	}
 	return &driveFile{
 		ID:            file.ID,
 		Name:          file.Name,
 		MimeType:      file.MimeType,
 		Parents:       parents,
 		AppProperties: appProperties,
+		Properties:    properties,
 		Content:       file.Content,
 		Version:       file.Version,
 		HeadRev:       file.HeadRev,
 		MD5Checksum:   file.MD5Checksum,
+		Trashed:       file.Trashed,
+		Starred:       file.Starred,
+		DriveID:       file.DriveID,
+		CreatedTime:   file.CreatedTime,
+		ModifiedTime:  file.ModifiedTime,
+		owner:         file.owner,
+		seq:           file.seq,
 	}
 }
 
@@ -291,22 +1114,40 @@ func newDriveHandler(store *driveStore) http.Handler {
 		}
 		switch r.Method {
 		case http.MethodGet:
-			q := r.URL.Query().Get("q")
-			parentID := extractParentID(q)
-			propertyKey, propertyValue := extractAppProperty(q)
-			files := store.list(parentID, propertyKey, propertyValue)
-			writeJSON(w, map[string]any{
-				"files": files,
-			})
-		case http.MethodPost:
-			var resource map[string]any
-			_ = json.NewDecoder(r.Body).Decode(&resource)
-			file, created := store.create(resource)
-			if !created {
+			query, err := parseDriveQuery(r.URL.Query().Get("q"))
+			if err != nil {
+				writeDriveError(w, err)
+				return
+			}
+			order, err := parseOrderBy(r.URL.Query().Get("orderBy"))
+			if err != nil {
+				writeDriveError(w, err)
+				return
+			}
+			files := store.list(query, requestUser(r).EmailAddress, order)
+			start, end, next, err := pageRange(r, len(files), defaultFilePageSize, maxFilePageSize)
+			if err != nil {
+				writeDriveError(w, err)
+				return
+			}
+			response := map[string]any{
+				"kind":             "drive#fileList",
+				"incompleteSearch": false,
+				"files":            files[start:end],
+			}
+			if next != "" {
+				response["nextPageToken"] = next
+			}
+			writeFields(w, r, response)
+		case http.MethodPost:
+			var resource map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&resource)
+			file, created := store.create(resource, requestUser(r))
+			if !created {
 				http.Error(w, "file already exists", http.StatusConflict)
 				return
 			}
-			writeJSON(w, file)
+			writeFields(w, r, file)
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
@@ -316,11 +1157,23 @@ func newDriveHandler(store *driveStore) http.Handler {
 		if allowCORS(w, r) {
 			return
 		}
-		id := strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")
+		id, subresource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/drive/v3/files/"), "/")
 		if id == "" {
 			http.NotFound(w, r)
 			return
 		}
+		if subresource != "" {
+			collection, itemID, _ := strings.Cut(subresource, "/")
+			switch collection {
+			case "revisions":
+				serveRevisions(w, r, store, id, itemID)
+			case "comments":
+				serveComments(w, r, store, id, itemID)
+			default:
+				http.NotFound(w, r)
+			}
+			return
+		}
 
 		switch r.Method {
 		case http.MethodGet:
@@ -336,7 +1189,7 @@ func newDriveHandler(store *driveStore) http.Handler {
 				return
 			}
 			w.Header().Set("ETag", driveFileETag(file))
-			writeJSON(w, file)
+			writeFields(w, r, file)
 		case http.MethodPatch:
 			var resource map[string]any
 			_ = json.NewDecoder(r.Body).Decode(&resource)
@@ -350,12 +1203,116 @@ func newDriveHandler(store *driveStore) http.Handler {
 				http.NotFound(w, r)
 				return
 			}
-			writeJSON(w, file)
+			writeFields(w, r, file)
+		case http.MethodDelete:
+			if !store.delete(id) {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 
+	mux.HandleFunc("/drive/v3/changes/startPageToken", func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, err := store.startPageToken(r.URL.Query().Get("driveId"))
+		if err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		writeFields(w, r, map[string]any{"kind": "drive#startPageToken", "startPageToken": token})
+	})
+	mux.HandleFunc("/drive/v3/changes", func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		filter, err := parseChangeFilter(query)
+		if err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		pageSize := defaultChangePageSize
+		if raw := query.Get("pageSize"); raw != "" {
+			if pageSize, err = strconv.Atoi(raw); err != nil || pageSize < 1 || pageSize > maxChangePageSize {
+				writeDriveError(w, invalidParameter("pageSize", raw))
+				return
+			}
+		}
+		changes, next, newStart, err := store.listChanges(query.Get("pageToken"), filter, pageSize)
+		if err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		response := map[string]any{"kind": "drive#changeList", "changes": changes}
+		if next != "" {
+			response["nextPageToken"] = next
+		} else {
+			response["newStartPageToken"] = newStart
+		}
+		writeFields(w, r, response)
+	})
+	mux.HandleFunc("/drive/v3/changes/watch", func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		filter, err := parseChangeFilter(query)
+		if err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		channel, err := parseWatchChannel(decodeResource(r), store.now())
+		if err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		channel.ResourceURI = "http://" + r.Host + "/drive/v3/changes?" + query.Encode()
+		if channel, err = store.watchChanges(query.Get("pageToken"), filter, channel); err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		writeJSON(w, map[string]any{
+			"kind":        "api#channel",
+			"id":          channel.ID,
+			"resourceId":  channel.ResourceID,
+			"resourceUri": channel.ResourceURI,
+			"token":       channel.Token,
+			"expiration":  strconv.FormatInt(channel.Expiration.UnixMilli(), 10),
+		})
+	})
+	mux.HandleFunc("/drive/v3/channels/stop", func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resource := decodeResource(r)
+		if err := store.stopChannel(stringValue(resource["id"], ""), stringValue(resource["resourceId"], "")); err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
 	mux.HandleFunc("/upload/drive/v3/files/", func(w http.ResponseWriter, r *http.Request) {
 		if allowCORS(w, r) {
 			return
@@ -374,6 +1331,7 @@ func newDriveHandler(store *driveStore) http.Handler {
 			id,
 			string(body),
 			r.Header.Get("If-Match"),
+			requestUser(r),
 		)
 		if !ok {
 			http.NotFound(w, r)
@@ -384,12 +1342,467 @@ func newDriveHandler(store *driveStore) http.Handler {
 			return
 		}
 		w.Header().Set("ETag", driveFileETag(file))
-		writeJSON(w, file)
+		writeFields(w, r, file)
 	})
 
 	return mux
 }
 
+// serveRevisions serves revisions.list when revisionID is empty, and
+// revisions.get (including alt=media), update, and delete otherwise.
+func serveRevisions(w http.ResponseWriter, r *http.Request, store *driveStore, fileID, revisionID string) {
+	viewer := requestUser(r)
+	if revisionID == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		revisions, err := store.listRevisions(fileID)
+		if err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		start, end, next, err := pageRange(r, len(revisions), defaultRevisionPageSize, maxRevisionPageSize)
+		if err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		page := revisions[start:end]
+		for _, revision := range page {
+			revision.LastModifyingUser = revision.LastModifyingUser.seenBy(viewer)
+		}
+		response := map[string]any{"kind": "drive#revisionList", "revisions": page}
+		if next != "" {
+			response["nextPageToken"] = next
+		}
+		writeFields(w, r, response)
+		return
+	}
+
+	var (
+		revision *driveRevision
+		err      error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		revision, err = store.getRevision(fileID, revisionID)
+		if err == nil && r.URL.Query().Get("alt") == "media" {
+			w.Header().Set("Content-Type", revision.MimeType)
+			_, _ = w.Write([]byte(revision.Content))
+			return
+		}
+	case http.MethodPatch:
+		var resource map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&resource)
+		revision, err = store.updateRevision(fileID, revisionID, resource)
+	case http.MethodDelete:
+		if err := store.deleteRevision(fileID, revisionID); err != nil {
+			writeDriveError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeDriveError(w, err)
+		return
+	}
+	revision.LastModifyingUser = revision.LastModifyingUser.seenBy(viewer)
+	writeFields(w, r, revision)
+}
+
+// serveComments serves comments and, below a comment, its replies. Like
+// Drive, every method that returns a resource requires fields.
+func serveComments(w http.ResponseWriter, r *http.Request, store *driveStore, fileID, path string) {
+	viewer := requestUser(r)
+	query := r.URL.Query()
+	if r.Method != http.MethodDelete && query.Get("fields") == "" {
+		writeDriveError(w, &driveAPIError{Code: http.StatusBadRequest, Reason: "required", Message: "The 'fields' parameter is required for this method."})
+		return
+	}
+	includeDeleted := query.Get("includeDeleted") == "true"
+	commentID, rest, _ := strings.Cut(path, "/")
+	collection, replyID, _ := strings.Cut(rest, "/")
+	if rest != "" && collection != "replies" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var (
+		result any
+		err    error
+	)
+	switch {
+	case commentID == "" && r.Method == http.MethodGet:
+		var since time.Time
+		if raw := query.Get("startModifiedTime"); raw != "" {
+			if since, err = time.Parse(time.RFC3339, raw); err != nil {
+				writeDriveError(w, invalidParameter("startModifiedTime", raw))
+				return
+			}
+		}
+		var comments []*driveComment
+		if comments, err = store.listComments(fileID, includeDeleted, since); err == nil {
+			result, err = commentPage(r, "comments", comments, func(comment *driveComment) { comment.seenBy(viewer) })
+		}
+	case commentID == "" && r.Method == http.MethodPost:
+		var comment *driveComment
+		if comment, err = store.createComment(fileID, decodeResource(r), viewer); err == nil {
+			comment.seenBy(viewer)
+			result = comment
+		}
+	case commentID == "":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	case rest == "":
+		var comment *driveComment
+		switch r.Method {
+		case http.MethodGet:
+			comment, err = store.getComment(fileID, commentID, includeDeleted)
+		case http.MethodPatch:
+			comment, err = store.updateComment(fileID, commentID, decodeResource(r), viewer)
+		case http.MethodDelete:
+			err = store.deleteComment(fileID, commentID, viewer)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if comment != nil {
+			comment.seenBy(viewer)
+			result = comment
+		}
+	case replyID == "" && r.Method == http.MethodGet:
+		var replies []*driveReply
+		if replies, err = store.listReplies(fileID, commentID, includeDeleted); err == nil {
+			result, err = commentPage(r, "replies", replies, func(reply *driveReply) { reply.Author = reply.Author.seenBy(viewer) })
+		}
+	case replyID == "" && r.Method == http.MethodPost:
+		var reply *driveReply
+		if reply, err = store.createReply(fileID, commentID, decodeResource(r), viewer); err == nil {
+			reply.Author = reply.Author.seenBy(viewer)
+			result = reply
+		}
+	case replyID == "":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		var reply *driveReply
+		switch r.Method {
+		case http.MethodGet:
+			reply, err = store.getReply(fileID, commentID, replyID, includeDeleted)
+		case http.MethodPatch:
+			reply, err = store.updateReply(fileID, commentID, replyID, decodeResource(r), viewer)
+		case http.MethodDelete:
+			err = store.deleteReply(fileID, commentID, replyID, viewer)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if reply != nil {
+			reply.Author = reply.Author.seenBy(viewer)
+			result = reply
+		}
+	}
+
+	if err != nil {
+		writeDriveError(w, err)
+		return
+	}
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeFields(w, r, result)
+}
+
+// commentPage returns the requested page of items as a comment or reply
+// list, after applying view to each item on the page.
+func commentPage[T any](r *http.Request, key string, items []T, view func(T)) (map[string]any, error) {
+	start, end, next, err := pageRange(r, len(items), defaultCommentPageSize, maxCommentPageSize)
+	if err != nil {
+		return nil, err
+	}
+	page := items[start:end]
+	for _, item := range page {
+		view(item)
+	}
+	kind := "drive#commentList"
+	if key == "replies" {
+		kind = "drive#replyList"
+	}
+	response := map[string]any{"kind": kind, key: page}
+	if next != "" {
+		response["nextPageToken"] = next
+	}
+	return response, nil
+}
+
+// seenBy sets me on the comment's and its replies' authors for viewer.
+func (c *driveComment) seenBy(viewer driveUser) {
+	c.Author = c.Author.seenBy(viewer)
+	for _, reply := range c.Replies {
+		reply.Author = reply.Author.seenBy(viewer)
+	}
+}
+
+func decodeResource(r *http.Request) map[string]any {
+	var resource map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&resource)
+	return resource
+}
+
+// parseChangeFilter reads the changes.list and changes.watch parameters
+// that select changes. Like Drive, shared drive items need
+// supportsAllDrives.
+func parseChangeFilter(query url.Values) (changeFilter, error) {
+	filter := changeFilter{
+		driveID:           query.Get("driveId"),
+		includeRemoved:    query.Get("includeRemoved") != "false",
+		restrictToMyDrive: query.Get("restrictToMyDrive") == "true",
+		allDrives:         query.Get("includeItemsFromAllDrives") == "true",
+	}
+	supportsAllDrives := query.Get("supportsAllDrives") == "true"
+	if (filter.allDrives || filter.driveID != "") && !supportsAllDrives {
+		return changeFilter{}, &driveAPIError{Code: http.StatusForbidden, Reason: "teamDrivesParameterNotSupported", Message: "Shared drive parameters require supportsAllDrives=true."}
+	}
+	if filter.driveID != "" && filter.restrictToMyDrive {
+		return changeFilter{}, invalidParameter("restrictToMyDrive", "true")
+	}
+	return filter, nil
+}
+
+// parseWatchChannel reads a changes.watch request body. The fake only posts
+// to loopback addresses, so a test cannot make it call out.
+func parseWatchChannel(resource map[string]any, now time.Time) (*changeChannel, error) {
+	channel := &changeChannel{
+		ID:         stringValue(resource["id"], ""),
+		Token:      stringValue(resource["token"], ""),
+		Address:    stringValue(resource["address"], ""),
+		Expiration: now.Add(defaultChannelLifetime),
+	}
+	if channel.ID == "" {
+		return nil, requiredParameter("id")
+	}
+	if kind := stringValue(resource["type"], ""); kind != "web_hook" && kind != "webhook" {
+		return nil, invalidParameter("type", kind)
+	}
+	address, err := url.Parse(channel.Address)
+	if err != nil || (address.Scheme != "http" && address.Scheme != "https") || !isLoopbackHost(address.Hostname()) {
+		return nil, invalidParameter("address", channel.Address)
+	}
+	if raw := stringValue(resource["expiration"], ""); raw != "" {
+		millis, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || millis <= now.UnixMilli() {
+			return nil, invalidParameter("expiration", raw)
+		}
+		channel.Expiration = time.UnixMilli(millis)
+	}
+	return channel, nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// pageRange reads pageSize and pageToken from r and returns the bounds of
+// the requested page of total items, and the token for the page after it.
+// Like Drive's, tokens are opaque and only valid for a request to the same
+// path with the same parameters; pageSize and fields may change between
+// pages.
+func pageRange(r *http.Request, total, defaultSize, maxSize int) (start, end int, next string, err error) {
+	query := r.URL.Query()
+	size := defaultSize
+	if raw := query.Get("pageSize"); raw != "" {
+		size, err = strconv.Atoi(raw)
+		if err != nil || size < 1 || size > maxSize {
+			return 0, 0, "", invalidParameter("pageSize", raw)
+		}
+	}
+	scope := pageScope(r)
+	if token := query.Get("pageToken"); token != "" {
+		decoded, decodeErr := base64.RawURLEncoding.DecodeString(token)
+		offset, tokenScope, _ := strings.Cut(string(decoded), ":")
+		start, err = strconv.Atoi(offset)
+		if decodeErr != nil || tokenScope != scope || err != nil || start < 0 || start > total {
+			return 0, 0, "", invalidParameter("pageToken", token)
+		}
+	}
+	end = min(start+size, total)
+	if end < total {
+		next = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end) + ":" + scope))
+	}
+	return start, end, next, nil
+}
+
+// pageScope digests the path and the parameters that select and order a
+// list, binding page tokens to them.
+func pageScope(r *http.Request) string {
+	scoped := url.Values{}
+	for key, values := range r.URL.Query() {
+		switch key {
+		case "pageToken", "pageSize", "fields":
+		default:
+			scoped[key] = values
+		}
+	}
+	sum := md5.Sum([]byte(r.URL.Path + "?" + scoped.Encode()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// fileOrder is one orderBy key of files.list.
+type fileOrder struct {
+	key  string
+	desc bool
+}
+
+// parseOrderBy parses a files.list orderBy such as "folder,name_natural" or
+// "modifiedTime desc". Keys the fake cannot sort by are rejected.
+func parseOrderBy(raw string) ([]fileOrder, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var order []fileOrder
+	for _, part := range strings.Split(raw, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 || (len(fields) == 2 && fields[1] != "asc" && fields[1] != "desc") {
+			return nil, invalidParameter("orderBy", raw)
+		}
+		switch fields[0] {
+		case "createdTime", "folder", "modifiedTime", "name", "name_natural", "starred":
+		default:
+			return nil, invalidParameter("orderBy", raw)
+		}
+		order = append(order, fileOrder{key: fields[0], desc: len(fields) == 2 && fields[1] == "desc"})
+	}
+	return order, nil
+}
+
+// sortFiles sorts files by order, falling back to creation order so every
+// listing is deterministic. Folders and starred files sort first for
+// ascending folder and starred keys, as in Drive.
+func sortFiles(files []*driveFile, order []fileOrder) {
+	slices.SortStableFunc(files, func(a, b *driveFile) int {
+		for _, key := range order {
+			var c int
+			switch key.key {
+			case "createdTime":
+				c = compareDriveTimes(a.CreatedTime, b.CreatedTime)
+			case "modifiedTime":
+				c = compareDriveTimes(a.ModifiedTime, b.ModifiedTime)
+			case "folder":
+				c = compareBool(a.MimeType == driveFolderMime, b.MimeType == driveFolderMime)
+			case "starred":
+				c = compareBool(a.Starred, b.Starred)
+			case "name":
+				c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+			case "name_natural":
+				c = compareNatural(strings.ToLower(a.Name), strings.ToLower(b.Name))
+			}
+			if key.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return a.seq - b.seq
+	})
+}
+
+func compareDriveTimes(a, b string) int {
+	at, _ := time.Parse(time.RFC3339, a)
+	bt, _ := time.Parse(time.RFC3339, b)
+	return at.Compare(bt)
+}
+
+// compareBool orders true before false.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// compareNatural compares strings with runs of digits compared by value,
+// so "notebook 2" sorts before "notebook 10".
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		aDigits, bDigits := leadingDigits(a), leadingDigits(b)
+		if aDigits > 0 && bDigits > 0 {
+			aNumber := strings.TrimLeft(a[:aDigits], "0")
+			bNumber := strings.TrimLeft(b[:bDigits], "0")
+			if c := cmp.Compare(len(aNumber), len(bNumber)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(aNumber, bNumber); c != 0 {
+				return c
+			}
+			a, b = a[aDigits:], b[bDigits:]
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func leadingDigits(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+func newDriveUser(email, displayName string) driveUser {
+	if displayName == "" {
+		displayName, _, _ = strings.Cut(email, "@")
+	}
+	return driveUser{Kind: "drive#user", DisplayName: displayName, EmailAddress: email}
+}
+
+// requestUser identifies the caller from the bearer token. Tokens minted by
+// cuj-oidc-server are JWTs whose email and name claims name the user; any
+// other token is the default fake user.
+func requestUser(r *http.Request) driveUser {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if parts := strings.Split(token, "."); len(parts) == 3 {
+		if payload, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+			var claims struct {
+				Email string `json:"email"`
+				Name  string `json:"name"`
+			}
+			if json.Unmarshal(payload, &claims) == nil && claims.Email != "" {
+				return newDriveUser(claims.Email, claims.Name)
+			}
+		}
+	}
+	return newDriveUser(defaultUserEmail, "")
+}
+
+// seenBy returns a copy of u with me set for viewer.
+func (u *driveUser) seenBy(viewer driveUser) *driveUser {
+	if u == nil {
+		return nil
+	}
+	user := *u
+	user.Me = user.EmailAddress == viewer.EmailAddress
+	return &user
+}
+
 func main() {
 	host := envOrDefault("CUJ_DRIVE_FAKE_HOST", defaultDriveHost)
 	port := envOrDefault("CUJ_DRIVE_FAKE_PORT", defaultDrivePort)
@@ -407,7 +1820,7 @@ func allowCORS(w http.ResponseWriter, r *http.Request) bool {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match")
 	w.Header().Set("Access-Control-Expose-Headers", "ETag")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusNoContent)
 		return true
@@ -422,25 +1835,631 @@ func writeJSON(w http.ResponseWriter, value any) {
 	}
 }
 
-var parentQueryPattern = regexp.MustCompile(`'([^']+)' in parents`)
-var appPropertyQueryPattern = regexp.MustCompile(
-	`appProperties has \{ key='([^']+)' and value='([^']*)' \}`,
+// writeDriveError writes err in the error shape of the Drive API. Errors
+// that are not a driveAPIError are internal errors.
+func writeDriveError(w http.ResponseWriter, err error) {
+	var apiErr *driveAPIError
+	if !errors.As(err, &apiErr) {
+		apiErr = &driveAPIError{Code: http.StatusInternalServerError, Reason: "internalError", Message: err.Error()}
+	}
+	detail := map[string]string{
+		"domain":  "global",
+		"reason":  apiErr.Reason,
+		"message": apiErr.Message,
+	}
+	if apiErr.Location != "" {
+		detail["locationType"] = "parameter"
+		detail["location"] = apiErr.Location
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Code)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    apiErr.Code,
+			"message": apiErr.Message,
+			"errors":  []map[string]string{detail},
+		},
+	})
+}
+
+// fieldMask is a parsed partial-response selector such as
+// "nextPageToken,comments(id,author(displayName))". Each key selects a
+// member; a nil value selects the whole member, and "*" selects every
+// member.
+type fieldMask map[string]fieldMask
+
+// writeFields writes value as JSON projected onto the request's fields
+// parameter; without one, value is written whole.
+func writeFields(w http.ResponseWriter, r *http.Request, value any) {
+	raw := r.URL.Query().Get("fields")
+	mask, err := parseFieldMask(raw)
+	if err != nil {
+		writeDriveError(w, &driveAPIError{Code: http.StatusBadRequest, Reason: "invalidParameter", Message: "Invalid field selection " + raw})
+		return
+	}
+	if mask == nil {
+		writeJSON(w, value)
+		return
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		writeDriveError(w, err)
+		return
+	}
+	var decoded any
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		writeDriveError(w, err)
+		return
+	}
+	writeJSON(w, mask.project(decoded))
+}
+
+func parseFieldMask(fields string) (fieldMask, error) {
+	if strings.TrimSpace(fields) == "" {
+		return nil, nil
+	}
+	parser := &fieldMaskParser{input: fields}
+	mask, err := parser.list()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.input) {
+		return nil, fmt.Errorf("unexpected %q at %d", parser.input[parser.pos], parser.pos)
+	}
+	return mask, nil
+}
+
+type fieldMaskParser struct {
+	input string
+	pos   int
+}
+
+// list parses selectors separated by commas.
+func (p *fieldMaskParser) list() (fieldMask, error) {
+	mask := fieldMask{}
+	for {
+		if err := p.selector(mask); err != nil {
+			return nil, err
+		}
+		if !p.consume(',') {
+			return mask, nil
+		}
+	}
+}
+
+// selector parses a path like a/b/c, optionally followed by a
+// parenthesized sub-selection, and adds it to mask.
+func (p *fieldMaskParser) selector(mask fieldMask) error {
+	var path []string
+	for {
+		p.skipSpace()
+		start := p.pos
+		for p.pos < len(p.input) && (isFieldNameByte(p.input[p.pos]) || p.input[p.pos] == '*') {
+			p.pos++
+		}
+		if start == p.pos {
+			return fmt.Errorf("expected a field name at %d", p.pos)
+		}
+		path = append(path, p.input[start:p.pos])
+		if !p.consume('/') {
+			break
+		}
+	}
+	var sub fieldMask
+	if p.consume('(') {
+		var err error
+		if sub, err = p.list(); err != nil {
+			return err
+		}
+		if !p.consume(')') {
+			return fmt.Errorf("expected ')' at %d", p.pos)
+		}
+	}
+	mask.add(path, sub)
+	return nil
+}
+
+func (p *fieldMaskParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *fieldMaskParser) skipSpace() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func isFieldNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// add selects path in m, narrowed to sub when sub is not nil.
+func (m fieldMask) add(path []string, sub fieldMask) {
+	name := path[0]
+	existing, seen := m[name]
+	if seen && existing == nil {
+		return
+	}
+	if len(path) > 1 {
+		if !seen {
+			existing = fieldMask{}
+			m[name] = existing
+		}
+		existing.add(path[1:], sub)
+		return
+	}
+	if sub == nil || !seen {
+		m[name] = sub
+		return
+	}
+	for key, value := range sub {
+		existing.add([]string{key}, value)
+	}
+}
+
+// project returns the members of decoded JSON that m selects. Lists are
+// projected element by element.
+func (m fieldMask) project(value any) any {
+	if m == nil {
+		return value
+	}
+	switch typed := value.(type) {
+	case map[string]any:
+		out := map[string]any{}
+		for key, member := range typed {
+			if sub, ok := m[key]; ok {
+				out[key] = sub.project(member)
+			} else if sub, ok := m["*"]; ok {
+				out[key] = sub.project(member)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, len(typed))
+		for i, item := range typed {
+			out[i] = m.project(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// driveQuery reports whether a file matches a files.list q for the viewer
+// with the given email.
+type driveQuery func(file *driveFile, viewer string) bool
+
+type queryTokenKind int
+
+const (
+	queryEOF queryTokenKind = iota
+	queryString
+	queryWord
+	queryOperator
+	queryPunct
 )
 
-func extractParentID(query string) string {
-	matches := parentQueryPattern.FindStringSubmatch(query)
-	if len(matches) == 2 {
-		return matches[1]
+type queryToken struct {
+	kind queryTokenKind
+	text string
+	pos  int
+}
+
+// invalidQuery is the 400 Drive returns for a q it cannot parse. The fake
+// also returns it for valid Drive syntax it cannot evaluate faithfully, so
+// a test never passes on a filter that was silently ignored.
+func invalidQuery(format string, args ...any) error {
+	return &driveAPIError{Code: http.StatusBadRequest, Reason: "invalid", Message: "Invalid Value: " + fmt.Sprintf(format, args...), Location: "q"}
+}
+
+// lexDriveQuery splits q into string literals, words, comparison
+// operators, and the punctuation ( ) { }.
+func lexDriveQuery(q string) ([]queryToken, error) {
+	var tokens []queryToken
+	for pos := 0; pos < len(q); {
+		c := q[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+		case c == '\'':
+			var value strings.Builder
+			start := pos
+			for pos++; ; pos++ {
+				if pos >= len(q) {
+					return nil, invalidQuery("unterminated string at %d", start)
+				}
+				if q[pos] == '\\' && pos+1 < len(q) {
+					pos++
+				} else if q[pos] == '\'' {
+					break
+				}
+				value.WriteByte(q[pos])
+			}
+			pos++
+			tokens = append(tokens, queryToken{kind: queryString, text: value.String(), pos: start})
+		case strings.IndexByte("(){}", c) >= 0:
+			tokens = append(tokens, queryToken{kind: queryPunct, text: string(c), pos: pos})
+			pos++
+		case strings.IndexByte("=!<>", c) >= 0:
+			start := pos
+			pos++
+			if pos < len(q) && q[pos] == '=' {
+				pos++
+			}
+			if op := q[start:pos]; op == "!" {
+				return nil, invalidQuery("unexpected '!' at %d", start)
+			}
+			tokens = append(tokens, queryToken{kind: queryOperator, text: q[start:pos], pos: start})
+		case isFieldNameByte(c):
+			start := pos
+			for pos < len(q) && (isFieldNameByte(q[pos]) || q[pos] == '.') {
+				pos++
+			}
+			tokens = append(tokens, queryToken{kind: queryWord, text: q[start:pos], pos: start})
+		default:
+			return nil, invalidQuery("unexpected %q at %d", c, pos)
+		}
 	}
-	return ""
+	return append(tokens, queryToken{kind: queryEOF, pos: len(q)}), nil
+}
+
+// parseDriveQuery parses the Drive v3 search grammar:
+//
+//	query   = or
+//	or      = and { "or" and }
+//	and     = not { "and" not }
+//	not     = "not" not | "(" query ")" | term
+//	term    = field op value | string "in" field | field "has" "{" key "}" | "sharedWithMe"
+//
+// An empty q matches every file.
+func parseDriveQuery(q string) (driveQuery, error) {
+	if strings.TrimSpace(q) == "" {
+		return func(*driveFile, string) bool { return true }, nil
+	}
+	tokens, err := lexDriveQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	parser := &queryParser{tokens: tokens}
+	query, err := parser.or()
+	if err != nil {
+		return nil, err
+	}
+	if next := parser.peek(); next.kind != queryEOF {
+		return nil, invalidQuery("unexpected %q at %d", next.text, next.pos)
+	}
+	return query, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	token := p.tokens[p.pos]
+	if token.kind != queryEOF {
+		p.pos++
+	}
+	return token
+}
+
+// keyword consumes the next token if it is the keyword word, which Drive
+// matches case-insensitively.
+func (p *queryParser) keyword(word string) bool {
+	if token := p.peek(); token.kind == queryWord && strings.EqualFold(token.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expect(kind queryTokenKind, text string) error {
+	token := p.next()
+	if token.kind != kind || (text != "" && !strings.EqualFold(token.text, text)) {
+		return invalidQuery("expected %q at %d", text, token.pos)
+	}
+	return nil
+}
+
+func (p *queryParser) or() (driveQuery, error) {
+	left, err := p.and()
+	for err == nil && p.keyword("or") {
+		var right driveQuery
+		if right, err = p.and(); err == nil {
+			left = orQuery(left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) and() (driveQuery, error) {
+	left, err := p.not()
+	for err == nil && p.keyword("and") {
+		var right driveQuery
+		if right, err = p.not(); err == nil {
+			left = andQuery(left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) not() (driveQuery, error) {
+	if p.keyword("not") {
+		inner, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(file *driveFile, viewer string) bool { return !inner(file, viewer) }, nil
+	}
+	if token := p.peek(); token.kind == queryPunct && token.text == "(" {
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(queryPunct, ")")
+	}
+	return p.term()
+}
+
+func orQuery(left, right driveQuery) driveQuery {
+	return func(file *driveFile, viewer string) bool { return left(file, viewer) || right(file, viewer) }
+}
+
+func andQuery(left, right driveQuery) driveQuery {
+	return func(file *driveFile, viewer string) bool { return left(file, viewer) && right(file, viewer) }
 }
 
-func extractAppProperty(query string) (string, string) {
-	matches := appPropertyQueryPattern.FindStringSubmatch(query)
-	if len(matches) == 3 {
-		return matches[1], matches[2]
+func (p *queryParser) term() (driveQuery, error) {
+	token := p.next()
+	switch token.kind {
+	case queryString:
+		if !p.keyword("in") {
+			return nil, invalidQuery("expected 'in' after %q at %d", token.text, token.pos)
+		}
+		field := p.next()
+		return membershipQuery(token.text, field)
+	case queryWord:
+	default:
+		return nil, invalidQuery("expected a search term at %d", token.pos)
+	}
+
+	field := token.text
+	switch field {
+	case "sharedWithMe":
+		// sharedWithMe may stand alone or be compared with a boolean.
+		if p.peek().kind != queryOperator {
+			return sharedWithMe, nil
+		}
+	case "properties", "appProperties":
+		return p.propertyTerm(field)
+	}
+
+	operator := p.next()
+	if operator.kind == queryWord && strings.EqualFold(operator.text, "contains") {
+		operator.text = "contains"
+	} else if operator.kind != queryOperator {
+		return nil, invalidQuery("expected an operator after %s at %d", field, operator.pos)
+	}
+	value := p.next()
+	if value.kind != queryString && value.kind != queryWord {
+		return nil, invalidQuery("expected a value after %s %s at %d", field, operator.text, value.pos)
+	}
+	return comparisonQuery(field, operator.text, value)
+}
+
+// propertyTerm parses the rest of `properties has { key='k' and value='v' }`.
+func (p *queryParser) propertyTerm(field string) (driveQuery, error) {
+	if !p.keyword("has") {
+		return nil, invalidQuery("expected 'has' after %s", field)
+	}
+	if err := p.expect(queryPunct, "{"); err != nil {
+		return nil, err
+	}
+	key, err := p.namedString("key")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(queryWord, "and"); err != nil {
+		return nil, err
 	}
-	return "", ""
+	value, err := p.namedString("value")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(queryPunct, "}"); err != nil {
+		return nil, err
+	}
+	return func(file *driveFile, _ string) bool {
+		properties := file.Properties
+		if field == "appProperties" {
+			properties = file.AppProperties
+		}
+		actual, ok := properties[key]
+		return ok && actual == value
+	}, nil
+}
+
+// namedString parses `name = 'string'` inside a has clause.
+func (p *queryParser) namedString(name string) (string, error) {
+	if err := p.expect(queryWord, name); err != nil {
+		return "", err
+	}
+	if err := p.expect(queryOperator, "="); err != nil {
+		return "", err
+	}
+	value := p.next()
+	if value.kind != queryString {
+		return "", invalidQuery("expected a string after %s = at %d", name, value.pos)
+	}
+	return value.text, nil
+}
+
+// membershipQuery builds `'value' in field`. The fake has no sharing, so
+// it supports the collections it can answer: parents and owners.
+func membershipQuery(value string, field queryToken) (driveQuery, error) {
+	switch field.text {
+	case "parents":
+		return func(file *driveFile, _ string) bool { return containsString(file.Parents, value) }, nil
+	case "owners":
+		return func(file *driveFile, viewer string) bool {
+			if value == "me" {
+				return file.owner == viewer
+			}
+			return file.owner == value
+		}, nil
+	case "writers", "readers":
+		return nil, invalidQuery("the fake Drive server does not model %s", field.text)
+	}
+	return nil, invalidQuery("unsupported collection %q at %d", field.text, field.pos)
+}
+
+func sharedWithMe(file *driveFile, viewer string) bool {
+	return file.DriveID == "" && file.owner != "" && file.owner != viewer
+}
+
+// comparisonQuery builds `field operator value`, checking the operator and
+// value type against what Drive allows for field.
+func comparisonQuery(field, operator string, value queryToken) (driveQuery, error) {
+	unsupported := func() error {
+		return invalidQuery("operator %s is not supported for %s", operator, field)
+	}
+	switch field {
+	case "name", "mimeType":
+		if value.kind != queryString {
+			return nil, invalidQuery("%s must be compared with a string", field)
+		}
+		get := func(file *driveFile) string { return file.Name }
+		contains := nameContains
+		if field == "mimeType" {
+			get = func(file *driveFile) string { return file.MimeType }
+			contains = strings.Contains
+		}
+		switch operator {
+		case "=":
+			return func(file *driveFile, _ string) bool { return get(file) == value.text }, nil
+		case "!=":
+			return func(file *driveFile, _ string) bool { return get(file) != value.text }, nil
+		case "contains":
+			return func(file *driveFile, _ string) bool { return contains(get(file), value.text) }, nil
+		}
+		return nil, unsupported()
+	case "fullText":
+		if operator != "contains" || value.kind != queryString {
+			return nil, unsupported()
+		}
+		return func(file *driveFile, _ string) bool { return fullTextContains(file, value.text) }, nil
+	case "trashed", "starred", "sharedWithMe":
+		want, err := strconv.ParseBool(value.text)
+		if value.kind != queryWord || err != nil {
+			return nil, invalidQuery("%s must be compared with true or false", field)
+		}
+		get := func(file *driveFile, viewer string) bool { return file.Trashed }
+		switch field {
+		case "starred":
+			get = func(file *driveFile, viewer string) bool { return file.Starred }
+		case "sharedWithMe":
+			get = sharedWithMe
+		}
+		switch operator {
+		case "=":
+			return func(file *driveFile, viewer string) bool { return get(file, viewer) == want }, nil
+		case "!=":
+			return func(file *driveFile, viewer string) bool { return get(file, viewer) != want }, nil
+		}
+		return nil, unsupported()
+	case "modifiedTime", "createdTime":
+		want, err := parseQueryTime(value)
+		if err != nil {
+			return nil, err
+		}
+		get := func(file *driveFile) string { return file.ModifiedTime }
+		if field == "createdTime" {
+			get = func(file *driveFile) string { return file.CreatedTime }
+		}
+		var compare func(int) bool
+		switch operator {
+		case "=":
+			compare = func(c int) bool { return c == 0 }
+		case "!=":
+			compare = func(c int) bool { return c != 0 }
+		case "<":
+			compare = func(c int) bool { return c < 0 }
+		case "<=":
+			compare = func(c int) bool { return c <= 0 }
+		case ">":
+			compare = func(c int) bool { return c > 0 }
+		case ">=":
+			compare = func(c int) bool { return c >= 0 }
+		default:
+			return nil, unsupported()
+		}
+		return func(file *driveFile, _ string) bool {
+			actual, err := time.Parse(time.RFC3339, get(file))
+			return err == nil && compare(actual.Compare(want))
+		}, nil
+	}
+	return nil, invalidQuery("unsupported field %q at %d", field, value.pos)
+}
+
+// parseQueryTime parses a date value; Drive takes RFC 3339 and assumes UTC
+// when the zone is left out.
+func parseQueryTime(value queryToken) (time.Time, error) {
+	if value.kind == queryString {
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+			if parsed, err := time.Parse(layout, value.text); err == nil {
+				return parsed, nil
+			}
+		}
+	}
+	return time.Time{}, invalidQuery("invalid date %q at %d", value.text, value.pos)
+}
+
+// nameContains matches like Drive's name contains: a case-insensitive
+// prefix of the name or of one of its words.
+func nameContains(name, prefix string) bool {
+	name, prefix = strings.ToLower(name), strings.ToLower(prefix)
+	if strings.HasPrefix(name, prefix) {
+		return true
+	}
+	for _, word := range queryWords(name) {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fullTextContains matches like Drive's fullText contains: every word of
+// text must be a whole word of the file's name or content.
+func fullTextContains(file *driveFile, text string) bool {
+	words := map[string]bool{}
+	for _, word := range queryWords(strings.ToLower(file.Name + " " + file.Content)) {
+		words[word] = true
+	}
+	wanted := queryWords(strings.ToLower(strings.Trim(text, `"`)))
+	for _, word := range wanted {
+		if !words[word] {
+			return false
+		}
+	}
+	return len(wanted) > 0
+}
+
+func queryWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+	})
 }
 
 func envOrDefault(key, fallback string) string {
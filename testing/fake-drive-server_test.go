@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -141,3 +144,426 @@ func TestConditionalContentUploadUsesVersionedETag(t *testing.T) {
 		t.Fatalf("unexpected stored content %q", content)
 	}
 }
+
+func TestRevisionsTrackContentWrites(t *testing.T) {
+	server := httptest.NewServer(newDriveHandler(newDriveStore()))
+	defer server.Close()
+
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"email":"editor@example.com","name":"Editor"}`))
+	token := "Bearer header." + claims + ".signature"
+	for _, content := range []string{"second", "third"} {
+		status, _ := driveRequest(t, http.MethodPatch, server.URL+"/upload/drive/v3/files/"+seedFileID+"?uploadType=media", content, token)
+		if status != http.StatusOK {
+			t.Fatalf("upload %q returned %d", content, status)
+		}
+	}
+
+	var page struct {
+		Revisions     []driveRevision `json:"revisions"`
+		NextPageToken string          `json:"nextPageToken"`
+	}
+	status, body := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files/"+seedFileID+"/revisions?pageSize=2", "", token)
+	if status != http.StatusOK {
+		t.Fatalf("revisions.list returned %d: %s", status, body)
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Revisions) != 2 || page.NextPageToken == "" || page.Revisions[0].ID != "rev-1" || page.Revisions[0].LastModifyingUser.Me {
+		t.Fatalf("first page = %s", body)
+	}
+	status, body = driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files/"+seedFileID+"/revisions?pageSize=2&pageToken="+page.NextPageToken, "", token)
+	page.NextPageToken = ""
+	if err := json.Unmarshal(body, &page); status != http.StatusOK || err != nil {
+		t.Fatalf("second page returned %d: %s", status, body)
+	}
+	head := page.Revisions[0]
+	if len(page.Revisions) != 1 || page.NextPageToken != "" || head.ID != "rev-3" || head.Size != "5" ||
+		head.LastModifyingUser.EmailAddress != "editor@example.com" || !head.LastModifyingUser.Me {
+		t.Fatalf("second page = %s", body)
+	}
+
+	if _, content := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files/"+seedFileID+"/revisions/rev-2?alt=media", "", ""); string(content) != "second" {
+		t.Fatalf("rev-2 content = %q", content)
+	}
+	status, body = driveRequest(t, http.MethodPatch, server.URL+"/drive/v3/files/"+seedFileID+"/revisions/rev-2", `{"keepForever":true}`, "")
+	if status != http.StatusOK || !strings.Contains(string(body), `"keepForever":true`) {
+		t.Fatalf("revisions.update returned %d: %s", status, body)
+	}
+
+	// Deleting the head revision restores the previous content.
+	if status, body := driveRequest(t, http.MethodDelete, server.URL+"/drive/v3/files/"+seedFileID+"/revisions/rev-3", "", ""); status != http.StatusNoContent {
+		t.Fatalf("revisions.delete returned %d: %s", status, body)
+	}
+	if _, content := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files/"+seedFileID+"?alt=media", "", ""); string(content) != "second" {
+		t.Fatalf("content after deleting head = %q", content)
+	}
+	status, body = driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files/"+seedFileID+"/revisions/rev-3", "", "")
+	if status != http.StatusNotFound || !strings.Contains(string(body), `"reason":"notFound"`) {
+		t.Fatalf("deleted revision returned %d: %s", status, body)
+	}
+}
+
+func driveRequest(t *testing.T, method, target, body, authorization string) (int, []byte) {
+	t.Helper()
+	request, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, content
+}
+
+func TestCommentsAndReplies(t *testing.T) {
+	server := httptest.NewServer(newDriveHandler(newDriveStore()))
+	defer server.Close()
+	commentsURL := server.URL + "/drive/v3/files/" + seedFileID + "/comments"
+	author := "Bearer header." + base64.RawURLEncoding.EncodeToString([]byte(`{"email":"author@example.com","name":"Author"}`)) + ".signature"
+
+	if status, body := driveRequest(t, http.MethodGet, commentsURL, "", author); status != http.StatusBadRequest || !strings.Contains(string(body), "fields") {
+		t.Fatalf("list without fields returned %d: %s", status, body)
+	}
+
+	var comment driveComment
+	status, body := driveRequest(t, http.MethodPost, commentsURL+"?fields=*",
+		`{"content":"check <this>","anchor":"{\"cell\":\"c1\"}","quotedFileContent":{"mimeType":"text/plain","value":"echo"}}`, author)
+	if err := json.Unmarshal(body, &comment); status != http.StatusOK || err != nil {
+		t.Fatalf("comments.create returned %d: %s", status, body)
+	}
+	if comment.HTMLContent != "check &lt;this&gt;" || comment.QuotedFileContent.Value != "echo" || !comment.Author.Me || comment.Author.DisplayName != "Author" {
+		t.Fatalf("created comment = %s", body)
+	}
+	commentURL := commentsURL + "/" + comment.ID
+
+	// Another user can reply and resolve, but not edit the comment.
+	if status, body := driveRequest(t, http.MethodPatch, commentURL+"?fields=id", `{"content":"hijacked"}`, ""); status != http.StatusForbidden {
+		t.Fatalf("update by another user returned %d: %s", status, body)
+	}
+	for _, resource := range []string{`{"content":"looks good"}`, `{"action":"resolve"}`} {
+		if status, body := driveRequest(t, http.MethodPost, commentURL+"/replies?fields=id,action", resource, ""); status != http.StatusOK {
+			t.Fatalf("replies.create %s returned %d: %s", resource, status, body)
+		}
+	}
+
+	status, body = driveRequest(t, http.MethodGet, commentsURL+"?fields=nextPageToken,comments(id,resolved,author(me),replies(action,content))", "", author)
+	if status != http.StatusOK {
+		t.Fatalf("comments.list returned %d: %s", status, body)
+	}
+	want := `{"comments":[{"author":{"me":true},"id":"` + comment.ID + `","replies":[{"content":"looks good"},{"action":"resolve"}],"resolved":true}]}`
+	if strings.TrimSpace(string(body)) != want {
+		t.Fatalf("comments.list = %s\nwant %s", body, want)
+	}
+
+	if status, body := driveRequest(t, http.MethodDelete, commentURL, "", author); status != http.StatusNoContent {
+		t.Fatalf("comments.delete returned %d: %s", status, body)
+	}
+	if status, _ := driveRequest(t, http.MethodGet, commentURL+"?fields=id", "", author); status != http.StatusNotFound {
+		t.Fatalf("deleted comment returned %d", status)
+	}
+	status, body = driveRequest(t, http.MethodGet, commentsURL+"?includeDeleted=true&fields=comments(deleted,content)", "", author)
+	if status != http.StatusOK || strings.TrimSpace(string(body)) != `{"comments":[{"deleted":true}]}` {
+		t.Fatalf("list with deleted returned %d: %s", status, body)
+	}
+}
+
+func TestFieldMaskProjection(t *testing.T) {
+	value := map[string]any{
+		"nextPageToken": "t",
+		"kind":          "drive#fileList",
+		"files": []any{
+			map[string]any{"id": "a", "name": "n", "owner": map[string]any{"me": true, "email": "e"}},
+		},
+	}
+	for fields, want := range map[string]string{
+		"nextPageToken,files(id,owner/me)": `{"files":[{"id":"a","owner":{"me":true}}],"nextPageToken":"t"}`,
+		"files/name, files(id)":            `{"files":[{"id":"a","name":"n"}]}`,
+		"files(owner(email),owner),kind":   `{"files":[{"owner":{"email":"e","me":true}}],"kind":"drive#fileList"}`,
+		"files(*)":                         `{"files":[{"id":"a","name":"n","owner":{"email":"e","me":true}}]}`,
+	} {
+		mask, err := parseFieldMask(fields)
+		if err != nil {
+			t.Fatalf("%s: %v", fields, err)
+		}
+		got, _ := json.Marshal(mask.project(value))
+		if string(got) != want {
+			t.Errorf("%s: got %s, want %s", fields, got, want)
+		}
+	}
+	for _, fields := range []string{"files(id", "files()", "a,,b", "files)"} {
+		if _, err := parseFieldMask(fields); err == nil {
+			t.Errorf("%q parsed", fields)
+		}
+	}
+}
+
+func TestChangesFeed(t *testing.T) {
+	notifications := make(chan http.Header, 16)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notifications <- r.Header.Clone()
+	}))
+	defer webhook.Close()
+	server := httptest.NewServer(newDriveHandler(newDriveStore()))
+	defer server.Close()
+
+	var start struct {
+		StartPageToken string `json:"startPageToken"`
+	}
+	_, body := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/changes/startPageToken", "", "")
+	if err := json.Unmarshal(body, &start); err != nil || start.StartPageToken == "" {
+		t.Fatalf("startPageToken = %s, %v", body, err)
+	}
+	status, body := driveRequest(t, http.MethodPost, server.URL+"/drive/v3/changes/watch?pageToken="+start.StartPageToken,
+		`{"id":"channel-1","type":"web_hook","address":"`+webhook.URL+`","token":"secret"}`, "")
+	if status != http.StatusOK || !strings.Contains(string(body), `"resourceId"`) {
+		t.Fatalf("changes.watch returned %d: %s", status, body)
+	}
+	if header := <-notifications; header.Get("X-Goog-Resource-State") != "sync" || header.Get("X-Goog-Channel-Token") != "secret" {
+		t.Fatalf("first notification = %v", header)
+	}
+
+	// One change each: create, metadata update, upload, trash, and delete;
+	// plus a file in the shared drive.
+	for _, step := range []struct{ method, path, body string }{
+		{http.MethodPost, "/drive/v3/files", `{"id":"doc","name":"doc.json","parents":["` + seedFolderID + `"]}`},
+		{http.MethodPatch, "/drive/v3/files/doc", `{"name":"renamed.json"}`},
+		{http.MethodPatch, "/upload/drive/v3/files/doc?uploadType=media", "content"},
+		{http.MethodPatch, "/drive/v3/files/doc", `{"trashed":true}`},
+		{http.MethodDelete, "/drive/v3/files/doc", ""},
+		{http.MethodPost, "/drive/v3/files", `{"id":"team-doc","name":"team.json","parents":["` + seedSharedDrive + `"]}`},
+	} {
+		if status, body := driveRequest(t, step.method, server.URL+step.path, step.body, ""); status >= 300 {
+			t.Fatalf("%s %s returned %d: %s", step.method, step.path, status, body)
+		}
+	}
+	for i := 2; i <= 6; i++ {
+		if header := <-notifications; header.Get("X-Goog-Resource-State") != "change" || header.Get("X-Goog-Message-Number") != strconv.Itoa(i) {
+			t.Fatalf("notification %d = %v", i, header)
+		}
+	}
+
+	type changeList struct {
+		Changes []struct {
+			FileID  string     `json:"fileId"`
+			Removed bool       `json:"removed"`
+			File    *driveFile `json:"file"`
+		} `json:"changes"`
+		NextPageToken     string `json:"nextPageToken"`
+		NewStartPageToken string `json:"newStartPageToken"`
+	}
+	list := func(query string) changeList {
+		t.Helper()
+		status, body := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/changes?"+query, "", "")
+		var changes changeList
+		if err := json.Unmarshal(body, &changes); status != http.StatusOK || err != nil {
+			t.Fatalf("changes.list?%s returned %d: %s", query, status, body)
+		}
+		return changes
+	}
+
+	first := list("pageSize=3&pageToken=" + start.StartPageToken)
+	if len(first.Changes) != 3 || first.NextPageToken == "" || first.Changes[1].File.Name != "renamed.json" {
+		t.Fatalf("first page = %+v", first)
+	}
+	rest := list("pageSize=3&pageToken=" + first.NextPageToken)
+	if len(rest.Changes) != 2 || !rest.Changes[0].File.Trashed || !rest.Changes[1].Removed || rest.NewStartPageToken == "" {
+		t.Fatalf("second page = %+v", rest)
+	}
+	if changes := list("includeRemoved=false&pageToken=" + start.StartPageToken); len(changes.Changes) != 4 {
+		t.Fatalf("without removed: %+v", changes)
+	}
+	if changes := list("includeItemsFromAllDrives=true&supportsAllDrives=true&pageToken=" + start.StartPageToken); len(changes.Changes) != 6 {
+		t.Fatalf("all drives: %+v", changes)
+	}
+	if changes := list("driveId=" + seedSharedDrive + "&supportsAllDrives=true&pageToken=" + start.StartPageToken); len(changes.Changes) != 1 || changes.Changes[0].File.DriveID != seedSharedDrive {
+		t.Fatalf("shared drive: %+v", changes)
+	}
+	if status, _ := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/changes?driveId="+seedSharedDrive+"&pageToken=1", "", ""); status != http.StatusForbidden {
+		t.Fatalf("driveId without supportsAllDrives returned %d", status)
+	}
+	if status, _ := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/changes?pageToken=999", "", ""); status != http.StatusBadRequest {
+		t.Fatalf("unknown page token returned %d", status)
+	}
+
+	if status, body := driveRequest(t, http.MethodPost, server.URL+"/drive/v3/channels/stop", `{"id":"channel-1","resourceId":"changes-channel-1"}`, ""); status != http.StatusNoContent {
+		t.Fatalf("channels.stop returned %d: %s", status, body)
+	}
+}
+
+func TestDriveQuery(t *testing.T) {
+	files := []*driveFile{
+		{ID: "notes", Name: "Release Notes.md", MimeType: "text/markdown", Parents: []string{"root"}, Content: "ship the runner today",
+			AppProperties: map[string]string{"kind": "notes"}, ModifiedTime: "2026-10-02T00:00:00.000Z", CreatedTime: "2026-10-01T00:00:00.000Z", owner: "me@example.com"},
+		{ID: "folder", Name: "Runbooks", MimeType: driveFolderMime, Parents: []string{"root"}, Starred: true,
+			ModifiedTime: "2026-10-05T00:00:00.000Z", CreatedTime: "2026-10-01T00:00:00.000Z", owner: "me@example.com"},
+		{ID: "shared", Name: "it's shared.json", MimeType: notebookJSONMime, Parents: []string{"other"}, Trashed: true,
+			Properties: map[string]string{"team": "web"}, ModifiedTime: "2026-10-03T00:00:00.000Z", CreatedTime: "2026-09-01T00:00:00.000Z", owner: "owner@example.com"},
+	}
+	for q, want := range map[string]string{
+		"":                                      "notes,folder,shared",
+		"'root' in parents and trashed = false": "notes,folder",
+		"name contains 'notes'":                 "notes",
+		"name contains 'otes'":                  "",
+		`name = 'it\'s shared.json'`:            "shared",
+		"mimeType = '" + driveFolderMime + "' or starred = true":                "folder",
+		"not mimeType contains 'json'":                                          "notes,folder",
+		"modifiedTime > '2026-10-02T00:00:00Z' and createdTime >= '2026-10-01'": "folder",
+		"fullText contains 'Runner'":                                            "notes",
+		"fullText contains 'run'":                                               "",
+		"appProperties has { key='kind' and value='notes' }":                    "notes",
+		"properties has { key='team' and value='web' } AND trashed = true":      "shared",
+		"sharedWithMe": "shared",
+		"sharedWithMe = false and 'me' in owners":                                       "notes,folder",
+		"('owner@example.com' in owners or 'other' in parents) and not trashed = false": "shared",
+	} {
+		query, err := parseDriveQuery(q)
+		if err != nil {
+			t.Errorf("%q: %v", q, err)
+			continue
+		}
+		var got []string
+		for _, file := range files {
+			if query(file, "me@example.com") {
+				got = append(got, file.ID)
+			}
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%q matched %v, want %s", q, got, want)
+		}
+	}
+
+	for _, q := range []string{
+		"name = ",
+		"'root' in parents and",
+		"trashed = 'false'",
+		"modifiedTime > 'yesterday'",
+		"name > 'a'",
+		"'me' in writers",
+		"visibility = 'anyoneCanFind'",
+		"(trashed = false",
+		"name = 'unterminated",
+	} {
+		if _, err := parseDriveQuery(q); err == nil {
+			t.Errorf("%q parsed", q)
+		}
+	}
+}
+
+func TestInvalidQueryReturnsDriveError(t *testing.T) {
+	server := httptest.NewServer(newDriveHandler(newDriveStore()))
+	defer server.Close()
+
+	status, body := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files?q="+url.QueryEscape("title = 'v2 syntax'"), "", "")
+	var response struct {
+		Error struct {
+			Code   int `json:"code"`
+			Errors []struct {
+				Reason   string `json:"reason"`
+				Location string `json:"location"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || status != http.StatusBadRequest {
+		t.Fatalf("invalid q returned %d: %s", status, body)
+	}
+	if response.Error.Code != http.StatusBadRequest || response.Error.Errors[0].Reason != "invalid" || response.Error.Errors[0].Location != "q" {
+		t.Fatalf("error body = %s", body)
+	}
+}
+
+func TestFilesListPagesInOrder(t *testing.T) {
+	server := httptest.NewServer(newDriveHandler(newDriveStore()))
+	defer server.Close()
+
+	for _, name := range []string{"notebook 10.json", "Notebook 2.json", "archive", "notebook 1.json"} {
+		mimeType := notebookJSONMime
+		if name == "archive" {
+			mimeType = driveFolderMime
+		}
+		body, _ := json.Marshal(map[string]any{"name": name, "mimeType": mimeType, "parents": []string{seedFolderID}})
+		if status, response := driveRequest(t, http.MethodPost, server.URL+"/drive/v3/files", string(body), ""); status != http.StatusOK {
+			t.Fatalf("create %s returned %d: %s", name, status, response)
+		}
+	}
+
+	list := url.Values{
+		"q":        {"'" + seedFolderID + "' in parents"},
+		"orderBy":  {"folder,name_natural desc"},
+		"pageSize": {"2"},
+		"fields":   {"nextPageToken,files(name)"},
+	}
+	var names []string
+	for page := 0; ; page++ {
+		status, body := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files?"+list.Encode(), "", "")
+		if status != http.StatusOK {
+			t.Fatalf("page %d returned %d: %s", page, status, body)
+		}
+		var response map[string]any
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range response["files"].([]any) {
+			if len(file.(map[string]any)) != 1 {
+				t.Fatalf("fields not applied: %s", body)
+			}
+			names = append(names, file.(map[string]any)["name"].(string))
+		}
+		next, _ := response["nextPageToken"].(string)
+		if next == "" {
+			break
+		}
+		if page == 0 {
+			// A token only continues the listing it came from.
+			changed := url.Values{"q": {"trashed = false"}, "pageToken": {next}}
+			if status, body := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files?"+changed.Encode(), "", ""); status != http.StatusBadRequest {
+				t.Fatalf("token reused with another q returned %d: %s", status, body)
+			}
+		}
+		list.Set("pageToken", next)
+	}
+	want := "archive," + seedFileName + ",notebook 10.json,Notebook 2.json,notebook 1.json"
+	if strings.Join(names, ",") != want {
+		t.Fatalf("listed %v, want %s", names, want)
+	}
+
+	// The app picks the oldest of duplicate uploads with an explicit asc.
+	byCreated := url.Values{
+		"q":       {"'" + seedFolderID + "' in parents"},
+		"orderBy": {"createdTime asc"},
+		"fields":  {"files(name)"},
+	}
+	status, body := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files?"+byCreated.Encode(), "", "")
+	if status != http.StatusOK {
+		t.Fatalf("createdTime asc returned %d: %s", status, body)
+	}
+	var created struct {
+		Files []struct {
+			Name string `json:"name"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil {
+		t.Fatal(err)
+	}
+	names = names[:0]
+	for _, file := range created.Files {
+		names = append(names, file.Name)
+	}
+	want = seedFileName + ",notebook 10.json,Notebook 2.json,archive,notebook 1.json"
+	if strings.Join(names, ",") != want {
+		t.Fatalf("createdTime asc listed %v, want %s", names, want)
+	}
+
+	if status, body := driveRequest(t, http.MethodGet, server.URL+"/drive/v3/files?orderBy=viewedByMeTime", "", ""); status != http.StatusBadRequest {
+		t.Fatalf("unsupported orderBy returned %d: %s", status, body)
+	}
+}